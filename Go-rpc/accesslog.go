@@ -0,0 +1,108 @@
+package gorpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry 访问日志的一条记录
+type AccessLogEntry struct {
+	Time          time.Time
+	Peer          string
+	ServiceMethod string
+	Seq           uint64
+	Error         error
+	// 写出的响应字节数
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Status 调用成功为 ok 失败为 error
+func (e *AccessLogEntry) Status() string {
+	if e.Error != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// AccessLogFormatter 将一条记录格式化为一行日志 不包含换行符
+type AccessLogFormatter func(e *AccessLogEntry) string
+
+// TextAccessLogFormatter 空格分隔的文本格式
+// 例如 2022-01-02T15:04:05.000Z 10.0.0.1:51234 Foo.Sum ok 12 1.2ms
+func TextAccessLogFormatter(e *AccessLogEntry) string {
+	peer := e.Peer
+	if peer == "" {
+		peer = "-"
+	}
+	line := fmt.Sprintf("%s %s %s %s %d %s", e.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		peer, e.ServiceMethod, e.Status(), e.Bytes, e.Duration)
+	if e.Error != nil {
+		line += fmt.Sprintf(" %q", e.Error.Error())
+	}
+	return line
+}
+
+// JSONAccessLogFormatter 每行一个JSON对象
+func JSONAccessLogFormatter(e *AccessLogEntry) string {
+	v := struct {
+		Time          time.Time `json:"ts"`
+		Peer          string    `json:"peer,omitempty"`
+		ServiceMethod string    `json:"method"`
+		Seq           uint64    `json:"seq"`
+		Status        string    `json:"status"`
+		Error         string    `json:"error,omitempty"`
+		Bytes         int64     `json:"bytes"`
+		Duration      float64   `json:"duration_ms"`
+	}{
+		Time:          e.Time,
+		Peer:          e.Peer,
+		ServiceMethod: e.ServiceMethod,
+		Seq:           e.Seq,
+		Status:        e.Status(),
+		Bytes:         e.Bytes,
+		Duration:      float64(e.Duration) / float64(time.Millisecond),
+	}
+	if e.Error != nil {
+		v.Error = e.Error.Error()
+	}
+	b, _ := json.Marshal(&v)
+	return string(b)
+}
+
+// AccessLog 访问日志中间件 每次调用向 w 写入一行
+// w 为nil时写入标准错误 format 为nil时使用 TextAccessLogFormatter
+// 例如 server.Use(gorpc.AccessLog(f, gorpc.JSONAccessLogFormatter))
+func AccessLog(w io.Writer, format AccessLogFormatter) Middleware {
+	if w == nil {
+		w = os.Stderr
+	}
+	if format == nil {
+		format = TextAccessLogFormatter
+	}
+	var mu sync.Mutex // protect w
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			start := time.Now()
+			err := next(ctx, call)
+			line := format(&AccessLogEntry{
+				Time:          start,
+				Peer:          call.Peer,
+				ServiceMethod: call.ServiceMethod,
+				Seq:           call.Seq,
+				Error:         err,
+				Bytes:         call.BytesWritten,
+				Duration:      time.Since(start),
+			})
+			mu.Lock()
+			_, _ = io.WriteString(w, line+"\n")
+			mu.Unlock()
+			return err
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gorpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEntry 一条审计记录 谁在什么时间调用了什么方法 结果如何
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// 已认证的调用方 未认证时为空
+	Identity      string        `json:"identity"`
+	ServiceMethod string        `json:"method"`
+	Peer          string        `json:"peer,omitempty"`
+	Result        string        `json:"result"`
+	Error         string        `json:"error,omitempty"`
+	Duration      time.Duration `json:"duration"`
+}
+
+// AuditSink 接收审计记录 实现需要并发安全
+type AuditSink func(e *AuditEntry)
+
+// JSONAuditSink 每条记录以一行JSON写入 w
+func JSONAuditSink(w io.Writer) AuditSink {
+	var mu sync.Mutex // protect w
+	return func(e *AuditEntry) {
+		line, _ := json.Marshal(e)
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(append(line, '\n'))
+	}
+}
+
+// Audit 审计中间件 记录 methods 中方法的调用 需要添加在认证中间件之后
+// methods 的格式同 FaultRule.Method 例如 Admin. 匹配 Admin 服务的所有方法 为空时记录所有方法
+// 调用方身份来自 IdentityFromContext 由认证中间件或双向TLS设置
+func Audit(sink AuditSink, methods ...string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			if !matchAny(methods, call.ServiceMethod) {
+				return next(ctx, call)
+			}
+			start := time.Now()
+			err := next(ctx, call)
+			e := &AuditEntry{
+				Time:          start,
+				ServiceMethod: call.ServiceMethod,
+				Peer:          call.Peer,
+				Result:        "ok",
+				Duration:      time.Since(start),
+			}
+			if id, ok := IdentityFromContext(ctx); ok {
+				e.Identity = id.Name
+			}
+			if err != nil {
+				e.Result, e.Error = "error", err.Error()
+			}
+			sink(e)
+			return err
+		}
+	}
+}
+
+// matchAny patterns 为空或任意一项匹配时返回true
+func matchAny(patterns []string, serviceMethod string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p != "" && matchMethod(p, serviceMethod) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package gorpc
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedCaller 带响应缓存的客户端 适用于读多写少的方法
+// 只有通过 SetMethodTTL 开启缓存的方法才会被缓存 以 方法名+参数 作为key
+// 只缓存成功的同步调用(Call) Go 直接透传
+type CachedCaller struct {
+	Caller
+	// 最多缓存的条目数 超过时淘汰最久未使用的条目
+	maxEntries int
+	mu         sync.Mutex // protect following
+	ttls       map[string]time.Duration
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+// cacheEntry 缓存条目 reply 以gob编码存储 防止调用方修改缓存内容
+type cacheEntry struct {
+	key      string
+	reply    []byte
+	expireAt time.Time
+}
+
+var _ Caller = (*CachedCaller)(nil)
+
+// NewCachedCaller 包装一个Caller maxEntries<=0 表示不限制条目数
+func NewCachedCaller(c Caller, maxEntries int) *CachedCaller {
+	return &CachedCaller{
+		Caller:     c,
+		maxEntries: maxEntries,
+		ttls:       make(map[string]time.Duration),
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// SetMethodTTL 为方法开启缓存 ttl<=0 关闭该方法的缓存
+func (c *CachedCaller) SetMethodTTL(serviceMethod string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl <= 0 {
+		delete(c.ttls, serviceMethod)
+		c.invalidateMethod(serviceMethod)
+		return
+	}
+	c.ttls[serviceMethod] = ttl
+}
+
+// cacheKey 方法名+参数的JSON编码
+func cacheKey(serviceMethod string, args interface{}) (string, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	return serviceMethod + "|" + string(b), nil
+}
+
+// Call 命中缓存时直接返回 否则调用后写入缓存
+func (c *CachedCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	c.mu.Lock()
+	ttl, ok := c.ttls[serviceMethod]
+	c.mu.Unlock()
+	if !ok || reply == nil {
+		return c.Caller.Call(ctx, serviceMethod, args, reply)
+	}
+	key, err := cacheKey(serviceMethod, args)
+	if err != nil {
+		return c.Caller.Call(ctx, serviceMethod, args, reply)
+	}
+	if data, hit := c.get(key); hit {
+		// gob不会写入零值字段 先清空reply
+		v := reflect.ValueOf(reply).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(reply); err == nil {
+			return nil
+		}
+	}
+	if err := c.Caller.Call(ctx, serviceMethod, args, reply); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(reply); err == nil {
+		c.put(key, buf.Bytes(), ttl)
+	}
+	return nil
+}
+
+func (c *CachedCaller) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.removeElement(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.reply, true
+}
+
+func (c *CachedCaller) put(key string, reply []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, reply: reply, expireAt: time.Now().Add(ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(entry)
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *CachedCaller) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// Invalidate 删除指定方法和参数的缓存
+func (c *CachedCaller) Invalidate(serviceMethod string, args interface{}) {
+	key, err := cacheKey(serviceMethod, args)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// InvalidateMethod 删除指定方法的全部缓存
+func (c *CachedCaller) InvalidateMethod(serviceMethod string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateMethod(serviceMethod)
+}
+
+func (c *CachedCaller) invalidateMethod(serviceMethod string) {
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, serviceMethod+"|") {
+			c.removeElement(e)
+		}
+	}
+}
+
+// Purge 清空缓存
+func (c *CachedCaller) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Len 当前缓存的条目数
+func (c *CachedCaller) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,49 @@
+package gorpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCaller 记录实际发出的调用次数
+type countingCaller struct {
+	Caller
+	calls int32
+}
+
+func (c *countingCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.Caller.Call(ctx, serviceMethod, args, reply)
+}
+
+func TestCachedCaller(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh)
+	counter := &countingCaller{Caller: client}
+	c := NewCachedCaller(counter, 2)
+	defer func() { _ = c.Close() }()
+	c.SetMethodTTL("Foo.Sum", time.Second)
+
+	call := func(n int) int {
+		var reply int
+		err := c.Call(context.Background(), "Foo.Sum", Args{Num1: n, Num2: n}, &reply)
+		_assert(err == nil, "failed to call Foo.Sum: %v", err)
+		return reply
+	}
+	_assert(call(1) == 2 && call(2) == 4 && c.Len() == 2, "expect 2 cached entries")
+	// 命中缓存
+	_assert(call(1) == 2 && atomic.LoadInt32(&counter.calls) == 2, "expect a cache hit")
+	// 淘汰最久未使用的条目
+	call(3)
+	_assert(c.Len() == 2, "expect LRU eviction")
+	_ = call(2)
+	_assert(atomic.LoadInt32(&counter.calls) == 4, "expect the evicted entry to miss")
+	c.Invalidate("Foo.Sum", Args{Num1: 3, Num2: 3})
+	_assert(c.Len() == 1, "expect invalidation")
+	c.InvalidateMethod("Foo.Sum")
+	_assert(c.Len() == 0, "expect method invalidation")
+}
@@ -0,0 +1,83 @@
+package gorpc
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 回调工作协程池 所有Client共享
+var (
+	callbackOnce  sync.Once
+	callbackQueue chan *Call
+)
+
+// startCallbackWorkers 按CPU数量启动工作协程
+func startCallbackWorkers() {
+	n := runtime.NumCPU()
+	callbackQueue = make(chan *Call, n*64)
+	for i := 0; i < n; i++ {
+		go func() {
+			for call := range callbackQueue {
+				call.callback(call)
+			}
+		}()
+	}
+}
+
+// runCallback 将回调交给工作协程执行
+// 工作协程全忙时 新开协程执行 防止回调中再次发起同步调用导致 receive 阻塞死锁
+func runCallback(call *Call) {
+	callbackOnce.Do(startCallbackWorkers)
+	select {
+	case callbackQueue <- call:
+	default:
+		go call.callback(call)
+	}
+}
+
+// fire 执行回调 保证只执行一次
+func (call *Call) fire() {
+	if !atomic.CompareAndSwapInt32(&call.fired, 0, 1) {
+		return
+	}
+	close(call.finished)
+	runCallback(call)
+}
+
+// GoFunc 回调风格的异步接口
+// 调用完成(或ctx取消)后在工作协程池中执行 callback 返回的Call不使用Done
+func (client *Client) GoFunc(ctx context.Context, serviceMethod string, args, reply interface{}, callback func(*Call)) *Call {
+	if callback == nil {
+		log.Panic("rpc client: callback is nil")
+	}
+	ctx, cancel := client.withDefaultTimeout(ctx)
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		callback: func(call *Call) {
+			cancel()
+			callback(call)
+		},
+		finished: make(chan struct{}),
+	}
+	client.start(ctx, call)
+	// ctx 可以被取消时 等待完成或取消
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-call.finished:
+			case <-ctx.Done():
+				// 移除成功说明响应还未到达 由这里执行回调
+				if client.removeCall(call.Seq) != nil {
+					call.Error = callFailed(ctx)
+					call.done()
+				}
+			}
+		}()
+	}
+	return call
+}
@@ -0,0 +1,21 @@
+package gorpc
+
+import "context"
+
+// Caller 可以发起RPC调用的客户端
+// *Client、*Pool 和 *xclient.XClient 都实现了该接口
+// 库和业务代码依赖 Caller 而不是具体类型 即可自由切换单连接、连接池或负载均衡客户端
+// 测试中也可以替换为 gorpctest.MockCaller
+type Caller interface {
+	// Call 同步调用
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+	// Go 异步调用 完成后通过 Call.Done 通知
+	Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call
+	// Close 关闭客户端
+	Close() error
+}
+
+var (
+	_ Caller = (*Client)(nil)
+	_ Caller = (*Pool)(nil)
+)
@@ -3,6 +3,8 @@ package gorpc
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,8 +13,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,11 +32,47 @@ type Call struct {
 	Reply interface{}
 	// 错误信息
 	Error error
+	// 服务端返回的响应元数据
+	Metadata Metadata
+	// 请求元数据 来自 NewOutgoingContext
+	outgoing Metadata
+	// 发出请求的客户端 用于 Cancel
+	client *Client
 	// 调用后的回调
 	Done chan *Call
+	// GoFunc 的回调函数 不为空时不使用Done
+	callback func(*Call)
+	// 回调是否已执行
+	fired int32
+	// 回调执行后关闭
+	finished chan struct{}
+	// 客户端span 随指标一起在结束时记录
+	span Span
+	// 指标回调 开始时间 是否已记录结束
+	stats    StatsHandler
+	start    time.Time
+	reported int32
+	// ctx 的截止时间 用于 DumpPending
+	deadline time.Time
+}
+
+// Cancel 取消一个未完成的请求 从pending中移除并以 ErrCanceled 通知Done
+// 请求已经完成时返回false 服务端仍会处理该请求 响应到达后被丢弃
+func (call *Call) Cancel() bool {
+	if call.client == nil || call.client.removeCall(call.Seq) == nil {
+		return false
+	}
+	call.Error = ErrCanceled
+	call.done()
+	return true
 }
 
 func (call *Call) done() {
+	call.reportEnd(call.Error)
+	if call.callback != nil {
+		call.fire()
+		return
+	}
 	call.Done <- call
 }
 
@@ -49,26 +89,27 @@ type Client struct {
 	mu sync.Mutex
 	// 发送请求的编号
 	seq uint64
-	// 存储未发送完的请求 k:V -> 编号:请求实例
-	pending map[uint64]*Call
-	// 是否关闭rpc调用(用户正常关闭）
-	closing bool
+	// 存储未发送完的请求 k:V -> 编号:请求实例 按编号分片 注册/移除不需要 mu
+	pending *pendingMap
+	// 是否关闭rpc调用(用户正常关闭） 原子操作
+	closing int32
 	// 服务停止(用于非正常closing）
 	shutdown bool
+	// 服务端地址 用于 Option.OnError
+	peer string
+	// 未完成请求的并发窗口 nil 表示不设限
+	slots chan struct{}
+	// 连接终止时关闭 唤醒等待窗口的请求
+	done chan struct{}
 }
 
 var _ io.Closer = (*Client)(nil)
 
-var ErrShutdown = errors.New("connection is shut down")
-
 // Close 关闭连接
 func (client *Client) Close() error {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-	if client.closing {
+	if !atomic.CompareAndSwapInt32(&client.closing, 0, 1) {
 		return ErrShutdown
 	}
-	client.closing = true
 	return client.cc.Close()
 }
 
@@ -76,33 +117,63 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && atomic.LoadInt32(&client.closing) == 0
 }
 
 // registerCall 客户端注册rpc请求
 func (client *Client) registerCall(call *Call) (uint64, error) {
-	// 方法内部上锁 防止并发问题 -> 该方法被其他client调用
-	client.mu.Lock()
-	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if atomic.LoadInt32(&client.closing) == 1 {
 		return 0, ErrShutdown
 	}
-	call.Seq = client.seq
-	client.pending[call.Seq] = call
 	// 序号++
-	client.seq++
+	call.Seq = atomic.AddUint64(&client.seq, 1) - 1
+	// terminateCalls 之后分片拒绝写入 不需要全局锁
+	if !client.pending.store(call) {
+		return 0, ErrShutdown
+	}
 	return call.Seq, nil
 }
 
 // removeCall 客户端移除rpc请求
 func (client *Client) removeCall(seq uint64) *Call {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-	call := client.pending[seq]
-	delete(client.pending, seq)
+	call := client.pending.remove(seq)
+	if call != nil {
+		client.releaseSlot()
+	}
 	return call
 }
 
+// acquireSlot 占用一个并发窗口
+// 窗口已满时 根据Option快速失败或阻塞等待
+func (client *Client) acquireSlot(ctx context.Context) error {
+	if client.slots == nil {
+		return nil
+	}
+	if client.opt.PendingFailFast {
+		select {
+		case client.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrTooManyPendingCalls
+		}
+	}
+	select {
+	case client.slots <- struct{}{}:
+		return nil
+	case <-client.done:
+		return ErrShutdown
+	case <-ctx.Done():
+		return callFailed(ctx)
+	}
+}
+
+// releaseSlot 释放一个并发窗口
+func (client *Client) releaseSlot() {
+	if client.slots != nil {
+		<-client.slots
+	}
+}
+
 // terminateCalls rpc请求错误
 // defer处理顺序: client.mu.Unlock -> client.sending.Unlock
 func (client *Client) terminateCalls(err error) {
@@ -111,8 +182,10 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
+	close(client.done)
 	// 将所有错误信息通知等待处理中的call
-	for _, call := range client.pending {
+	for _, call := range client.pending.drain() {
+		client.releaseSlot()
 		call.Error = err
 		call.done()
 	}
@@ -120,22 +193,25 @@ func (client *Client) terminateCalls(err error) {
 
 // send 请求发送
 func (client *Client) send(call *Call) {
-	// 加锁确保请求信息发送完整
-	client.sending.Lock()
-	defer client.sending.Unlock()
-
-	// 先注册请求信息
+	// 先注册请求信息 分片表自带锁 不占用发送锁
+	// 注册后被 terminateCalls 清理的请求 写入会失败 removeCall返回nil
 	seq, err := client.registerCall(call)
 	if err != nil {
+		client.releaseSlot()
 		call.Error = err
 		call.done()
 		return
 	}
 
+	// 加锁确保请求信息发送完整
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
 	// 准备请求头
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.outgoing
 
 	// 编码 发送请求
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -151,12 +227,25 @@ func (client *Client) send(call *Call) {
 
 // Go 对外暴露给用户的RPC调用接口
 // 异步接口 返回Call实例
+// 设置了MaxPendingCalls时 窗口已满会阻塞或快速失败
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return client.goContext(context.Background(), serviceMethod, args, reply, done)
+}
+
+// doneChan 校验调用方传入的done通道 为nil时创建默认的带缓冲通道
+func doneChan(done chan *Call) chan *Call {
 	if done == nil {
-		done = make(chan *Call, 10)
-	} else if cap(done) == 0 {
+		return make(chan *Call, 10)
+	}
+	if cap(done) == 0 {
 		log.Panic("rpc client: done channel is unbuffered")
 	}
+	return done
+}
+
+// goContext Go的内部实现 等待并发窗口时响应ctx
+func (client *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	done = doneChan(done)
 	// 构造一个Call请求
 	call := &Call{
 		ServiceMethod: serviceMethod,
@@ -164,29 +253,68 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Reply:         reply,
 		Done:          done,
 	}
+	client.start(ctx, call)
+	return call
+}
+
+// start 占用并发窗口后发送请求
+func (client *Client) start(ctx context.Context, call *Call) {
+	call.client = client
+	call.outgoing, _ = FromOutgoingContext(ctx)
+	call.start = time.Now()
+	call.deadline, _ = ctx.Deadline()
+	call.startSpan(ctx, client.opt.Tracer)
+	call.reportStart(client.opt.Stats)
+	if err := client.limit(ctx, call.ServiceMethod); err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
+	if err := client.acquireSlot(ctx); err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
 	// 请求发送
 	// TODO 此处的send是同步等待的
 	// sending.Lock()
 	client.send(call)
-	return call
 }
 
 // Call 封装Go
 // 同步接口 call.Done，等待响应返回
 // 处理超时
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	ctx, cancel := client.withDefaultTimeout(ctx)
+	defer cancel()
+	if client.opt.HedgeDelay > 0 {
+		return client.hedgedCall(ctx, serviceMethod, args, reply)
+	}
 	//TODO chan数量为1 保证同步
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := client.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1))
 
 	select {
 	//TODO 提供一个供用户自定义的 具备超时检测能力的context对象来控制
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		err := callFailed(ctx)
+		if client.removeCall(call.Seq) != nil {
+			call.reportEnd(err)
+		}
+		return err
 	case call := <-call.Done:
+		receiveResponseMetadata(ctx, call)
 		return call.Error
 	}
-	return call.Error
+}
+
+// withDefaultTimeout ctx 没有截止时间时 使用 Option.CallTimeout
+func (client *Client) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if client.opt.CallTimeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			return context.WithTimeout(ctx, client.opt.CallTimeout)
+		}
+	}
+	return ctx, func() {}
 }
 
 // receive 接收响应
@@ -198,13 +326,25 @@ func (client *Client) receive() {
 			break
 		}
 		call := client.removeCall(h.Seq)
+		if call != nil {
+			call.Metadata = h.Metadata
+		}
+		var streamErr error
+		if sc, ok := client.cc.(streamErrorCodec); ok {
+			streamErr = sc.streamError()
+		}
 		switch {
 		case call == nil:
 			//TODO call不存在 可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了？
 			err = client.cc.ReadBody(nil)
+		case streamErr != nil:
+			// 该请求传输失败 不是服务端返回的错误
+			call.Error = streamErr
+			err = client.cc.ReadBody(nil)
+			call.done()
 		case h.Error != "":
 			// call存在 但是服务端处理出错
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = NewServerError(h.Error)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
@@ -224,16 +364,22 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error:", err)
+		Logf(LevelError, "rpc client: codec error: %v", err)
 		return nil, err
 	}
 	// 发送 option 编码给服务端
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		Logf(LevelError, "rpc client: options error: %v", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	if opt.Stats != nil {
+		conn = &statsConn{Conn: conn, stats: opt.Stats}
+	}
+	codecStats, _ := opt.Stats.(CodecStatsHandler)
+	client := newClientCodec(newStatsCodec(f, opt.CodecType, conn, codecStats), opt)
+	client.peer = peerAddr(conn)
+	return client, nil
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -241,7 +387,11 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		seq:     1, // seq starts with 1, 0 means invalid call
 		cc:      cc,
 		opt:     opt,
-		pending: make(map[uint64]*Call),
+		pending: newPendingMap(),
+		done:    make(chan struct{}),
+	}
+	if opt.MaxPendingCalls > 0 {
+		client.slots = make(chan struct{}, opt.MaxPendingCalls)
 	}
 	// 开启一个协程 receive响应
 	go client.receive()
@@ -256,12 +406,28 @@ type clientResult struct {
 // dialTimeout Dial外壳
 // 超时处理
 func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+	return dialContext(context.Background(), f, network, address, opts...)
+}
+
+// dialContext 建立连接并完成Option握手
+// 同时受 ctx 和 ConnectTimeout 约束
+func dialContext(ctx context.Context, f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	// 将net.Dial 替换为 net.DialTimeout
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	var conn net.Conn
+	if opt.Dialer != nil {
+		// 用户注入的拨号函数
+		conn, err = opt.Dialer(ctx, network, address)
+	} else if t := getTransport(network); t != nil {
+		// 自定义传输层
+		conn, err = t.Dial(ctx, address)
+	} else {
+		// 将net.Dial 替换为 net.Dialer.DialContext
+		d := net.Dialer{Timeout: opt.ConnectTimeout}
+		conn, err = d.DialContext(ctx, network, address)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -271,19 +437,30 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 			_ = conn.Close()
 		}
 	}()
-	ch := make(chan clientResult)
+	// 缓存信道 超时返回后协程仍可写入 防止泄漏
+	ch := make(chan clientResult, 1)
 	go func() {
 		client, err := f(conn, opt)
+		if err == nil && opt.PingOnConnect {
+			if err = client.Ping(ctx); err != nil {
+				_ = client.Close()
+				client = nil
+			}
+		}
 		ch <- clientResult{client: client, err: err}
 	}()
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
+	var timeout <-chan time.Time
+	if opt.ConnectTimeout > 0 {
+		timer := time.NewTimer(opt.ConnectTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 	select {
 	// 创建客户端超时
-	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-timeout:
+		return nil, &kindError{msg: fmt.Sprintf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout), kind: ErrTimeout}
+	case <-ctx.Done():
+		return nil, ctxError("rpc client: connect failed: ", ctx.Err())
 	case result := <-ch:
 		return result.client, result.err
 	}
@@ -311,12 +488,22 @@ func parseOptions(opts ...*Option) (*Option, error) {
 
 // Dial 传入服务端地址
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	return DialContext(context.Background(), network, address, opts...)
+}
+
+// DialContext 同 Dial 拨号及Option握手期间响应ctx的取消和截止时间
+func DialContext(ctx context.Context, network, address string, opts ...*Option) (client *Client, err error) {
+	return dialContext(ctx, NewClient, network, address, opts...)
 }
 
 // NewHTTPClient 通过HTTP协议新建一个客户端
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
+	return newHTTPClientPath(conn, defaultRPCPath, opt)
+}
+
+// newHTTPClientPath 向指定路径发送CONNECT请求后切换到RPC协议
+func newHTTPClientPath(conn net.Conn, path string, opt *Option) (*Client, error) {
+	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", path))
 
 	// 切换到RPC协议之前需要正确的HTTP响应
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
@@ -329,25 +516,137 @@ func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 	return nil, err
 }
 
+// proxyConnect 通过HTTP代理的CONNECT方法建立到目标地址的隧道
+func proxyConnect(conn net.Conn, proxyURL *url.URL, address string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("rpc client: proxy error: " + resp.Status)
+	}
+	return nil
+}
+
+// proxyAddr 代理地址 未指定端口时默认80
+func proxyAddr(proxyURL *url.URL) (string, error) {
+	if proxyURL.Scheme != "" && proxyURL.Scheme != "http" {
+		return "", fmt.Errorf("rpc client: unsupported proxy scheme %s", proxyURL.Scheme)
+	}
+	if proxyURL.Port() == "" {
+		return net.JoinHostPort(proxyURL.Hostname(), "80"), nil
+	}
+	return proxyURL.Host, nil
+}
+
 // DialHTTP 连接到指定网络地址的服务器，监听默认 HTTP RPC 路径
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	return DialHTTPPath(network, address, defaultRPCPath, opts...)
+}
+
+// DialHTTPPath 连接到指定网络地址的服务器，监听指定的 HTTP RPC 路径
+// Option.Proxy 不为空时 经由HTTP代理建立连接
+func DialHTTPPath(network, address, path string, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	dialAddr := address
+	f := func(conn net.Conn, opt *Option) (*Client, error) {
+		return newHTTPClientPath(conn, path, opt)
+	}
+	if opt.Proxy != nil {
+		proxyURL, err := opt.Proxy(&http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Scheme: "http", Host: address, Path: path},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			if dialAddr, err = proxyAddr(proxyURL); err != nil {
+				return nil, err
+			}
+			f = func(conn net.Conn, opt *Option) (*Client, error) {
+				if err := proxyConnect(conn, proxyURL, address); err != nil {
+					return nil, err
+				}
+				return newHTTPClientPath(conn, path, opt)
+			}
+		}
+	}
+	return dialTimeout(f, network, dialAddr, opt)
+}
+
+// DialTLS 通过TLS连接到指定地址的服务器 握手完成后再发送Option
+// config 为nil时使用 Option.TLSConfig 双向TLS时在 config.Certificates 中设置客户端证书
+func DialTLS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = opt.TLSConfig
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	f := func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return NewClient(tlsConn, opt)
+	}
+	return dialTimeout(f, network, address, opt)
+}
+
+// parseRPCAddr 解析 protocol@addr 格式的地址
+// 只按第一个@划分 unix socket 路径中可以包含@
+func parseRPCAddr(rpcAddr string) (protocol, addr string, err error) {
+	parts := strings.SplitN(rpcAddr, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
+	}
+	return parts[0], parts[1], nil
 }
 
 // XDial 统一调用路口
 // 通用格式 protocol@addr, 例如：
-// http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/gorpc.sock
+// http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/gorpc.sock, h2@10.0.0.1:7443, tls@10.0.0.1:9443
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
-	parts := strings.Split(rpcAddr, "@")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
+	protocol, addr, err := parseRPCAddr(rpcAddr)
+	if err != nil {
+		return nil, err
 	}
-	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "unix":
+		return Dial("unix", addr, opts...)
+	case "h2":
+		return DialHTTP2(addr, opts...)
+	case "tls":
+		return DialTLS("tcp", addr, nil, opts...)
 	default:
-		// protool支持 tcp,unix等协议
+		// protool支持 tcp,unix等协议 以及通过 RegisterTransport 注册的传输层
 		return Dial(protocol, addr, opts...)
 	}
 }
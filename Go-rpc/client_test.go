@@ -1,11 +1,33 @@
 package gorpc
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorpc/codec"
+	"io"
+	"log"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/trace"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -17,9 +39,24 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+var slowOnceCalls sync.Map
+
+// SlowOnce 每个argv第一次调用耗时2s 之后立即返回
+func (b Bar) SlowOnce(argv int, reply *int) error {
+	if _, seen := slowOnceCalls.LoadOrStore(argv, true); !seen {
+		time.Sleep(time.Second * 2)
+	}
+	*reply = argv
+	return nil
+}
+
 func startServer(addr chan string) {
 	var b Bar
+	var foo Foo
+	var baz Baz
 	_ = Register(&b)
+	_ = Register(&foo)
+	_ = Register(&baz)
 	// pick a free port
 	l, _ := net.Listen("tcp", ":0")
 	addr <- l.Addr().String()
@@ -43,6 +80,12 @@ func TestClient_dialTimeout(t *testing.T) {
 		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
 		_assert(err == nil, "0 means no limit")
 	})
+	t.Run("ctx", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := dialContext(ctx, f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
+		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a ctx error")
+	})
 }
 
 func TestClient_Call(t *testing.T) {
@@ -53,11 +96,18 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
 	})
+	t.Run("default call timeout", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{CallTimeout: time.Second})
+		var reply int
+		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), context.DeadlineExceeded.Error()), "expect a timeout error")
+	})
 	t.Run("server handle timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr, &Option{
 			HandleTimeout: time.Second,
@@ -76,7 +126,8 @@ func TestXDial(t *testing.T) {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
@@ -86,3 +137,1073 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestParseRPCAddr(t *testing.T) {
+	protocol, addr, err := parseRPCAddr("unix@/tmp/a@b.sock")
+	_assert(err == nil && protocol == "unix" && addr == "/tmp/a@b.sock", "wrong unix addr %s", addr)
+	_, _, err = parseRPCAddr("tcp:9999")
+	_assert(err != nil, "expect a format error")
+	_, _, err = parseRPCAddr("unix@")
+	_assert(err != nil, "expect a format error")
+}
+
+func TestListen_Unix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	t.Parallel()
+	addr := "unix@" + filepath.Join(t.TempDir(), "gorpc.sock")
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	// 残留的socket文件不影响重新监听
+	for i := 0; i < 2; i++ {
+		l, err := Listen(addr)
+		_assert(err == nil, "failed to listen unix socket: %v", err)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		go server.Accept(l)
+		// 仍在监听的socket不会被抢占
+		_, err = Listen(addr)
+		_assert(err != nil, "expect a live unix socket to stay in use")
+		client, err := XDial(addr)
+		_assert(err == nil, "failed to connect unix socket")
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over unix socket")
+		_ = client.Close()
+		_ = l.Close()
+	}
+}
+
+func TestClient_MaxPendingCalls(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	time.Sleep(time.Second)
+	t.Run("fail fast", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{MaxPendingCalls: 1, PendingFailFast: true})
+		defer func() { _ = client.Close() }()
+		var reply int
+		_ = client.Go("Bar.Timeout", 1, &reply, nil)
+		call := <-client.Go("Bar.Timeout", 1, &reply, nil).Done
+		_assert(call.Error == ErrTooManyPendingCalls, "expect too many pending calls error")
+	})
+	t.Run("block", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{MaxPendingCalls: 1})
+		defer func() { _ = client.Close() }()
+		var reply int
+		_ = client.Go("Bar.Timeout", 1, &reply, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect to block until timeout")
+	})
+}
+
+// startProxy 简易的CONNECT代理
+func startProxy(addr chan string) {
+	l, _ := net.Listen("tcp", ":0")
+	addr <- l.Addr().String()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			br := bufio.NewReader(conn)
+			req, err := http.ReadRequest(br)
+			if err != nil || req.Method != "CONNECT" {
+				_ = conn.Close()
+				return
+			}
+			target, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			go func() { _, _ = io.Copy(target, br) }()
+			_, _ = io.Copy(conn, target)
+		}(conn)
+	}
+}
+
+func TestDialHTTPPath(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	mux := http.NewServeMux()
+	mux.Handle("/custom", server)
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = http.Serve(l, mux) }()
+	addr := l.Addr().String()
+
+	t.Run("path", func(t *testing.T) {
+		client, err := DialHTTPPath("tcp", addr, "/custom")
+		_assert(err == nil, "failed to dial custom path: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum")
+	})
+	t.Run("proxy", func(t *testing.T) {
+		proxyCh := make(chan string)
+		go startProxy(proxyCh)
+		proxyURL := &url.URL{Scheme: "http", Host: <-proxyCh}
+		client, err := DialHTTPPath("tcp", addr, "/custom", &Option{Proxy: http.ProxyURL(proxyURL)})
+		_assert(err == nil, "failed to dial through proxy: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum through proxy")
+	})
+}
+
+// tcpTransport 通过自定义传输层转发到tcp
+type tcpTransport struct{ dials int }
+
+func (t *tcpTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	t.dials++
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (t *tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func TestRegisterTransport(t *testing.T) {
+	tr := &tcpTransport{}
+	RegisterTransport("mytcp", tr)
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, err := Listen("mytcp@127.0.0.1:0")
+	_assert(err == nil, "failed to listen custom transport: %v", err)
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := XDial("mytcp@" + l.Addr().String())
+	_assert(err == nil && tr.dials == 1, "failed to dial custom transport: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over custom transport")
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	pool := NewPool("tcp@"+addr, &Option{PoolSize: 2})
+	c1, err := pool.Get()
+	_assert(err == nil, "failed to get client: %v", err)
+	c2, _ := pool.Get()
+	c3, _ := pool.Get()
+	_assert(c1 != c2 && c1 == c3, "expect round robin over 2 connections")
+
+	// 连接断开后重新建立
+	_ = c1.Close()
+	var reply int
+	err = pool.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum")
+	_ = pool.Close()
+	_, err = pool.Get()
+	_assert(err == ErrShutdown, "expect pool closed")
+
+	// 获取连接失败时 done通道的校验与Client.Go一致
+	call := <-pool.Go("Foo.Sum", Args{}, &reply, nil).Done
+	_assert(call.Error == ErrShutdown, "expect pool closed, got %v", call.Error)
+	func() {
+		defer func() {
+			_assert(recover() != nil, "expect unbuffered done channel to panic")
+		}()
+		pool.Go("Foo.Sum", Args{}, &reply, make(chan *Call))
+	}()
+}
+
+func TestPool_SingleflightDial(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		time.Sleep(time.Millisecond * 50)
+		return nil, errors.New("connection refused")
+	}
+	pool := NewPool("tcp@fake:1", &Option{Dialer: dialer})
+	defer func() { _ = pool.Close() }()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pool.Get()
+			_assert(err != nil, "expect dial error")
+		}()
+	}
+	wg.Wait()
+	_assert(atomic.LoadInt32(&dials) < 5, "expect concurrent callers to share one dial, got %d dials", dials)
+}
+
+func TestClient_GoFunc(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh)
+	defer func() { _ = client.Close() }()
+
+	t.Run("callback", func(t *testing.T) {
+		ch := make(chan *Call, 1)
+		var reply int
+		client.GoFunc(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, func(call *Call) {
+			ch <- call
+		})
+		call := <-ch
+		_assert(call.Error == nil && reply == 3, "failed to call Foo.Sum")
+	})
+	t.Run("ctx", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ch := make(chan *Call, 2)
+		var reply int
+		client.GoFunc(ctx, "Bar.Timeout", 1, &reply, func(call *Call) {
+			ch <- call
+		})
+		call := <-ch
+		_assert(call.Error != nil && strings.Contains(call.Error.Error(), ctx.Err().Error()), "expect a timeout error")
+		time.Sleep(time.Second * 2)
+		_assert(len(ch) == 0, "callback should be invoked once")
+	})
+}
+
+func TestClient_ResponseMetadata(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh)
+	defer func() { _ = client.Close() }()
+
+	var md Metadata
+	var reply int
+	err := client.Call(WithResponseMetadata(context.Background(), &md), "Baz.Version", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Baz.Version")
+	_assert(md["version"] == "1.0", "expect response metadata, got %v", md)
+
+	call := <-client.Go("Baz.Version", Args{Num1: 1, Num2: 2}, &reply, nil).Done
+	_assert(call.Error == nil && call.Metadata["version"] == "1.0", "expect response metadata on Call")
+
+	ctx := NewOutgoingContext(context.Background(), Metadata{"user": "alice"})
+	ctx = AppendToOutgoingContext(ctx, "trace", "1")
+	err = client.Call(WithResponseMetadata(ctx, &md), "Baz.Version", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && md["echo"] == "alice", "expect request metadata to reach the server, got %v", md)
+}
+
+func TestClient_Hedge(t *testing.T) {
+	t.Parallel()
+	// -count 大于1时 每次运行都从慢调用开始
+	slowOnceCalls.Delete(7)
+	slowOnceCalls.Delete(8)
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh, &Option{HedgeDelay: time.Millisecond * 100})
+	defer func() { _ = client.Close() }()
+
+	start := time.Now()
+	var reply int
+	err := client.Call(context.Background(), "Bar.SlowOnce", 7, &reply)
+	_assert(err == nil && reply == 7, "failed to call Bar.SlowOnce")
+	_assert(time.Since(start) < time.Second, "expect the hedged request to win")
+
+	// 不关心响应体时 reply可以为nil 同样会对冲
+	start = time.Now()
+	err = client.Call(context.Background(), "Bar.SlowOnce", 8, nil)
+	elapsed := time.Since(start)
+	_assert(err == nil, "failed to call Bar.SlowOnce with a nil reply: %v", err)
+	_assert(elapsed >= time.Millisecond*100 && elapsed < time.Second, "expect the hedge to fire after HedgeDelay, took %v", elapsed)
+}
+
+func TestClient_Stats(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	stats := NewClientStats()
+	client, _ := Dial("tcp", <-addrCh, &Option{Stats: stats})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_ = client.Call(context.Background(), "Foo.Missing", Args{}, &reply)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_ = client.Call(ctx, "Bar.Timeout", 1, &reply)
+
+	methods := stats.Methods()
+	_assert(methods["Foo.Sum"].Calls == 1 && methods["Foo.Sum"].Errors == 0, "wrong Foo.Sum stats %+v", methods["Foo.Sum"])
+	_assert(methods["Foo.Missing"].Errors == 1, "wrong Foo.Missing stats %+v", methods["Foo.Missing"])
+	_assert(methods["Bar.Timeout"].Errors == 1 && methods["Bar.Timeout"].InFlight == 0, "wrong Bar.Timeout stats %+v", methods["Bar.Timeout"])
+	written, read := stats.Bytes()
+	_assert(written > 0 && read > 0, "expect bytes to be counted")
+	sum := stats.Codecs()[CodecKey{Codec: codec.GobType, ServiceMethod: "Foo.Sum"}]
+	_assert(sum.Encodes == 1 && sum.Decodes == 1 && sum.EncodedBytes > 0 && sum.DecodedBytes > 0, "wrong Foo.Sum codec stats %+v", sum)
+}
+
+func BenchmarkClient_pending(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) {
+		client := &Client{seq: 1, opt: DefaultOption, pending: newPendingMap()}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				seq, _ := client.registerCall(&Call{})
+				client.removeCall(seq)
+			}
+		})
+	})
+	// 分片之前的实现 单个互斥锁保护的map 作为对照
+	b.Run("mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		var seq uint64
+		pending := make(map[uint64]*Call)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				s := seq
+				seq++
+				pending[s] = &Call{Seq: s}
+				mu.Unlock()
+				mu.Lock()
+				delete(pending, s)
+				mu.Unlock()
+			}
+		})
+	})
+}
+
+func TestCall_Cancel(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	call := client.Go("Bar.Timeout", 1, &reply, nil)
+	_assert(call.Cancel(), "expect the pending call to be canceled")
+	call = <-call.Done
+	_assert(call.Error == ErrCanceled, "expect ErrCanceled, got %v", call.Error)
+	_assert(!call.Cancel(), "a finished call can't be canceled")
+	_assert(client.pending.len() == 0, "expect no pending calls")
+}
+
+func TestClient_Ping(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	client, err := Dial("tcp", addr, &Option{PingOnConnect: true})
+	_assert(err == nil, "failed to dial with ping: %v", err)
+	_assert(client.Ping(context.Background()) == nil, "failed to ping")
+
+	pool := NewPool("tcp@"+addr, &Option{PoolSize: 3, PingOnConnect: true})
+	_assert(pool.WarmUp() == nil, "failed to warm up pool")
+	for _, c := range pool.clients {
+		_assert(c != nil && c.IsAvailable(), "expect all connections established")
+	}
+}
+
+func TestClient_Dialer(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var dialed string
+	// 内存管道 不经过网络
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = network + "@" + address
+		c1, c2 := net.Pipe()
+		go server.ServeConn(c2)
+		return c1, nil
+	}
+	client, err := XDial("tcp@fake:1", &Option{Dialer: dialer})
+	_assert(err == nil && dialed == "tcp@fake:1", "failed to dial with custom dialer: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over pipe")
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	t.Run("fail fast", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{
+			MethodRateLimiters: map[string]*RateLimiter{"Foo.Sum": NewRateLimiter(1, 1)},
+			RateLimitFailFast:  true,
+		})
+		defer func() { _ = client.Close() }()
+		var reply int
+		_assert(client.Call(context.Background(), "Foo.Sum", Args{}, &reply) == nil, "first call should pass")
+		err := client.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+		_assert(err == ErrRateLimited, "expect ErrRateLimited, got %v", err)
+		_assert(client.Ping(context.Background()) == nil, "other methods are not limited")
+	})
+	t.Run("refund", func(t *testing.T) {
+		method := NewRateLimiter(0, 2)
+		client, _ := Dial("tcp", addr, &Option{
+			RateLimiter:        NewRateLimiter(0, 1),
+			MethodRateLimiters: map[string]*RateLimiter{"Foo.Sum": method},
+			RateLimitFailFast:  true,
+		})
+		defer func() { _ = client.Close() }()
+		var reply int
+		_assert(client.Call(context.Background(), "Foo.Sum", Args{}, &reply) == nil, "first call should pass")
+		err := client.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+		_assert(err == ErrRateLimited, "expect ErrRateLimited, got %v", err)
+		// 被全局限流器拒绝的调用 不消耗方法级令牌
+		_assert(method.Allow(), "expect the method token to be returned")
+	})
+	t.Run("block", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{RateLimiter: NewRateLimiter(10, 1)})
+		defer func() { _ = client.Close() }()
+		start := time.Now()
+		var reply int
+		for i := 0; i < 3; i++ {
+			_ = client.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+		}
+		_assert(time.Since(start) >= time.Millisecond*150, "expect calls to be throttled")
+	})
+}
+
+func TestClient_Errors(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	client, _ := Dial("tcp", addr, &Option{HandleTimeout: time.Millisecond * 100})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Nope.Sum", Args{}, &reply)
+	var se *ServerError
+	_assert(errors.As(err, &se) && errors.Is(err, ErrServiceNotFound), "expect ErrServiceNotFound, got %v", err)
+	err = client.Call(context.Background(), "Foo.Nope", Args{}, &reply)
+	_assert(errors.Is(err, ErrMethodNotFound), "expect ErrMethodNotFound, got %v", err)
+	err = client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+	_assert(errors.Is(err, ErrHandleTimeout) && errors.Is(err, ErrTimeout), "expect ErrHandleTimeout, got %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = client.Call(ctx, "Bar.Timeout", 1, &reply)
+	_assert(errors.Is(err, ErrTimeout) && errors.Is(err, context.DeadlineExceeded), "expect ErrTimeout, got %v", err)
+}
+
+func TestXDial_TLS(t *testing.T) {
+	t.Parallel()
+	// 借用 httptest 自带的证书 (对 127.0.0.1 有效)
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	l, _ := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	defer func() { _ = l.Close() }()
+	var foo Foo
+	server := NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	client, err := XDial("tls@"+l.Addr().String(), &Option{TLSConfig: &tls.Config{RootCAs: pool}})
+	_assert(err == nil, "failed to dial tls: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over tls: %v", err)
+
+	_, err = XDial("tls@" + l.Addr().String())
+	_assert(err != nil, "expect certificate verification error")
+}
+
+// newTestCert 创建由 parent 签发的证书 parent 为nil时创建自签名的CA
+func newTestCert(tmpl *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServeTLS_Mutual(t *testing.T) {
+	t.Parallel()
+	ca := newTestCert(&x509.Certificate{Subject: pkix.Name{CommonName: "test ca"}}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newTestCert(&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}, &ca)
+	spiffe, _ := url.Parse("spiffe://example.org/alice")
+	clientCert := newTestCert(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, URIs: []*url.URL{spiffe}}, &ca)
+
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	identities := make(chan *Identity, 1)
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			id, _ := IdentityFromContext(ctx)
+			identities <- id
+			return next(ctx, call)
+		}
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool})
+
+	client, err := DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}})
+	_assert(err == nil, "failed to dial mtls: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over mtls: %v", err)
+	id := <-identities
+	_assert(id != nil && id.Name == "alice" && len(id.URIs) == 1 && id.URIs[0] == "spiffe://example.org/alice", "unexpected peer identity %+v", id)
+
+	// 没有客户端证书时握手失败
+	if anon, err := DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: pool}, &Option{CallTimeout: time.Second}); err == nil {
+		err = anon.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_ = anon.Close()
+		_assert(err != nil, "expect call without client certificate to fail")
+	}
+}
+
+func TestClient_Tracer(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	spans := make(map[SpanKind]*SpanData)
+	tracer := NewTracer(func(s *SpanData) {
+		mu.Lock()
+		defer mu.Unlock()
+		spans[s.Kind] = s
+	})
+	server := NewServer()
+	server.SetTracer(tracer)
+	var baz Baz
+	_ = server.Register(&baz)
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(c2)
+		return c1, nil
+	}
+	client, _ := XDial("tcp@fake:1", &Option{Dialer: dialer, Tracer: tracer})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Baz.Version", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Baz.Version")
+	mu.Lock()
+	defer mu.Unlock()
+	c, s := spans[SpanClient], spans[SpanServer]
+	_assert(c != nil && s != nil, "expect client and server spans, got %v", spans)
+	_assert(s.TraceID == c.TraceID && s.ParentSpanID == c.SpanID, "expect server span to continue the client trace: %+v %+v", c, s)
+	_assert(c.Name == "Baz.Version" && c.Error == nil, "unexpected client span %+v", c)
+
+	_, ok := parseTraceparent("00-" + c.TraceID + "-" + c.SpanID + "-01")
+	_assert(ok, "expect valid traceparent")
+	_, ok = parseTraceparent("00-" + strings.Repeat("0", 32) + "-" + c.SpanID + "-01")
+	_assert(!ok, "expect all-zero trace id to be rejected")
+}
+
+func TestServer_SlowThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetSlowThreshold(time.Hour)
+	server.SetMethodSlowThreshold("Foo.Sum", time.Nanosecond)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	ctx := AppendToOutgoingContext(context.Background(), "request-id", "req-1")
+	_ = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	server.SetMethodSlowThreshold("Foo.Sum", 0)
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	out := buf.String()
+	_assert(strings.Count(out, "slow call") == 1, "expect one slow call log, got %q", out)
+	_assert(strings.Contains(out, "method=Foo.Sum") && strings.Contains(out, "request_id=req-1"), "unexpected slow call log %q", out)
+	_assert(strings.Contains(out, "peer=127.0.0.1:"), "expect peer addr in slow call log %q", out)
+}
+
+// lineWriter 将每次写入发送到信道
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestLogf_LevelAndSampling(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	SetLogLevel(LevelWarn)
+	defer SetLogLevel(LevelInfo)
+	Logf(LevelInfo, "rpc test: info %d", 1)
+	_assert(buf.Len() == 0, "expect info log to be filtered, got %q", buf.String())
+
+	SetLogSampling(2, 3, time.Hour)
+	defer SetLogSampling(0, 0, 0)
+	for i := 1; i <= 10; i++ {
+		Logf(LevelWarn, "rpc test: read header error: %d", i)
+	}
+	out := buf.String()
+	// 前2条 之后每3条输出一条: 1 2 5 8
+	_assert(strings.Count(out, "read header error") == 4 && strings.Contains(out, "error: 8\n"), "unexpected sampled logs %q", out)
+
+	s := &logSampler{first: 1, tick: time.Second, counts: make(map[string]*sampleWindow)}
+	now := time.Now()
+	s.allow("k", now)
+	s.allow("k", now)
+	ok, dropped := s.allow("k", now.Add(time.Second))
+	_assert(ok && dropped == 1, "expect dropped count of the last window, got %v %d", ok, dropped)
+	l, err := ParseLogLevel("ERROR")
+	_assert(err == nil && l == LevelError, "failed to parse log level: %v", err)
+}
+
+func TestServer_Settings(t *testing.T) {
+	defer SetLogLevel(LevelInfo)
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	h := server.SettingsHandler()
+	post := func(body, contentType, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", defaultDebugSettings, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// 跨站的表单提交被拒绝
+	w := post(`{"maxConcurrency":1}`, "text/plain", "")
+	_assert(w.Code == http.StatusUnsupportedMediaType, "expect non-json settings to be rejected, got %d", w.Code)
+	w = post(`{"maxConcurrency":1}`, "application/json", "http://evil.example")
+	_assert(w.Code == http.StatusForbidden, "expect cross-origin settings to be rejected, got %d", w.Code)
+	w = post(`{"logLevel":"debug","handleTimeout":"bad"}`, "application/json", "")
+	_assert(w.Code == http.StatusBadRequest && GetLogLevel() == LevelInfo, "expect invalid settings to be rejected as a whole, got %d", w.Code)
+	body := `{"logLevel":"warn","slowThreshold":"1s","methodSlowThresholds":{"Foo.Sum":"10ms"},"handleTimeout":"2s","maxConcurrency":1}`
+	w = post(body, "application/json; charset=utf-8", "http://example.com")
+	var v settingsView
+	_ = json.Unmarshal(w.Body.Bytes(), &v)
+	_assert(w.Code == http.StatusOK && v.LogLevel == "warn" && v.SlowThreshold == "1s" && v.MethodSlowThresholds["Foo.Sum"] == "10ms" && v.MaxConcurrency == 1,
+		"unexpected settings %d %+v", w.Code, v)
+	_assert(server.effectiveHandleTimeout(0) == 2*time.Second && server.effectiveHandleTimeout(time.Second) == time.Second, "unexpected handle timeout")
+
+	// 并发上限为1 第一个请求阻塞在中间件中时 第二个请求被拒绝
+	entered, release := make(chan struct{}), make(chan struct{})
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			if call.Metadata["block"] != "" {
+				close(entered)
+				<-release
+			}
+			return next(ctx, call)
+		}
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	blocked := client.goContext(AppendToOutgoingContext(context.Background(), "block", "1"), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, nil)
+	<-entered
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	_assert(errors.Is(err, ErrServerBusy), "expect ErrServerBusy, got %v", err)
+	close(release)
+	<-blocked.Done
+	_assert(blocked.Error == nil && reply == 3, "expect blocked call to succeed, got %v", blocked.Error)
+}
+
+func TestServer_Middleware(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	logs := make(lineWriter, 2)
+	server.Use(AccessLog(logs, JSONAccessLogFormatter), func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			if call.Metadata["token"] != "secret" {
+				return errors.New("unauthorized")
+			}
+			return next(ctx, call)
+		}
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "unauthorized"), "expect call to be rejected, got %v", err)
+	ctx := AppendToOutgoingContext(context.Background(), "token", "secret")
+	err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum through middleware: %v", err)
+
+	// 访问日志在响应发出后写入
+	lines := []string{<-logs, <-logs}
+	var entry struct {
+		Peer   string `json:"peer"`
+		Method string `json:"method"`
+		Status string `json:"status"`
+		Bytes  int64  `json:"bytes"`
+	}
+	_ = json.Unmarshal([]byte(lines[0]), &entry)
+	_assert(entry.Status == "error" && entry.Bytes == 0, "unexpected rejected entry %q", lines[0])
+	_ = json.Unmarshal([]byte(lines[1]), &entry)
+	_assert(entry.Method == "Foo.Sum" && entry.Status == "ok" && entry.Bytes > 0, "unexpected entry %q", lines[1])
+	_assert(strings.HasPrefix(entry.Peer, "127.0.0.1:"), "expect peer in access log, got %q", entry.Peer)
+}
+
+func TestServer_Audit(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	var calc Calc
+	_ = server.Register(&foo)
+	_ = server.Register(&calc)
+	logs := make(lineWriter, 2)
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			if user := call.Metadata["user"]; user != "" {
+				ctx = WithIdentity(ctx, &Identity{Name: user})
+			}
+			return next(ctx, call)
+		}
+	}, Audit(JSONAuditSink(logs), "Calc.Div"))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	ctx := AppendToOutgoingContext(context.Background(), "user", "alice")
+	_ = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_ = client.Call(ctx, "Calc.Div", Args{Num1: 4, Num2: 2}, &reply)
+	_ = client.Call(context.Background(), "Calc.Div", Args{Num1: 4}, &reply)
+
+	var e AuditEntry
+	_ = json.Unmarshal([]byte(<-logs), &e)
+	_assert(e.Identity == "alice" && e.ServiceMethod == "Calc.Div" && e.Result == "ok", "unexpected audit entry %+v", e)
+	e = AuditEntry{}
+	_ = json.Unmarshal([]byte(<-logs), &e)
+	_assert(e.Identity == "" && e.Result == "error" && e.Error == "divide by zero", "unexpected audit entry %+v", e)
+	select {
+	case line := <-logs:
+		t.Fatalf("expect only Calc.Div to be audited, got %s", line)
+	default:
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+	bodies := make(chan []byte, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer collector.Close()
+	exp := NewOTLPExporter(collector.URL+"/v1/metrics", "test", time.Hour)
+
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetMeter(exp)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String(), &Option{Meter: exp})
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_ = client.Call(context.Background(), "Foo.Missing", Args{}, &reply)
+
+	_assert(exp.Close() == nil, "failed to push metrics")
+	var req otlpMetricsRequest
+	_ = json.Unmarshal(<-bodies, &req)
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	_assert(len(metrics) == 2 && metrics[0].Name == MetricClientDuration && metrics[1].Name == MetricServerDuration, "unexpected metrics %+v", metrics)
+	points := metrics[0].Histogram.DataPoints
+	_assert(len(points) == 2, "expect ok and error data points, got %+v", points)
+	for _, p := range points {
+		_assert(p.Count == 1 && len(p.BucketCounts) == len(DefaultHistogramBounds)+1, "unexpected data point %+v", p)
+	}
+}
+
+func TestServer_GoroutineTracking(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(server.GoroutineCounts() == nil, "expect no counts when tracking is disabled")
+	server.SetGoroutineTracking(true)
+	server.SetHandleTimeout(50 * time.Millisecond)
+	release := make(chan struct{})
+	server.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			<-release
+			return next(ctx, call)
+		}
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrHandleTimeout), "expect handle timeout, got %v", err)
+	waitCounts := func(want map[string]int64) {
+		var got map[string]int64
+		for i := 0; i < 100; i++ {
+			if got = server.GoroutineCounts(); fmt.Sprint(got) == fmt.Sprint(want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect goroutine counts %v, got %v", want, got)
+	}
+	// 服务方法仍然阻塞 超时后的协程可见
+	waitCounts(map[string]int64{"conn": 1, "connDrain": 0, "handleRequest": 1, "call": 1, "timeoutWait": 1})
+	page := &debugPage{Goroutines: server.debugGoroutines()}
+	_assert(page.Goroutines.Total > 0, "expect total goroutines in debug page")
+	close(release)
+	waitCounts(map[string]int64{"conn": 1, "connDrain": 0, "handleRequest": 0, "call": 0, "timeoutWait": 0})
+	_ = client.Close()
+	waitCounts(map[string]int64{"conn": 0, "connDrain": 0, "handleRequest": 0, "call": 0, "timeoutWait": 0})
+}
+
+func TestServer_Conns(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+
+	conns := server.debugConns()
+	_assert(len(conns) == 1, "expect 1 connection, got %+v", conns)
+	c := conns[0]
+	_assert(c.Codec == string(DefaultOption.CodecType) && c.BytesRead > 0 && c.BytesWritten > 0, "unexpected connection %+v", c)
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	_assert(!strings.Contains(w.Body.String(), defaultDebugClose), "expect no close button unless enabled")
+	w = httptest.NewRecorder()
+	crossSite := httptest.NewRequest("POST", fmt.Sprintf("%s?id=%d", defaultDebugClose, c.ID), nil)
+	crossSite.Header.Set("Origin", "http://evil.example")
+	debugClose{server}.ServeHTTP(w, crossSite)
+	_assert(w.Code == http.StatusForbidden, "expect cross-origin close to be rejected, got %d", w.Code)
+	w = httptest.NewRecorder()
+	debugClose{server}.ServeHTTP(w, httptest.NewRequest("POST", defaultDebugClose+"?id=999", nil))
+	_assert(w.Code == http.StatusNotFound, "expect unknown connection, got %d", w.Code)
+	w = httptest.NewRecorder()
+	debugClose{server}.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("%s?id=%d", defaultDebugClose, c.ID), nil))
+	_assert(w.Code == http.StatusSeeOther, "expect connection to be closed, got %d", w.Code)
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect call on closed connection to fail")
+	for i := 0; i < 100 && len(server.debugConns()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(server.debugConns()) == 0, "expect closed connection to be removed")
+}
+
+func TestServer_RuntimeTrace(t *testing.T) {
+	var buf bytes.Buffer
+	_assert(trace.Start(&buf) == nil, "failed to start runtime trace")
+	server := NewServer()
+	server.SetRuntimeTrace(true)
+	var foo Foo
+	_ = server.Register(&foo)
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(c2)
+		return c1, nil
+	}
+	client, _ := XDial("tcp@fake:1", &Option{Dialer: dialer})
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	trace.Stop()
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum with runtime trace")
+	_assert(bytes.Contains(buf.Bytes(), []byte("gorpc Foo.Sum")), "expect request task in trace")
+}
+
+func TestClient_DumpPending(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, _ := Dial("tcp", <-addrCh)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply int
+	go func() { _ = client.Call(ctx, "Bar.Timeout", 1, &reply) }()
+	for i := 0; i < 100 && len(client.DumpPending()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pending := client.DumpPending()
+	_assert(len(pending) == 1 && pending[0].ServiceMethod == "Bar.Timeout", "unexpected pending calls %+v", pending)
+	deadline, _ := ctx.Deadline()
+	_assert(pending[0].Deadline.Equal(deadline) && pending[0].Age >= 0, "unexpected pending call %+v", pending[0])
+
+	w := httptest.NewRecorder()
+	client.PendingHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	_assert(strings.Contains(w.Body.String(), `"method":"Bar.Timeout"`), "unexpected pending handler output %s", w.Body.String())
+}
+
+func TestOnError(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var c Calc
+	_ = server.Register(&c)
+	serverErrs := make(chan *ErrorInfo, 10)
+	server.SetOnError(func(info *ErrorInfo) { serverErrs <- info })
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	clientErrs := make(chan *ErrorInfo, 10)
+	client, _ := Dial("tcp", l.Addr().String(), &Option{OnError: func(info *ErrorInfo) { clientErrs <- info }})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Calc.Panic", Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "calc panic"), "expect panic to be returned as error, got %v", err)
+	info := <-serverErrs
+	var pe *PanicError
+	_assert(info.ServiceMethod == "Calc.Panic" && errors.As(info.Err, &pe) && strings.Contains(string(info.Stack), "Calc.Panic"), "unexpected server error info %+v", info)
+	_assert(strings.HasPrefix(info.Peer, "127.0.0.1:"), "expect client address, got %q", info.Peer)
+	info = <-clientErrs
+	_assert(info.ServiceMethod == "Calc.Panic" && info.Peer == l.Addr().String() && info.Stack == nil, "unexpected client error info %+v", info)
+
+	_ = client.Call(context.Background(), "Calc.Missing", Args{}, &reply)
+	info = <-serverErrs
+	_assert(errors.Is(info.Err, ErrMethodNotFound), "expect method not found, got %v", info.Err)
+	_ = client.Call(context.Background(), "Calc.Div", Args{Num1: 1, Num2: 1}, &reply)
+	_assert(len(serverErrs) == 0 && len(clientErrs) == 1, "expect no report for successful calls")
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+	newCalcServer := func(mw ...Middleware) (*Server, *Client) {
+		server := NewServer()
+		var c Calc
+		_ = server.Register(&c)
+		server.Use(mw...)
+		dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go server.ServeConn(c2)
+			return c1, nil
+		}
+		client, _ := XDial("tcp@fake:1", &Option{Dialer: dialer})
+		return server, client
+	}
+	records := make(lineWriter, 2)
+	_, client := newCalcServer(Recorder(records))
+	defer func() { _ = client.Close() }()
+	var reply int
+	ctx := AppendToOutgoingContext(context.Background(), "user", "alice")
+	_ = client.Call(ctx, "Calc.Div", Args{Num1: 6, Num2: 3}, &reply)
+	_ = client.Call(ctx, "Calc.Div", Args{Num1: 6}, &reply)
+	recorded := <-records + <-records
+	_assert(strings.Contains(recorded, `"user":"alice"`) && strings.Contains(recorded, `"error":"divide by zero"`), "unexpected records %s", recorded)
+
+	types, target := newCalcServer()
+	defer func() { _ = target.Close() }()
+	in := recorded + `{"method":"Calc.Missing","args":{}}` + "\n"
+	result, err := NewReplayer(target, types).Replay(context.Background(), strings.NewReader(in))
+	_assert(err == nil && *result == ReplayResult{Calls: 2, Errors: 1, Skipped: 1}, "unexpected replay result %+v %v", result, err)
+
+	in = strings.Replace(recorded, `"reply":2`, `"reply":3`, 1)
+	result, _ = NewReplayer(target, types).Replay(context.Background(), strings.NewReader(in))
+	_assert(result.Mismatches == 1, "expect changed reply to mismatch, got %+v", result)
+}
+
+func TestFaultInjector(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var c Calc
+	_ = server.Register(&c)
+	faults := NewFaultInjector(FaultRule{Method: "Calc.", Percent: 100, Error: "injected"})
+	server.Use(faults.Middleware())
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(c2)
+		return c1, nil
+	}
+	client, _ := XDial("tcp@fake:1", &Option{Dialer: dialer})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Calc.Div", Args{Num1: 4, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == "injected", "expect injected error, got %v", err)
+
+	faults.SetRules(FaultRule{Method: "Calc.Div", Percent: 100, Delay: 50 * time.Millisecond, Drop: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.Call(ctx, "Calc.Div", Args{Num1: 4, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrTimeout) && time.Since(start) >= 200*time.Millisecond, "expect dropped response to time out, got %v", err)
+
+	faults.SetRules(FaultRule{Percent: 0, Error: "never"})
+	err = client.Call(context.Background(), "Calc.Div", Args{Num1: 4, Num2: 2}, &reply)
+	_assert(err == nil && reply == 2, "expect no fault at 0%%, got %v", err)
+}
+
+func TestServer_CodecStats(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	stats := NewClientStats()
+	server.SetCodecStats(stats)
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(c2)
+		return c1, nil
+	}
+	client, _ := XDial("tcp@fake:1", &Option{Dialer: dialer})
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	// 服务端写完响应后才记录编码指标 客户端可能先收到响应
+	key := CodecKey{Codec: codec.GobType, ServiceMethod: "Foo.Sum"}
+	for deadline := time.Now().Add(time.Second); stats.Codecs()[key].Encodes == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	sum := stats.Codecs()[key]
+	_assert(sum.Decodes == 1 && sum.Encodes == 1 && sum.DecodedBytes > 0 && sum.EncodedBytes > 0, "wrong server codec stats %+v", sum)
+}
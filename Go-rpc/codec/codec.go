@@ -9,6 +9,8 @@ type Header struct {
 	Seq uint64
 	// 错误信息
 	Error string
+	// 元数据
+	Metadata map[string]string
 }
 
 // Codec 消息编解码接口
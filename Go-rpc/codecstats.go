@@ -0,0 +1,90 @@
+package gorpc
+
+import (
+	"gorpc/codec"
+	"io"
+	"time"
+)
+
+// CodecStatsHandler 记录每条消息的编解码耗时和大小 耗时不包含网络读写
+// 客户端的 Option.Stats 实现该接口时生效 服务端通过 Server.SetCodecStats 设置
+// 解码大小按从连接读到的字节统计 有预读时可能计入相邻的消息 大量消息的总量是准确的
+type CodecStatsHandler interface {
+	Encode(codecType codec.Type, serviceMethod string, d time.Duration, size int)
+	Decode(codecType codec.Type, serviceMethod string, d time.Duration, size int)
+}
+
+// SetCodecStats 设置服务端的编解码指标回调 HTTP/2 传输不统计
+func (server *Server) SetCodecStats(h CodecStatsHandler) {
+	server.codecStats = h
+}
+
+// timedConn 记录在连接上读写花费的时间和字节数 用于从编解码耗时中扣除IO
+// 读和写各自只在一个协程中进行 (接收协程 / sending 锁内)
+type timedConn struct {
+	io.ReadWriteCloser
+	readTime  time.Duration
+	writeTime time.Duration
+	read      int
+	written   int
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.ReadWriteCloser.Read(p)
+	c.readTime += time.Since(start)
+	c.read += n
+	return n, err
+}
+
+func (c *timedConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.ReadWriteCloser.Write(p)
+	c.writeTime += time.Since(start)
+	c.written += n
+	return n, err
+}
+
+// statsCodec 统计编解码耗时和大小
+type statsCodec struct {
+	codec.Codec
+	conn      *timedConn
+	codecType codec.Type
+	stats     CodecStatsHandler
+	// 当前正在读取的消息
+	method     string
+	decodeTime time.Duration
+	readStart  int
+}
+
+// newStatsCodec 用 f 在 conn 上创建编解码器 h 为nil时不统计
+func newStatsCodec(f codec.NewCodecFunc, codecType codec.Type, conn io.ReadWriteCloser, h CodecStatsHandler) codec.Codec {
+	if h == nil {
+		return f(conn)
+	}
+	tc := &timedConn{ReadWriteCloser: conn}
+	return &statsCodec{Codec: f(tc), conn: tc, codecType: codecType, stats: h}
+}
+
+func (c *statsCodec) Write(h *codec.Header, body interface{}) error {
+	start, ioTime, written := time.Now(), c.conn.writeTime, c.conn.written
+	err := c.Codec.Write(h, body)
+	c.stats.Encode(c.codecType, h.ServiceMethod, time.Since(start)-(c.conn.writeTime-ioTime), c.conn.written-written)
+	return err
+}
+
+func (c *statsCodec) ReadHeader(h *codec.Header) error {
+	start, ioTime, read := time.Now(), c.conn.readTime, c.conn.read
+	err := c.Codec.ReadHeader(h)
+	c.method, c.readStart = h.ServiceMethod, read
+	c.decodeTime = time.Since(start) - (c.conn.readTime - ioTime)
+	return err
+}
+
+func (c *statsCodec) ReadBody(body interface{}) error {
+	start, ioTime := time.Now(), c.conn.readTime
+	err := c.Codec.ReadBody(body)
+	c.decodeTime += time.Since(start) - (c.conn.readTime - ioTime)
+	c.stats.Decode(c.codecType, c.method, c.decodeTime, c.conn.read-c.readStart)
+	return err
+}
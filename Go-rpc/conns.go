@@ -0,0 +1,97 @@
+package gorpc
+
+import (
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// connInfo 连接信息 用于日志、访问日志和调试页面
+type connInfo struct {
+	id       uint64
+	peer     string
+	codec    string
+	start    time.Time
+	closer   io.Closer
+	inFlight int64
+	read     int64
+	written  int64
+	// 双向TLS验证通过的客户端身份
+	identity *Identity
+}
+
+// countingConn 统计读取和写出的字节数
+type countingConn struct {
+	io.ReadWriteCloser
+	info *connInfo
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.info.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.info.written, int64(n))
+	return n, err
+}
+
+// trackConn 记录一个打开的连接 返回的函数在连接关闭时调用
+func (server *Server) trackConn(info *connInfo) func() {
+	info.id = atomic.AddUint64(&server.connSeq, 1)
+	info.start = time.Now()
+	server.conns.Store(info.id, info)
+	return func() { server.conns.Delete(info.id) }
+}
+
+// SetCloseEndpoint 设置为true时 HandleHTTP 挂载 /debug/gorpc/close 调试页面显示关闭连接的按钮
+// 默认不挂载 该接口没有认证 需要在 HandleHTTP 之前设置
+func (server *Server) SetCloseEndpoint(enabled bool) {
+	server.closeEndpoint = enabled
+}
+
+// CloseConn 强制关闭指定id的连接 连接不存在时返回false
+// id 可以在调试页面或 /debug/gorpc.json 中查看
+func (server *Server) CloseConn(id uint64) bool {
+	v, ok := server.conns.Load(id)
+	if !ok {
+		return false
+	}
+	_ = v.(*connInfo).closer.Close()
+	return true
+}
+
+// debugConn 连接信息的快照
+type debugConn struct {
+	ID           uint64        `json:"id"`
+	RemoteAddr   string        `json:"remoteAddr"`
+	Codec        string        `json:"codec"`
+	Uptime       time.Duration `json:"uptime"`
+	InFlight     int64         `json:"inFlight"`
+	BytesRead    int64         `json:"bytesRead"`
+	BytesWritten int64         `json:"bytesWritten"`
+}
+
+// debugConns 按id排序的打开连接 HTTP/2 的stream不计入
+func (server *Server) debugConns() []debugConn {
+	var conns []debugConn
+	now := time.Now()
+	server.conns.Range(func(_, v interface{}) bool {
+		info := v.(*connInfo)
+		conns = append(conns, debugConn{
+			ID:           info.id,
+			RemoteAddr:   info.peer,
+			Codec:        info.codec,
+			Uptime:       now.Sub(info.start).Round(time.Second),
+			InFlight:     atomic.LoadInt64(&info.inFlight),
+			BytesRead:    atomic.LoadInt64(&info.read),
+			BytesWritten: atomic.LoadInt64(&info.written),
+		})
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
@@ -5,24 +5,72 @@
 package gorpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
 )
 
 const debugText = `<html>
+	<head>
+	<meta http-equiv="refresh" content="5">
+	</head>
 	<body>
 	<title>GoRPC Services</title>
-	{{range .}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th>
+		<th align=center>p50</th><th align=center>p95</th><th align=center>p99</th><th align=center>Last Error</th>
+		{{range $name, $m := .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{$name}}({{$m.ArgType}}, {{$m.ReplyType}}) error</td>
+			<td align=center>{{$m.Calls}}</td>
+			<td align=center>{{$m.Errors}}</td>
+			<td align=center>{{$m.P50}}</td>
+			<td align=center>{{$m.P95}}</td>
+			<td align=center>{{$m.P99}}</td>
+			<td align=left>{{if $m.LastError}}{{$m.LastErrorAt.Format "2006-01-02 15:04:05"}} {{$m.LastError}}{{end}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	<hr>
+	Connections
+	<hr>
+		<table>
+		<th align=center>ID</th><th align=center>Remote Address</th><th align=center>Codec</th><th align=center>Uptime</th>
+		<th align=center>In-Flight</th><th align=center>Bytes Read</th><th align=center>Bytes Written</th>{{if .CloseEnabled}}<th></th>{{end}}
+		{{$close := .CloseEnabled}}
+		{{range .Conns}}
+			<tr>
+			<td align=center>{{.ID}}</td>
+			<td align=left>{{.RemoteAddr}}</td>
+			<td align=center>{{.Codec}}</td>
+			<td align=center>{{.Uptime}}</td>
+			<td align=center>{{.InFlight}}</td>
+			<td align=center>{{.BytesRead}}</td>
+			<td align=center>{{.BytesWritten}}</td>
+			{{if $close}}<td><form method="POST" action="` + defaultDebugClose + `"><input type="hidden" name="id" value="{{.ID}}"><input type="submit" value="Close"></form></td>{{end}}
+			</tr>
+		{{end}}
+		</table>
+	{{with .Goroutines}}
+	<hr>
+	Goroutines ({{.Total}} total)
+	<hr>
+		<table>
+		<th align=center>Stage</th><th align=center>Count</th>
+		{{range $stage, $n := .Stages}}
+			<tr>
+			<td align=left>{{$stage}}</td>
+			<td align=center>{{$n}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -36,25 +84,101 @@ type debugHTTP struct {
 	*Server
 }
 
+type debugJSON struct {
+	*Server
+}
+
+type debugClose struct {
+	*Server
+}
+
+// debugPage 调试页面和 /debug/gorpc.json 的内容
+type debugPage struct {
+	Services   []debugService   `json:"services"`
+	Conns      []debugConn      `json:"connections"`
+	Goroutines *debugGoroutines `json:"goroutines,omitempty"`
+	// 是否显示关闭连接的按钮
+	CloseEnabled bool `json:"-"`
+}
+
 type debugService struct {
-	Name   string
-	Method map[string]*methodType
+	Name    string                 `json:"name"`
+	Methods map[string]debugMethod `json:"methods"`
 }
 
-// 路径: /debug/gorpc
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// Build a sorted version of the data.
+// debugMethod 方法指标的快照 JSON中耗时单位为纳秒
+type debugMethod struct {
+	ArgType     string        `json:"argType"`
+	ReplyType   string        `json:"replyType"`
+	Calls       uint64        `json:"calls"`
+	Errors      uint64        `json:"errors"`
+	P50         time.Duration `json:"p50"`
+	P95         time.Duration `json:"p95"`
+	P99         time.Duration `json:"p99"`
+	LastError   string        `json:"lastError,omitempty"`
+	LastErrorAt time.Time     `json:"lastErrorAt"`
+}
+
+// debugServices 按服务名排序的服务及方法指标
+func (server *Server) debugServices() []debugService {
 	var services []debugService
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
 		svc := svci.(*service)
-		services = append(services, debugService{
-			Name:   namei.(string),
-			Method: svc.method,
-		})
+		methods := make(map[string]debugMethod, len(svc.method))
+		for name, m := range svc.method {
+			dm := debugMethod{ArgType: m.ArgType.String(), ReplyType: m.ReplyType.String(), Calls: m.NumCalls(), Errors: m.NumErrors()}
+			dm.P50, dm.P95, dm.P99 = m.Percentiles()
+			dm.LastError, dm.LastErrorAt = m.LastError()
+			methods[name] = dm
+		}
+		services = append(services, debugService{Name: namei.(string), Methods: methods})
 		return true
 	})
-	err := debug.Execute(w, services)
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// 路径: /debug/gorpc
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := debug.Execute(w, &debugPage{Services: server.debugServices(), Conns: server.debugConns(), Goroutines: server.debugGoroutines(), CloseEnabled: server.closeEndpoint})
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+// 路径: /debug/gorpc.json 与调试页面相同的指标
+func (server debugJSON) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&debugPage{Services: server.debugServices(), Conns: server.debugConns(), Goroutines: server.debugGoroutines()})
+}
+
+// sameOrigin 浏览器发起的跨站请求 Origin 或 Referer 的主机与请求的主机不同
+// 两者都没有时视为非浏览器的请求
+func sameOrigin(req *http.Request) bool {
+	for _, key := range []string{"Origin", "Referer"} {
+		if v := req.Header.Get(key); v != "" {
+			u, err := url.Parse(v)
+			return err == nil && u.Host == req.Host
+		}
+	}
+	return true
+}
+
+// 路径: /debug/gorpc/close 强制关闭 id 指定的连接 需要通过 SetCloseEndpoint 启用
+func (server debugClose) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = fmt.Fprintln(w, "405 must POST")
+		return
+	}
+	if !sameOrigin(req) {
+		http.Error(w, "rpc: cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseUint(req.FormValue("id"), 10, 64)
+	if err != nil || !server.CloseConn(id) {
+		http.Error(w, "rpc: no such connection", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, req, defaultDebugPath, http.StatusSeeOther)
+}
@@ -0,0 +1,58 @@
+package gorpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// PendingCall 一个未完成请求的诊断信息
+type PendingCall struct {
+	Seq           uint64        `json:"seq"`
+	ServiceMethod string        `json:"method"`
+	Age           time.Duration `json:"age"`
+	// ctx 的截止时间 没有设置时为零值
+	Deadline time.Time `json:"deadline"`
+}
+
+// DumpPending 返回所有已发出但还没有收到响应的请求 按序列号排序
+// 客户端卡住时用于查看哪些请求一直没有响应
+func (client *Client) DumpPending() []PendingCall {
+	now := time.Now()
+	calls := client.pending.snapshot()
+	out := make([]PendingCall, 0, len(calls))
+	for _, call := range calls {
+		out = append(out, PendingCall{
+			Seq:           call.Seq,
+			ServiceMethod: call.ServiceMethod,
+			Age:           now.Sub(call.start),
+			Deadline:      call.deadline,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Seq < out[j].Seq })
+	return out
+}
+
+// PendingHandler 以JSON返回 DumpPending 的结果 需要自行挂载 例如
+// http.Handle("/debug/gorpc/client/pending", client.PendingHandler())
+func (client *Client) PendingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(client.DumpPending())
+	})
+}
+
+// snapshot 返回所有未完成请求 不移除
+func (m *pendingMap) snapshot() []*Call {
+	var calls []*Call
+	for i := range m {
+		s := &m[i]
+		s.mu.Lock()
+		for _, call := range s.calls {
+			calls = append(calls, call)
+		}
+		s.mu.Unlock()
+	}
+	return calls
+}
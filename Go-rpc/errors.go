@@ -0,0 +1,94 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 可以通过 errors.Is 判断的错误
+var (
+	ErrShutdown            = errors.New("connection is shut down")
+	ErrTooManyPendingCalls = errors.New("rpc client: too many pending calls")
+	ErrCanceled            = errors.New("rpc client: call canceled")
+	ErrRateLimited         = errors.New("rpc client: rate limit exceeded")
+	// ErrTimeout 客户端ctx超时、连接超时、服务端处理超时
+	ErrTimeout = errors.New("rpc: timeout")
+	// ErrServiceNotFound 服务端没有注册该服务
+	ErrServiceNotFound = errors.New("rpc server: can't find service")
+	// ErrMethodNotFound 服务中没有该方法
+	ErrMethodNotFound = errors.New("rpc server: can't find method")
+	// ErrIllFormed 请求的方法名不是 Service.Method 格式
+	ErrIllFormed = errors.New("rpc server: service/method request ill-formed")
+	// ErrHandleTimeout 服务端处理超时 同时满足 errors.Is(err, ErrTimeout)
+	ErrHandleTimeout = &kindError{msg: "rpc server: request handle timeout", kind: ErrTimeout}
+	// ErrServerBusy 服务端正在处理的请求数达到 SetMaxConcurrency 的上限
+	ErrServerBusy = errors.New("rpc server: too many concurrent requests")
+)
+
+// kindError 保留原有错误信息 同时可以被 errors.Is 识别为 kind
+// err 为被包装的原始错误 例如 context.DeadlineExceeded
+type kindError struct {
+	msg  string
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.err }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// ctxError 包装ctx结束的原因 deadline 对应 ErrTimeout 取消对应 ErrCanceled
+func ctxError(prefix string, err error) error {
+	kind := ErrCanceled
+	if err == context.DeadlineExceeded {
+		kind = ErrTimeout
+	}
+	return &kindError{msg: prefix + err.Error(), kind: kind, err: err}
+}
+
+// callFailed 调用等待期间ctx结束
+func callFailed(ctx context.Context) error {
+	return ctxError("rpc client: call failed: ", ctx.Err())
+}
+
+// CallFailed 返回调用等待期间ctx结束时 Client 返回的错误
+// 满足 errors.Is(err, ErrTimeout) 或 errors.Is(err, ErrCanceled) 供模拟客户端等 Caller 实现使用
+func CallFailed(ctx context.Context) error {
+	return callFailed(ctx)
+}
+
+// ServerError 服务端返回的错误
+// 可以通过 errors.As 获取 通过 errors.Is 判断 ErrServiceNotFound 等服务端错误
+type ServerError struct {
+	Message string
+	kind    error
+}
+
+func (e *ServerError) Error() string { return e.Message }
+
+func (e *ServerError) Unwrap() error { return e.kind }
+
+// serverErrorKinds 根据错误信息前缀识别服务端错误类型
+var serverErrorKinds = []error{ErrServiceNotFound, ErrMethodNotFound, ErrIllFormed, ErrHandleTimeout, ErrServerBusy}
+
+// NewServerError 将响应头中的错误信息还原为 ServerError
+func NewServerError(msg string) error {
+	e := &ServerError{Message: msg}
+	for _, kind := range serverErrorKinds {
+		if strings.HasPrefix(msg, kind.Error()) {
+			e.kind = kind
+			break
+		}
+	}
+	return e
+}
+
+// handleTimeoutError 服务端处理超时的错误信息
+func handleTimeoutError(timeout time.Duration) string {
+	return fmt.Sprintf("%s: expect within %s", ErrHandleTimeout, timeout)
+}
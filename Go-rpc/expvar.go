@@ -0,0 +1,45 @@
+package gorpc
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMu 保证检查名字和发布是原子的 expvar.Publish 重名时会panic
+var expvarMu sync.Mutex
+
+// PublishExpvar 通过标准库 expvar 发布服务端指标 默认不发布
+// 指标与 /debug/gorpc.json 相同 另外汇总全部方法的调用和错误次数
+// name 已被占用时返回错误
+func (server *Server) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("rpc server: expvar %q already published", name)
+	}
+	expvar.Publish(name, expvar.Func(server.expvarStats))
+	return nil
+}
+
+// PublishExpvar 默认服务器以 gorpc 为名发布指标
+func PublishExpvar() error {
+	return DefaultServer.PublishExpvar("gorpc")
+}
+
+// expvarStats 每次读取时生成指标快照
+func (server *Server) expvarStats() interface{} {
+	services := server.debugServices()
+	var calls, errors uint64
+	for _, svc := range services {
+		for _, m := range svc.Methods {
+			calls += m.Calls
+			errors += m.Errors
+		}
+	}
+	return struct {
+		Calls    uint64         `json:"calls"`
+		Errors   uint64         `json:"errors"`
+		Services []debugService `json:"services"`
+	}{calls, errors, services}
+}
@@ -0,0 +1,112 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errFaultDrop 注入的丢弃响应 服务端不发送响应
+var errFaultDrop = errors.New("rpc: response dropped by fault injection")
+
+// FaultRule 故障注入规则
+type FaultRule struct {
+	// 匹配的方法 例如 Foo.Sum 以点结尾时匹配整个服务 例如 Foo. 为空时匹配所有方法
+	Method string
+	// 生效的概率 0-100
+	Percent float64
+	// 注入的延迟
+	Delay time.Duration
+	// 丢弃响应 客户端等待直到超时
+	Drop bool
+	// 不为空时 延迟之后返回该错误 不再调用方法
+	Error string
+}
+
+func (r *FaultRule) match(serviceMethod string) bool {
+	return matchMethod(r.Method, serviceMethod)
+}
+
+// matchMethod pattern 为空、与方法相同 或以点结尾且为服务名前缀时匹配
+func matchMethod(pattern, serviceMethod string) bool {
+	if pattern == "" || pattern == serviceMethod {
+		return true
+	}
+	return strings.HasSuffix(pattern, ".") && strings.HasPrefix(serviceMethod, pattern)
+}
+
+// FaultInjector 按方法和概率注入延迟、丢弃响应和错误
+// 用于测试超时、重试和熔断 不需要修改服务代码
+type FaultInjector struct {
+	mu    sync.Mutex // protect rules and rand
+	rules []FaultRule
+	rand  *rand.Rand
+}
+
+// NewFaultInjector 创建故障注入器 每次调用使用第一条命中的规则
+func NewFaultInjector(rules ...FaultRule) *FaultInjector {
+	return &FaultInjector{rules: rules, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// SetRules 替换全部规则 没有规则时不注入
+func (f *FaultInjector) SetRules(rules ...FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+// pick 返回本次调用命中的规则
+func (f *FaultInjector) pick(serviceMethod string) *FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rules {
+		r := &f.rules[i]
+		if r.match(serviceMethod) && f.rand.Float64()*100 < r.Percent {
+			rule := *r
+			return &rule
+		}
+	}
+	return nil
+}
+
+// Inject 按命中的规则等待延迟 返回是否丢弃响应和注入的错误
+// ctx 结束时停止等待并返回ctx的错误
+func (f *FaultInjector) Inject(ctx context.Context, serviceMethod string) (drop bool, err error) {
+	r := f.pick(serviceMethod)
+	if r == nil {
+		return false, nil
+	}
+	if r.Delay > 0 {
+		timer := time.NewTimer(r.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	if r.Error != "" {
+		return false, errors.New(r.Error)
+	}
+	return r.Drop, nil
+}
+
+// Middleware 服务端故障注入中间件
+// 丢弃响应时 服务方法不会被调用 客户端收不到响应
+func (f *FaultInjector) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			drop, err := f.Inject(ctx, call.ServiceMethod)
+			if drop {
+				return errFaultDrop
+			}
+			if err != nil {
+				return err
+			}
+			return next(ctx, call)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package gorpc
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// goStage 服务端协程所处的阶段
+type goStage int
+
+const (
+	// stageConn 处理连接 读取请求的协程
+	stageConn goStage = iota
+	// stageConnDrain 连接已断开 等待未完成的请求
+	stageConnDrain
+	// stageHandle handleRequest 协程 等待调用结束并发送响应
+	stageHandle
+	// stageCall 执行中间件和服务方法的协程
+	stageCall
+	// stageTimeoutWait 已返回超时响应 仍在等待服务方法结束的 handleRequest 协程
+	stageTimeoutWait
+	numGoStages
+)
+
+var goStageNames = [numGoStages]string{"conn", "connDrain", "handleRequest", "call", "timeoutWait"}
+
+// SetGoroutineTracking 设置为true时 按阶段统计每个连接和请求启动的协程
+// 计数长时间不下降说明协程泄漏 例如服务方法超时后一直阻塞
+// 结果在 GoroutineCounts 和调试页面中查看 默认不启用
+func (server *Server) SetGoroutineTracking(enabled bool) {
+	v := int32(0)
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&server.goTracking, v)
+}
+
+// trackGoroutine 当前协程进入 stage 返回离开时调用的函数
+// 启用之前进入的阶段不计数 离开时也不扣减
+func (server *Server) trackGoroutine(stage goStage) func() {
+	if atomic.LoadInt32(&server.goTracking) == 0 {
+		return func() {}
+	}
+	atomic.AddInt64(&server.goStages[stage], 1)
+	return func() { atomic.AddInt64(&server.goStages[stage], -1) }
+}
+
+// GoroutineCounts 返回各阶段的协程数 未启用跟踪时返回nil
+func (server *Server) GoroutineCounts() map[string]int64 {
+	if atomic.LoadInt32(&server.goTracking) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, numGoStages)
+	for i, name := range goStageNames {
+		counts[name] = atomic.LoadInt64(&server.goStages[i])
+	}
+	return counts
+}
+
+// debugGoroutines 调试页面的协程统计
+type debugGoroutines struct {
+	// 进程的协程总数
+	Total  int              `json:"total"`
+	Stages map[string]int64 `json:"stages"`
+}
+
+// debugGoroutines 未启用跟踪时返回nil
+func (server *Server) debugGoroutines() *debugGoroutines {
+	counts := server.GoroutineCounts()
+	if counts == nil {
+		return nil
+	}
+	return &debugGoroutines{Total: runtime.NumGoroutine(), Stages: counts}
+}
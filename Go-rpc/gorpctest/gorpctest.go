@@ -0,0 +1,175 @@
+// Package gorpctest 提供用于单元测试的 gorpc 工具
+package gorpctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorpc"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// HandlerFunc 自定义方法的处理逻辑
+type HandlerFunc func(ctx context.Context, args, reply interface{}) error
+
+// Invocation 一次被记录的调用
+type Invocation struct {
+	ServiceMethod string
+	Args          interface{}
+}
+
+// method 单个方法的预设行为
+type method struct {
+	reply   interface{}
+	err     error
+	latency time.Duration
+	handler HandlerFunc
+}
+
+// MockCaller 实现 gorpc.Caller 的模拟客户端
+// 可以为每个方法预设响应、延迟和错误 不需要真实的服务端
+type MockCaller struct {
+	mu      sync.Mutex // protect following
+	methods map[string]*method
+	calls   []Invocation
+	closed  bool
+}
+
+var _ gorpc.Caller = (*MockCaller)(nil)
+
+// NewMockCaller 创建模拟客户端
+func NewMockCaller() *MockCaller {
+	return &MockCaller{methods: make(map[string]*method)}
+}
+
+func (m *MockCaller) method(serviceMethod string) *method {
+	mt := m.methods[serviceMethod]
+	if mt == nil {
+		mt = &method{}
+		m.methods[serviceMethod] = mt
+	}
+	return mt
+}
+
+// SetResponse 预设方法的返回值和错误 reply 会被复制到调用方的reply中
+func (m *MockCaller) SetResponse(serviceMethod string, reply interface{}, err error) *MockCaller {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt := m.method(serviceMethod)
+	mt.reply, mt.err, mt.handler = reply, err, nil
+	return m
+}
+
+// SetLatency 预设方法的响应延迟
+func (m *MockCaller) SetLatency(serviceMethod string, latency time.Duration) *MockCaller {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.method(serviceMethod).latency = latency
+	return m
+}
+
+// SetHandler 自定义方法的处理逻辑 优先于 SetResponse
+func (m *MockCaller) SetHandler(serviceMethod string, h HandlerFunc) *MockCaller {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.method(serviceMethod).handler = h
+	return m
+}
+
+// Calls 返回所有被记录的调用
+func (m *MockCaller) Calls() []Invocation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := make([]Invocation, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+// Call 按预设行为返回
+func (m *MockCaller) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return gorpc.ErrShutdown
+	}
+	m.calls = append(m.calls, Invocation{ServiceMethod: serviceMethod, Args: args})
+	p := m.methods[serviceMethod]
+	var mt method
+	if p != nil {
+		mt = *p
+	}
+	m.mu.Unlock()
+
+	if p == nil {
+		return gorpc.NewServerError(fmt.Sprintf("%s %s", gorpc.ErrMethodNotFound, serviceMethod))
+	}
+	if mt.latency > 0 {
+		timer := time.NewTimer(mt.latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return gorpc.CallFailed(ctx)
+		case <-timer.C:
+		}
+	}
+	if mt.handler != nil {
+		return mt.handler(ctx, args, reply)
+	}
+	if mt.reply != nil && reply != nil {
+		if err := setReply(reply, mt.reply); err != nil {
+			return err
+		}
+	}
+	return mt.err
+}
+
+// setReply 将预设的返回值复制到调用方的reply中
+func setReply(reply, value interface{}) error {
+	dst := reflect.ValueOf(reply)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.New("gorpctest: reply must be a non-nil pointer")
+	}
+	src := reflect.ValueOf(value)
+	if src.Kind() == reflect.Ptr && src.Type() == dst.Type() {
+		src = src.Elem()
+	}
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("gorpctest: reply type %s is not assignable to %s", src.Type(), dst.Elem().Type())
+	}
+	dst.Elem().Set(src)
+	return nil
+}
+
+// Go 异步调用
+func (m *MockCaller) Go(serviceMethod string, args, reply interface{}, done chan *gorpc.Call) *gorpc.Call {
+	if done == nil {
+		done = make(chan *gorpc.Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &gorpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	go func() {
+		call.Error = m.Call(context.Background(), serviceMethod, args, reply)
+		done <- call
+	}()
+	return call
+}
+
+// Close 关闭后所有调用返回 gorpc.ErrShutdown
+func (m *MockCaller) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return gorpc.ErrShutdown
+	}
+	m.closed = true
+	return nil
+}
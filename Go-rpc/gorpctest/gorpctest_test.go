@@ -0,0 +1,48 @@
+package gorpctest
+
+import (
+	"context"
+	"errors"
+	"gorpc"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+func TestMockCaller(t *testing.T) {
+	m := NewMockCaller().
+		SetResponse("Foo.Sum", 3, nil).
+		SetResponse("Foo.Fail", nil, errors.New("boom")).
+		SetResponse("Foo.Slow", 1, nil).
+		SetLatency("Foo.Slow", time.Second)
+
+	var reply int
+	if err := m.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d %v", reply, err)
+	}
+	if err := m.Call(context.Background(), "Foo.Fail", Args{}, &reply); err == nil || err.Error() != "boom" {
+		t.Fatalf("expect boom, got %v", err)
+	}
+	if err := m.Call(context.Background(), "Foo.Missing", Args{}, &reply); err == nil {
+		t.Fatal("expect an unknown method error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := m.Call(ctx, "Foo.Slow", Args{}, &reply); !errors.Is(err, gorpc.ErrTimeout) {
+		t.Fatalf("expect a timeout error, got %v", err)
+	}
+	// 与真实客户端相同 取消对应 gorpc.ErrCanceled
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := m.Call(ctx, "Foo.Slow", Args{}, &reply); !errors.Is(err, gorpc.ErrCanceled) {
+		t.Fatalf("expect a canceled error, got %v", err)
+	}
+	call := <-m.Go("Foo.Sum", Args{1, 2}, &reply, nil).Done
+	if call.Error != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d %v", reply, call.Error)
+	}
+	if n := len(m.Calls()); n != 6 {
+		t.Fatalf("expect 6 recorded calls, got %d", n)
+	}
+}
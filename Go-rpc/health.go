@@ -0,0 +1,74 @@
+package gorpc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	defaultHealthzPath = "/healthz"
+	defaultReadyzPath  = "/readyz"
+)
+
+// readinessCheck 一项就绪检查
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// AddReadinessCheck 添加就绪检查 check 返回错误时 /readyz 返回503
+// 例如注册中心的心跳 server.AddReadinessCheck("registry", heartbeater.Ready)
+func (server *Server) AddReadinessCheck(name string, check func() error) {
+	server.readyMu.Lock()
+	defer server.readyMu.Unlock()
+	server.readyChecks = append(server.readyChecks, readinessCheck{name: name, check: check})
+}
+
+// SetReadyMaxInFlight 正在处理的请求数达到 n 时 /readyz 返回503 默认0 表示不限制
+func (server *Server) SetReadyMaxInFlight(n int) {
+	atomic.StoreInt64(&server.readyMaxInFlight, int64(n))
+}
+
+// Ready 依次执行就绪检查 返回第一个错误 没有注册服务也视为未就绪
+func (server *Server) Ready() error {
+	if len(server.Services()) == 0 {
+		return fmt.Errorf("rpc server: no service registered")
+	}
+	if max := atomic.LoadInt64(&server.readyMaxInFlight); max > 0 {
+		if n := atomic.LoadInt64(&server.inFlight); n >= max {
+			return fmt.Errorf("rpc server: %d requests in flight, limit %d", n, max)
+		}
+	}
+	server.readyMu.Lock()
+	checks := server.readyChecks
+	server.readyMu.Unlock()
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// HealthzHandler 进程存活检查 总是返回200
+func (server *Server) HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "ok\n")
+	})
+}
+
+// ReadyzHandler 就绪检查 未就绪时返回503及原因
+func (server *Server) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := server.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, err.Error()+"\n")
+			return
+		}
+		_, _ = io.WriteString(w, "ok\n")
+	})
+}
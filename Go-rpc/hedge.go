@@ -0,0 +1,56 @@
+package gorpc
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// hedgedCall 对冲请求
+// 第一次请求超过 Option.HedgeDelay 仍未返回时 再发起一次相同的请求
+// 取最先成功的响应 另一个请求从pending中移除
+func (client *Client) hedgedCall(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	done := make(chan *Call, 2)
+	// 每次请求使用独立的reply 防止并发写入 reply为nil时不需要响应体
+	newReply := func() interface{} {
+		if reply == nil {
+			return nil
+		}
+		return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+	}
+	calls := []*Call{client.goContext(ctx, serviceMethod, args, newReply(), done)}
+	removeAll := func() {
+		for _, call := range calls {
+			if client.removeCall(call.Seq) != nil {
+				call.reportEnd(ErrCanceled)
+			}
+		}
+	}
+	timer := time.NewTimer(client.opt.HedgeDelay)
+	defer timer.Stop()
+	finished := 0
+	for {
+		select {
+		case <-ctx.Done():
+			removeAll()
+			return callFailed(ctx)
+		case <-timer.C:
+			calls = append(calls, client.goContext(ctx, serviceMethod, args, newReply(), done))
+		case call := <-done:
+			finished++
+			if call.Error == nil {
+				removeAll()
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(call.Reply).Elem())
+				}
+				receiveResponseMetadata(ctx, call)
+				return nil
+			}
+			// 没有进行中的请求 返回最后一个错误
+			if finished == len(calls) {
+				receiveResponseMetadata(ctx, call)
+				return call.Error
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package gorpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gorpc/codec"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 每个请求对应一个独立的HTTP/2 stream
+// 请求体/响应体 仍然使用gorpc的编解码器 (Header + Body)
+const (
+	defaultHTTP2Path      = "/gorpc/h2"
+	handleTimeoutHeader   = "X-Gorpc-Handle-Timeout"
+	http2StreamQueueLimit = 64
+)
+
+// httpStream 将一次HTTP请求/响应的body包装为 io.ReadWriteCloser 供编解码器使用
+type httpStream struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (s *httpStream) Read(p []byte) (int, error) {
+	if s.r == nil {
+		return 0, io.EOF
+	}
+	return s.r.Read(p)
+}
+
+func (s *httpStream) Write(p []byte) (int, error) {
+	if s.w == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return s.w.Write(p)
+}
+
+func (s *httpStream) Close() error { return nil }
+
+// StreamError 单个HTTP/2 stream的传输错误 例如连接被拒绝、网关返回502、stream被重置
+// 请求可能没有到达服务端 不是 ServerError 可以换一个实例重试
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return "rpc client: http2 stream error: " + e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error { return e.Err }
+
+// streamErrorCodec 能够报告单个请求传输错误的编解码器
+type streamErrorCodec interface {
+	// streamError 返回上一次 ReadHeader 对应请求的传输错误 没有错误时返回nil
+	streamError() error
+}
+
+// http2Response 一个已完成的stream
+type http2Response struct {
+	seq  uint64
+	body io.ReadCloser
+	err  error
+}
+
+// http2Codec 将 stream-per-call 适配为 codec.Codec
+// Client 的 pending/receive 机制保持不变
+type http2Codec struct {
+	url       string
+	client    *http.Client
+	codecType codec.Type
+	newCodec  codec.NewCodecFunc
+	timeout   time.Duration
+	// 已完成的响应 由 receive 协程依次读取
+	responses chan *http2Response
+	// 当前正在读取的响应 及其传输错误
+	cur     codec.Codec
+	curBody io.ReadCloser
+	curErr  error
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+var _ codec.Codec = (*http2Codec)(nil)
+
+func newHTTP2Codec(url string, opt *Option, f codec.NewCodecFunc) *http2Codec {
+	client := opt.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &http2Codec{
+		url:       url,
+		client:    client,
+		codecType: opt.CodecType,
+		newCodec:  f,
+		timeout:   opt.HandleTimeout,
+		responses: make(chan *http2Response, http2StreamQueueLimit),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Write 编码请求 并在新的stream上异步发送
+func (c *http2Codec) Write(h *codec.Header, body interface{}) error {
+	var buf bytes.Buffer
+	if err := c.newCodec(&httpStream{w: &buf}).Write(h, body); err != nil {
+		return err
+	}
+	go c.roundTrip(h.Seq, &buf)
+	return nil
+}
+
+func (c *http2Codec) roundTrip(seq uint64, body io.Reader) {
+	resp := &http2Response{seq: seq}
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.url, body)
+	if err == nil {
+		req.Header.Set("Content-Type", string(c.codecType))
+		if c.timeout > 0 {
+			req.Header.Set(handleTimeoutHeader, c.timeout.String())
+		}
+		var r *http.Response
+		if r, err = c.client.Do(req); err == nil {
+			if r.StatusCode != http.StatusOK {
+				_ = r.Body.Close()
+				err = fmt.Errorf("unexpected HTTP response: %s", r.Status)
+			} else {
+				resp.body = r.Body
+			}
+		}
+	}
+	resp.err = err
+	select {
+	case c.responses <- resp:
+	case <-c.ctx.Done():
+		if resp.body != nil {
+			_ = resp.body.Close()
+		}
+	}
+}
+
+// ReadHeader 读取下一个完成的stream的响应头
+// 单个stream出错时 只返回序号 错误通过 streamError 交给对应的请求 不影响其他请求
+func (c *http2Codec) ReadHeader(h *codec.Header) error {
+	var resp *http2Response
+	select {
+	case resp = <-c.responses:
+	case <-c.ctx.Done():
+		return io.EOF
+	}
+	c.curErr = nil
+	if resp.err == nil {
+		c.curBody = resp.body
+		c.cur = c.newCodec(&httpStream{r: resp.body})
+		if resp.err = c.cur.ReadHeader(h); resp.err == nil {
+			return nil
+		}
+		c.closeCurrent()
+	}
+	*h = codec.Header{Seq: resp.seq}
+	c.curErr = &StreamError{Err: resp.err}
+	return nil
+}
+
+func (c *http2Codec) streamError() error {
+	return c.curErr
+}
+
+// ReadBody 读取当前stream的响应体 读完即关闭该stream
+func (c *http2Codec) ReadBody(body interface{}) error {
+	if c.cur == nil {
+		return nil
+	}
+	defer c.closeCurrent()
+	return c.cur.ReadBody(body)
+}
+
+func (c *http2Codec) closeCurrent() {
+	if c.curBody != nil {
+		_ = c.curBody.Close()
+	}
+	c.cur, c.curBody = nil, nil
+}
+
+// Close 取消所有进行中的stream
+func (c *http2Codec) Close() error {
+	c.once.Do(c.cancel)
+	return nil
+}
+
+// NewHTTP2Client 创建一个基于HTTP/2 stream-per-call的客户端
+// url 为服务端处理程序的完整地址 例如 https://10.0.0.1:7001/gorpc/h2
+// 使用 Option.HTTPClient 发送请求 默认 http.DefaultClient (TLS下自动协商h2)
+func NewHTTP2Client(url string, opt *Option) (*Client, error) {
+	f := codec.NewCodecFuncMap[opt.CodecType]
+	if f == nil {
+		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
+		Logf(LevelError, "rpc client: codec error: %v", err)
+		return nil, err
+	}
+	client := newClientCodec(newHTTP2Codec(url, opt, f), opt)
+	client.peer = url
+	return client, nil
+}
+
+// DialHTTP2 连接到指定地址的服务器 使用默认的 HTTP/2 RPC 路径
+func DialHTTP2(address string, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewHTTP2Client("https://"+address+defaultHTTP2Path, opt)
+}
+
+// serveHTTP2 处理一个stream上的一次请求
+func (server *Server) serveHTTP2(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = io.WriteString(w, "405 must POST\n")
+		return
+	}
+	codecType := codec.Type(req.Header.Get("Content-Type"))
+	f := codec.NewCodecFuncMap[codecType]
+	if f == nil {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	var timeout time.Duration
+	if v := req.Header.Get(handleTimeoutHeader); v != "" {
+		timeout, _ = time.ParseDuration(v)
+	}
+	w.Header().Set("Content-Type", string(codecType))
+	info := &connInfo{peer: req.RemoteAddr}
+	if req.TLS != nil {
+		info.identity = stateIdentity(*req.TLS)
+	}
+	cc := f(&countingConn{ReadWriteCloser: &httpStream{r: req.Body, w: w}, info: info})
+	sending := new(sync.Mutex)
+	r, err := server.readRequest(cc)
+	if err != nil {
+		if r == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		server.reportError(r.h.ServiceMethod, info.peer, err)
+		r.h.Error = err.Error()
+		server.sendResponse(cc, r.h, invalidRequest, sending, info)
+		return
+	}
+	r.conn = info
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	server.handleRequest(cc, r, sending, wg, timeout)
+}
+
+// HTTP2Handler 返回处理 stream-per-call 请求的 http.Handler
+// 需要挂载在支持HTTP/2的http.Server上 (例如 ListenAndServeTLS)
+func (server *Server) HTTP2Handler() http.Handler {
+	return http.HandlerFunc(server.serveHTTP2)
+}
+
+// HandleHTTP2 在默认路径上注册 HTTP/2 RPC 处理程序
+func (server *Server) HandleHTTP2() {
+	http.Handle(defaultHTTP2Path, server.HTTP2Handler())
+	Logf(LevelInfo, "rpc server http2 path: %s", defaultHTTP2Path)
+}
+
+// HandleHTTP2 默认服务器注册 HTTP/2 处理程序
+func HandleHTTP2() {
+	DefaultServer.HandleHTTP2()
+}
@@ -0,0 +1,83 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTP2Client(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&foo)
+	_ = server.Register(&b)
+	var proto int
+	var mu sync.Mutex
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		proto = req.ProtoMajor
+		mu.Unlock()
+		server.HTTP2Handler().ServeHTTP(w, req)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	client, err := DialHTTP2(strings.TrimPrefix(ts.URL, "https://"), &Option{HTTPClient: ts.Client(), HandleTimeout: time.Second})
+	_assert(err == nil, "failed to create http2 client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply)
+				_assert(err == nil && reply == 2*i, "failed to call Foo.Sum")
+			}(i)
+		}
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		_assert(proto == 2, "expect HTTP/2, got HTTP/%d", proto)
+	})
+	t.Run("server error", func(t *testing.T) {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Missing", Args{}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect a server error")
+		err = client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+		_assert(client.IsAvailable(), "client should survive per-call errors")
+	})
+}
+
+func TestHTTP2Client_StreamError(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	client, err := DialHTTP2(strings.TrimPrefix(ts.URL, "https://"), &Option{HTTPClient: ts.Client()})
+	_assert(err == nil, "failed to create http2 client: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	var serverErr *ServerError
+	var streamErr *StreamError
+	_assert(err != nil && !errors.As(err, &serverErr), "stream failure should not be a server error: %v", err)
+	_assert(errors.As(err, &streamErr), "expect a stream error, got %v", err)
+	_assert(client.IsAvailable(), "client should survive stream errors")
+}
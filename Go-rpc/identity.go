@@ -0,0 +1,44 @@
+package gorpc
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// Identity 已认证的调用方身份 由认证中间件设置 供授权和审计使用
+type Identity struct {
+	// 调用方名称 例如用户名、服务名 双向TLS时为证书的 CN
+	Name string
+	// 双向TLS时客户端证书中的 SAN
+	DNSNames       []string
+	URIs           []string
+	EmailAddresses []string
+	// 双向TLS时验证通过的客户端证书 其他认证方式为nil
+	Certificate *x509.Certificate
+}
+
+type identityKey struct{}
+
+type peerKey struct{}
+
+// WithIdentity 认证中间件在ctx中记录调用方身份 之后的中间件和服务方法可以读取
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext 返回ctx中已认证的调用方身份
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// withPeer 在服务端ctx中记录对端地址
+func withPeer(ctx context.Context, peer string) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFromContext 返回服务端ctx中请求的对端地址 例如 10.0.0.1:51234 无法获取时返回false
+func PeerFromContext(ctx context.Context) (string, bool) {
+	peer, ok := ctx.Value(peerKey{}).(string)
+	return peer, ok && peer != ""
+}
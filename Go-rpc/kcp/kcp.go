@@ -0,0 +1,487 @@
+package kcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// 段命令 前四个与KCP协议一致
+const (
+	cmdPush = 81 // 数据
+	cmdAck  = 82 // 确认
+	cmdWask = 83 // 询问对端窗口
+	cmdWins = 84 // 告知本端窗口
+	// cmdFin 会话关闭 KCP协议没有关闭握手 这是本实现的扩展
+	// 其他KCP实现会把它当作未知命令丢弃 对端只能依赖空闲超时回收会话
+	cmdFin = 85
+)
+
+// 窗口探测标志
+const (
+	askSend = 1 << iota // 需要发送 cmdWask
+	askTell             // 需要发送 cmdWins
+)
+
+const (
+	// overhead 段头长度 conv(4) cmd(1) frg(1) wnd(2) ts(4) sn(4) una(4) len(4)
+	overhead   = 24
+	defaultMTU = 1400
+	defaultWnd = 128
+	// RTO 毫秒 使用nodelay模式的参数
+	rtoMin = 30
+	rtoDef = 200
+	rtoMax = 60000
+	// interval 刷新间隔 毫秒
+	interval = 10
+	// fastResend 被跳过多少次ACK后快速重传
+	fastResend = 2
+	// deadLink 单个段重传次数超过后认为连接失效
+	deadLink = 20
+	// 对端窗口为0时的探测间隔 毫秒
+	probeInit  = 7000
+	probeLimit = 120000
+)
+
+var (
+	errShortPacket = errors.New("kcp: short packet")
+	errConv        = errors.New("kcp: conversation mismatch")
+	errCmd         = errors.New("kcp: unknown command")
+)
+
+// refTime 时间戳的起点 只用于计算差值
+var refTime = time.Now()
+
+func currentMs() uint32 {
+	return uint32(time.Since(refTime) / time.Millisecond)
+}
+
+// timediff 序号/时间戳的差值 处理回绕
+func timediff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// segment 一个KCP段
+type segment struct {
+	conv uint32
+	cmd  uint8
+	frg  uint8
+	wnd  uint16
+	ts   uint32
+	sn   uint32
+	una  uint32
+	data []byte
+	// 以下只在发送端使用
+	rto      uint32
+	xmit     uint32
+	resendts uint32
+	fastack  uint32
+}
+
+// encode 将段追加到b
+func (s *segment) encode(b []byte) []byte {
+	var h [overhead]byte
+	binary.LittleEndian.PutUint32(h[0:], s.conv)
+	h[4] = s.cmd
+	h[5] = s.frg
+	binary.LittleEndian.PutUint16(h[6:], s.wnd)
+	binary.LittleEndian.PutUint32(h[8:], s.ts)
+	binary.LittleEndian.PutUint32(h[12:], s.sn)
+	binary.LittleEndian.PutUint32(h[16:], s.una)
+	binary.LittleEndian.PutUint32(h[20:], uint32(len(s.data)))
+	b = append(b, h[:]...)
+	return append(b, s.data...)
+}
+
+type ackItem struct {
+	sn, ts uint32
+}
+
+// kcp 可靠传输的状态机 只处理段 不负责收发UDP包和并发控制
+// 使用流模式(frg恒为0) 关闭拥塞控制 适合高延迟、有丢包的链路
+type kcp struct {
+	conv, mtu, mss uint32
+
+	sndUna, sndNxt, rcvNxt uint32
+	sndWnd, rcvWnd, rmtWnd uint32
+
+	rxSrtt, rxRttvar int32
+	rxRto            uint32
+
+	current            uint32
+	probe              uint32
+	tsProbe, probeWait uint32
+	// dead 某个段重传次数达到 deadLink
+	dead bool
+	// fin 收到了对端的 cmdFin
+	fin bool
+
+	sndQueue []segment
+	sndBuf   []segment
+	rcvQueue []segment
+	rcvBuf   []segment
+	acklist  []ackItem
+
+	buf    []byte
+	output func([]byte)
+}
+
+func newKCP(conv uint32, output func([]byte)) *kcp {
+	return &kcp{
+		conv:   conv,
+		mtu:    defaultMTU,
+		mss:    defaultMTU - overhead,
+		sndWnd: defaultWnd,
+		rcvWnd: defaultWnd,
+		rmtWnd: defaultWnd,
+		rxRto:  rtoDef,
+		buf:    make([]byte, 0, defaultMTU),
+		output: output,
+	}
+}
+
+// readable 是否有已按序到达的数据
+func (k *kcp) readable() bool {
+	return len(k.rcvQueue) > 0
+}
+
+// waitSnd 等待发送和等待确认的段数
+func (k *kcp) waitSnd() int {
+	return len(k.sndBuf) + len(k.sndQueue)
+}
+
+// recv 读取已按序到达的数据 没有数据时返回0
+func (k *kcp) recv(b []byte) int {
+	full := len(k.rcvQueue) >= int(k.rcvWnd)
+	n := 0
+	for n < len(b) && len(k.rcvQueue) > 0 {
+		seg := &k.rcvQueue[0]
+		c := copy(b[n:], seg.data)
+		n += c
+		if c < len(seg.data) {
+			seg.data = seg.data[c:]
+			break
+		}
+		k.rcvQueue[0] = segment{}
+		k.rcvQueue = k.rcvQueue[1:]
+	}
+	k.moveRcvBuf()
+	// 接收窗口重新打开 主动告知对端
+	if full && len(k.rcvQueue) < int(k.rcvWnd) {
+		k.probe |= askTell
+	}
+	return n
+}
+
+// send 写入待发送的数据 流模式下先填满最后一个段
+func (k *kcp) send(b []byte) {
+	if n := len(k.sndQueue); n > 0 {
+		last := &k.sndQueue[n-1]
+		if room := int(k.mss) - len(last.data); room > 0 {
+			c := room
+			if c > len(b) {
+				c = len(b)
+			}
+			last.data = append(last.data, b[:c]...)
+			b = b[c:]
+		}
+	}
+	for len(b) > 0 {
+		c := int(k.mss)
+		if c > len(b) {
+			c = len(b)
+		}
+		data := make([]byte, c, k.mss)
+		copy(data, b)
+		k.sndQueue = append(k.sndQueue, segment{data: data})
+		b = b[c:]
+	}
+}
+
+// moveRcvBuf 将连续的段从rcvBuf移到rcvQueue
+func (k *kcp) moveRcvBuf() {
+	for len(k.rcvBuf) > 0 && len(k.rcvQueue) < int(k.rcvWnd) {
+		seg := k.rcvBuf[0]
+		if seg.sn != k.rcvNxt {
+			break
+		}
+		k.rcvBuf[0] = segment{}
+		k.rcvBuf = k.rcvBuf[1:]
+		k.rcvQueue = append(k.rcvQueue, seg)
+		k.rcvNxt++
+	}
+}
+
+// updateAck 根据RTT样本更新RTO
+func (k *kcp) updateAck(rtt int32) {
+	if k.rxSrtt == 0 {
+		k.rxSrtt = rtt
+		k.rxRttvar = rtt / 2
+	} else {
+		delta := rtt - k.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		k.rxRttvar = (3*k.rxRttvar + delta) / 4
+		k.rxSrtt = (7*k.rxSrtt + rtt) / 8
+		if k.rxSrtt < 1 {
+			k.rxSrtt = 1
+		}
+	}
+	rto := uint32(k.rxSrtt) + maxU32(interval, uint32(4*k.rxRttvar))
+	k.rxRto = minU32(maxU32(rto, rtoMin), rtoMax)
+}
+
+func (k *kcp) shrinkBuf() {
+	if len(k.sndBuf) > 0 {
+		k.sndUna = k.sndBuf[0].sn
+	} else {
+		k.sndUna = k.sndNxt
+	}
+}
+
+// parseAck 移除被确认的段
+func (k *kcp) parseAck(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i := range k.sndBuf {
+		if k.sndBuf[i].sn == sn {
+			copy(k.sndBuf[i:], k.sndBuf[i+1:])
+			k.sndBuf[len(k.sndBuf)-1] = segment{}
+			k.sndBuf = k.sndBuf[:len(k.sndBuf)-1]
+			return
+		}
+		if timediff(sn, k.sndBuf[i].sn) < 0 {
+			return
+		}
+	}
+}
+
+// parseUna 移除una之前的段
+func (k *kcp) parseUna(una uint32) {
+	n := 0
+	for n < len(k.sndBuf) && timediff(una, k.sndBuf[n].sn) > 0 {
+		k.sndBuf[n] = segment{}
+		n++
+	}
+	k.sndBuf = k.sndBuf[n:]
+}
+
+// parseFastack sn之前未被确认的段 记录一次被跳过
+func (k *kcp) parseFastack(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i := range k.sndBuf {
+		if timediff(sn, k.sndBuf[i].sn) <= 0 {
+			break
+		}
+		k.sndBuf[i].fastack++
+	}
+}
+
+// parseData 按序号插入rcvBuf 重复或超出窗口的丢弃
+func (k *kcp) parseData(seg segment) {
+	if timediff(seg.sn, k.rcvNxt+k.rcvWnd) >= 0 || timediff(seg.sn, k.rcvNxt) < 0 {
+		return
+	}
+	i := len(k.rcvBuf) - 1
+	for ; i >= 0; i-- {
+		d := timediff(seg.sn, k.rcvBuf[i].sn)
+		if d == 0 {
+			return
+		}
+		if d > 0 {
+			break
+		}
+	}
+	k.rcvBuf = append(k.rcvBuf, segment{})
+	copy(k.rcvBuf[i+2:], k.rcvBuf[i+1:])
+	k.rcvBuf[i+1] = seg
+	k.moveRcvBuf()
+}
+
+// input 处理收到的一个UDP包 包内可以有多个段
+func (k *kcp) input(data []byte) error {
+	if len(data) < overhead {
+		return errShortPacket
+	}
+	k.current = currentMs()
+	var maxack uint32
+	acked := false
+	for len(data) >= overhead {
+		conv := binary.LittleEndian.Uint32(data)
+		if conv != k.conv {
+			return errConv
+		}
+		cmd := data[4]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[overhead:]
+		if uint32(len(data)) < length {
+			return errShortPacket
+		}
+		if cmd < cmdPush || cmd > cmdFin {
+			return errCmd
+		}
+		k.rmtWnd = uint32(wnd)
+		k.parseUna(una)
+		k.shrinkBuf()
+		switch cmd {
+		case cmdAck:
+			if rtt := timediff(k.current, ts); rtt >= 0 {
+				k.updateAck(rtt)
+			}
+			k.parseAck(sn)
+			k.shrinkBuf()
+			if !acked || timediff(sn, maxack) > 0 {
+				acked = true
+				maxack = sn
+			}
+		case cmdPush:
+			if timediff(sn, k.rcvNxt+k.rcvWnd) < 0 {
+				k.acklist = append(k.acklist, ackItem{sn: sn, ts: ts})
+				if timediff(sn, k.rcvNxt) >= 0 {
+					k.parseData(segment{sn: sn, data: append([]byte(nil), data[:length]...)})
+				}
+			}
+		case cmdWask:
+			k.probe |= askTell
+		case cmdFin:
+			k.fin = true
+		}
+		data = data[length:]
+	}
+	if acked {
+		k.parseFastack(maxack)
+	}
+	return nil
+}
+
+// finSegment 编码一个 cmdFin 段
+func (k *kcp) finSegment() []byte {
+	seg := segment{conv: k.conv, cmd: cmdFin, wnd: k.wndUnused(), ts: currentMs(), una: k.rcvNxt}
+	return seg.encode(nil)
+}
+
+// keepalive 下次 flush 时告知对端本端窗口 没有数据时用于保活
+func (k *kcp) keepalive() {
+	k.probe |= askTell
+}
+
+// wndUnused 接收窗口剩余大小
+func (k *kcp) wndUnused() uint16 {
+	if n := len(k.rcvQueue); n < int(k.rcvWnd) {
+		return uint16(int(k.rcvWnd) - n)
+	}
+	return 0
+}
+
+// flush 发送ACK、窗口探测、新数据和需要重传的段
+func (k *kcp) flush() {
+	k.current = currentMs()
+	buf := k.buf[:0]
+	emit := func(s *segment) {
+		if len(buf)+overhead+len(s.data) > int(k.mtu) {
+			k.output(buf)
+			buf = k.buf[:0]
+		}
+		buf = s.encode(buf)
+	}
+
+	wnd := k.wndUnused()
+	seg := segment{conv: k.conv, cmd: cmdAck, wnd: wnd, una: k.rcvNxt}
+	for _, ack := range k.acklist {
+		seg.sn, seg.ts = ack.sn, ack.ts
+		emit(&seg)
+	}
+	k.acklist = k.acklist[:0]
+
+	// 对端窗口为0时 定期询问
+	if k.rmtWnd == 0 {
+		if k.probeWait == 0 {
+			k.probeWait = probeInit
+			k.tsProbe = k.current + k.probeWait
+		} else if timediff(k.current, k.tsProbe) >= 0 {
+			k.probeWait = minU32(k.probeWait+k.probeWait/2, probeLimit)
+			k.tsProbe = k.current + k.probeWait
+			k.probe |= askSend
+		}
+	} else {
+		k.tsProbe, k.probeWait = 0, 0
+	}
+	seg.sn, seg.ts = 0, 0
+	if k.probe&askSend != 0 {
+		seg.cmd = cmdWask
+		emit(&seg)
+	}
+	if k.probe&askTell != 0 {
+		seg.cmd = cmdWins
+		emit(&seg)
+	}
+	k.probe = 0
+
+	// 发送窗口内的新数据移入sndBuf
+	cwnd := minU32(k.sndWnd, k.rmtWnd)
+	for len(k.sndQueue) > 0 && timediff(k.sndNxt, k.sndUna+cwnd) < 0 {
+		s := k.sndQueue[0]
+		k.sndQueue[0] = segment{}
+		k.sndQueue = k.sndQueue[1:]
+		s.conv = k.conv
+		s.cmd = cmdPush
+		s.sn = k.sndNxt
+		k.sndNxt++
+		k.sndBuf = append(k.sndBuf, s)
+	}
+
+	for i := range k.sndBuf {
+		s := &k.sndBuf[i]
+		send := false
+		switch {
+		case s.xmit == 0:
+			send = true
+			s.rto = k.rxRto
+			s.resendts = k.current + s.rto
+		case timediff(k.current, s.resendts) >= 0:
+			// 超时重传 RTO只增加一半
+			send = true
+			s.rto = minU32(s.rto+s.rto/2, rtoMax)
+			s.resendts = k.current + s.rto
+		case s.fastack >= fastResend:
+			send = true
+			s.fastack = 0
+			s.resendts = k.current + s.rto
+		}
+		if send {
+			s.xmit++
+			s.ts = k.current
+			s.wnd = wnd
+			s.una = k.rcvNxt
+			emit(s)
+			if s.xmit >= deadLink {
+				k.dead = true
+			}
+		}
+	}
+	if len(buf) > 0 {
+		k.output(buf)
+	}
+}
+
+func minU32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxU32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
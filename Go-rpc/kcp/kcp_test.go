@@ -0,0 +1,219 @@
+package kcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gorpc"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	var foo Foo
+	server := gorpc.NewServer()
+	_ = server.Register(&foo)
+	l, err := gorpc.Listen("kcp@127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := gorpc.XDial("kcp@" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 10; i++ {
+		var reply int
+		if err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply); err != nil || reply != 2*i {
+			t.Fatalf("expect %d, got %d %v", 2*i, reply, err)
+		}
+	}
+
+	// 客户端离开后 服务端会话被回收
+	_ = client.Close()
+	kl := l.(*Listener)
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		kl.mu.Lock()
+		n := len(kl.sessions)
+		kl.mu.Unlock()
+		if n == 0 {
+			return
+		}
+	}
+	t.Fatal("expect the server session to be removed after the client left")
+}
+
+// lossyConn 随机丢弃一部分发出的包
+type lossyConn struct {
+	net.PacketConn
+	mu   sync.Mutex
+	rand *rand.Rand
+	loss float64
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	drop := c.rand.Float64() < c.loss
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func lossy(conn net.PacketConn, seed int64) net.PacketConn {
+	return &lossyConn{PacketConn: conn, rand: rand.New(rand.NewSource(seed)), loss: 0.2}
+}
+
+func TestSession_Lossy(t *testing.T) {
+	sconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newListener(lossy(sconn, 1), &Transport{})
+	defer func() { _ = l.Close() }()
+	cconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newSession(1, lossy(cconn, 2), l.Addr(), nil, &Transport{})
+	go c.readLoop()
+	defer func() { _ = c.Close() }()
+
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(3)).Read(data)
+	go func() {
+		for b := data; len(b) > 0; b = b[4096:] {
+			_, _ = c.Write(b[:4096])
+		}
+	}()
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.SetReadDeadline(time.Now().Add(time.Second * 20))
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data corrupted")
+	}
+}
+
+// accepted 建立一对会话 并确认数据可以到达服务端
+func accepted(t *testing.T, l net.Listener) (client, server *session) {
+	c, err := (&Transport{}).Dial(context.Background(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Write([]byte("ping"))
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(s, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expect ping, got %q %v", buf, err)
+	}
+	return c.(*session), s.(*session)
+}
+
+// waitRemoved 等待会话从监听器中移除 且刷新协程退出
+func waitRemoved(t *testing.T, l *Listener, s *session) {
+	select {
+	case <-s.die:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect the session to be closed")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.sessions) != 0 {
+		t.Fatalf("expect no sessions left, got %d", len(l.sessions))
+	}
+}
+
+func TestSession_Fin(t *testing.T) {
+	lis, err := (&Transport{}).Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lis.(*Listener)
+	defer func() { _ = l.Close() }()
+	c, s := accepted(t, l)
+	_, _ = c.Write([]byte("bye"))
+	time.Sleep(time.Millisecond * 50)
+	// 对端关闭 先读完已到达的数据 然后返回 io.EOF
+	_ = c.Close()
+	_ = s.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(s, buf); err != nil || string(buf) != "bye" {
+		t.Fatalf("expect bye, got %q %v", buf, err)
+	}
+	if _, err := s.Read(buf); err != io.EOF {
+		t.Fatalf("expect io.EOF, got %v", err)
+	}
+	waitRemoved(t, l, s)
+}
+
+func TestSession_IdleTimeout(t *testing.T) {
+	lis, err := (&Transport{IdleTimeout: time.Millisecond * 300}).Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lis.(*Listener)
+	defer func() { _ = l.Close() }()
+	c, s := accepted(t, l)
+	// 对端消失且没有发送 cmdFin 只能等待空闲超时
+	_ = c.conn.Close()
+	_ = s.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := s.Read(make([]byte, 1)); err != errIdle {
+		t.Fatalf("expect idle timeout, got %v", err)
+	}
+	waitRemoved(t, l, s)
+}
+
+func TestSession_KeepAlive(t *testing.T) {
+	lis, err := (&Transport{IdleTimeout: time.Millisecond * 300}).Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lis.(*Listener)
+	defer func() { _ = l.Close() }()
+	c, err := (&Transport{KeepAlive: time.Millisecond * 50}).Dial(context.Background(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	_, _ = c.Write([]byte("ping"))
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 客户端空闲时的保活包使服务端会话不会超时
+	_ = s.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 5)
+	if n, err := s.Read(buf); n != 4 {
+		t.Fatalf("expect ping, got %q %v", buf[:n], err)
+	}
+	if _, err := s.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect the session to stay open until the deadline, got %v", err)
+	}
+}
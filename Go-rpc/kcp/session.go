@@ -0,0 +1,442 @@
+// Package kcp 基于UDP的KCP可靠传输 作为 gorpc 的传输层
+//
+// 高延迟、有丢包的链路上 TCP的重传退避会让吞吐迅速下降
+// KCP以更多的带宽换取更低的延迟：RTO只增加一半、快速重传、不做拥塞控制
+//
+// 导入该包即注册 kcp 协议：
+//
+//	import _ "gorpc/kcp"
+//
+//	lis, _ := gorpc.Listen("kcp@:9999")
+//	client, _ := gorpc.XDial("kcp@10.0.0.1:9999")
+//
+// 段格式与KCP协议一致 不包含FEC和加密
+package kcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"gorpc"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxPacket 单个UDP包的最大长度
+const maxPacket = 65536
+
+// acceptBacklog 等待Accept的会话数 超出后丢弃新会话的包
+const acceptBacklog = 128
+
+// finRepeat 关闭时发送 cmdFin 的次数 UDP可能丢包 不等待确认
+const finRepeat = 3
+
+const (
+	// DefaultIdleTimeout 默认注册的 kcp 传输层的空闲超时
+	DefaultIdleTimeout = time.Minute
+	// defaultKeepAlive 默认的保活间隔 必须明显小于对端的空闲超时
+	defaultKeepAlive = time.Second * 10
+)
+
+var (
+	errDeadLink = errors.New("kcp: dead link")
+	errIdle     = errors.New("kcp: idle timeout")
+)
+
+func init() {
+	gorpc.RegisterTransport("kcp", &Transport{IdleTimeout: DefaultIdleTimeout})
+}
+
+// Transport KCP传输层 实现 gorpc.Transport
+type Transport struct {
+	// IdleTimeout 超过该时间没有收到对端的包 关闭会话 0表示不限制
+	// 对端正常关闭时会发送 cmdFin 进程崩溃或网络中断时只能通过它回收会话
+	IdleTimeout time.Duration
+	// KeepAlive 超过该时间没有发送任何包时 发送一个窗口通告保活 默认10s
+	KeepAlive time.Duration
+}
+
+func (t *Transport) keepAlive() time.Duration {
+	if t.KeepAlive <= 0 {
+		return defaultKeepAlive
+	}
+	return t.KeepAlive
+}
+
+// Dial 建立到addr的会话 KCP没有握手 不会等待对端响应
+func (t *Transport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	s := newSession(binary.LittleEndian.Uint32(b[:]), conn, udpAddr, nil, t)
+	go s.readLoop()
+	return s, nil
+}
+
+// Listen 监听UDP地址
+func (t *Transport) Listen(addr string) (net.Listener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newListener(conn, t), nil
+}
+
+// session 一个KCP会话 实现 net.Conn
+type session struct {
+	conn   net.PacketConn
+	remote net.Addr
+	// l 服务端会话所属的监听器 客户端为nil 自己持有conn
+	l           *Listener
+	idleTimeout time.Duration
+	keepAlive   time.Duration
+
+	mu            sync.Mutex // protect following
+	kcp           *kcp
+	readDeadline  time.Time
+	writeDeadline time.Time
+	lastRecv      time.Time
+	lastSend      time.Time
+	// err 会话关闭的原因
+	err error
+
+	chRead    chan struct{}
+	chWrite   chan struct{}
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func newSession(conv uint32, conn net.PacketConn, remote net.Addr, l *Listener, t *Transport) *session {
+	s := &session{
+		conn:        conn,
+		remote:      remote,
+		l:           l,
+		idleTimeout: t.IdleTimeout,
+		keepAlive:   t.keepAlive(),
+		lastRecv:    time.Now(),
+		lastSend:    time.Now(),
+		chRead:      make(chan struct{}, 1),
+		chWrite:     make(chan struct{}, 1),
+		die:         make(chan struct{}),
+	}
+	// output 只在持有mu时调用
+	s.kcp = newKCP(conv, func(b []byte) {
+		s.lastSend = time.Now()
+		_, _ = conn.WriteTo(b, remote)
+	})
+	go s.updater()
+	return s
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// writable 发送队列未满 调用方持有mu
+func (s *session) writable() bool {
+	return s.kcp.waitSnd() < int(2*s.kcp.sndWnd)
+}
+
+// updater 定期刷新 负责重传和发送ACK
+func (s *session) updater() {
+	ticker := time.NewTicker(interval * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.die:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if time.Since(s.lastSend) >= s.keepAlive {
+			s.kcp.keepalive()
+		}
+		s.kcp.flush()
+		dead := s.kcp.dead
+		idle := s.idleTimeout > 0 && time.Since(s.lastRecv) > s.idleTimeout
+		writable := s.writable()
+		s.mu.Unlock()
+		switch {
+		case dead:
+			s.close(errDeadLink)
+		case idle:
+			s.close(errIdle)
+		case writable:
+			notify(s.chWrite)
+		}
+	}
+}
+
+// input 处理收到的包
+func (s *session) input(data []byte) {
+	s.mu.Lock()
+	if s.kcp.input(data) == nil {
+		s.lastRecv = time.Now()
+	}
+	readable := s.kcp.readable()
+	writable := s.writable()
+	fin := s.kcp.fin
+	s.mu.Unlock()
+	if fin {
+		// 对端已关闭 读完已到达的数据后返回 io.EOF
+		s.close(io.EOF)
+		return
+	}
+	if readable {
+		notify(s.chRead)
+	}
+	if writable {
+		notify(s.chWrite)
+	}
+}
+
+// readLoop 客户端会话独占conn 只接收来自对端的包
+func (s *session) readLoop() {
+	buf := make([]byte, maxPacket)
+	remote := s.remote.String()
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		if addr.String() == remote {
+			s.input(buf[:n])
+		}
+	}
+}
+
+// wait 等待事件或超时
+func (s *session) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+	case <-s.die:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (s *session) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		s.mu.Lock()
+		if n := s.kcp.recv(b); n > 0 {
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		deadline := s.readDeadline
+		s.mu.Unlock()
+		if err := s.wait(s.chRead, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *session) Write(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		if s.writable() {
+			s.kcp.send(b)
+			s.kcp.flush()
+			s.mu.Unlock()
+			return len(b), nil
+		}
+		deadline := s.writeDeadline
+		s.mu.Unlock()
+		if err := s.wait(s.chWrite, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// close 关闭会话 err为之后读写返回的错误
+// 不是因为收到对端的 cmdFin 而关闭时 通知对端
+func (s *session) close(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.kcp.flush()
+		if err != io.EOF {
+			fin := s.kcp.finSegment()
+			for i := 0; i < finRepeat; i++ {
+				_, _ = s.conn.WriteTo(fin, s.remote)
+			}
+		}
+		s.err = err
+		s.mu.Unlock()
+		close(s.die)
+		if s.l != nil {
+			s.l.remove(s)
+		} else {
+			_ = s.conn.Close()
+		}
+	})
+}
+
+func (s *session) Close() error {
+	s.close(net.ErrClosed)
+	return nil
+}
+
+func (s *session) LocalAddr() net.Addr  { return s.conn.LocalAddr() }
+func (s *session) RemoteAddr() net.Addr { return s.remote }
+
+func (s *session) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline, s.writeDeadline = t, t
+	notify(s.chRead)
+	notify(s.chWrite)
+	return nil
+}
+
+func (s *session) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	notify(s.chRead)
+	return nil
+}
+
+func (s *session) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	notify(s.chWrite)
+	return nil
+}
+
+// Listener KCP监听器 按对端地址分发UDP包 实现 net.Listener
+type Listener struct {
+	conn      net.PacketConn
+	transport *Transport
+
+	mu       sync.Mutex // protect following
+	sessions map[string]*session
+	err      error
+
+	accept    chan *session
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func newListener(conn net.PacketConn, t *Transport) *Listener {
+	l := &Listener{
+		conn:      conn,
+		transport: t,
+		sessions:  make(map[string]*session),
+		accept:    make(chan *session, acceptBacklog),
+		die:       make(chan struct{}),
+	}
+	go l.readLoop()
+	return l
+}
+
+// readLoop 未知地址的第一个数据段创建新会话
+// 会话关闭后对端迟到的ACK、cmdFin等不会重新创建会话
+func (l *Listener) readLoop() {
+	buf := make([]byte, maxPacket)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			l.close(err)
+			return
+		}
+		key := addr.String()
+		l.mu.Lock()
+		s, ok := l.sessions[key]
+		if !ok && n >= overhead && buf[4] == cmdPush && len(l.accept) < cap(l.accept) && l.err == nil {
+			s = newSession(binary.LittleEndian.Uint32(buf), l.conn, addr, l, l.transport)
+			l.sessions[key] = s
+			l.accept <- s
+		}
+		l.mu.Unlock()
+		if s != nil {
+			s.input(buf[:n])
+		}
+	}
+}
+
+// remove 会话关闭后移除
+func (l *Listener) remove(s *session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[s.remote.String()] == s {
+		delete(l.sessions, s.remote.String())
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.die:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return nil, l.err
+	}
+}
+
+func (l *Listener) close(err error) {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.err = err
+		sessions := make([]*session, 0, len(l.sessions))
+		for _, s := range l.sessions {
+			sessions = append(sessions, s)
+		}
+		l.mu.Unlock()
+		close(l.die)
+		for _, s := range sessions {
+			s.close(err)
+		}
+		_ = l.conn.Close()
+	})
+}
+
+// Close 停止监听 并关闭所有已建立的会话
+func (l *Listener) Close() error {
+	l.close(net.ErrClosed)
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.conn.LocalAddr() }
@@ -0,0 +1,133 @@
+package gorpc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel 日志级别
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+// ParseLogLevel 解析 debug info warn error 不区分大小写
+func ParseLogLevel(s string) (LogLevel, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("rpc log: unknown level %q", s)
+}
+
+// logLevel 输出的最低级别 默认 info
+var logLevel = int32(LevelInfo)
+
+// SetLogLevel 设置输出的最低日志级别 可以在运行时修改
+func SetLogLevel(l LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(l))
+}
+
+// GetLogLevel 返回当前的日志级别
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+// logSampler 按格式串采样 相同的日志每个周期只输出前 first 条 之后每 thereafter 条输出一条
+type logSampler struct {
+	mu         sync.Mutex // protect counts
+	first      int
+	thereafter int
+	tick       time.Duration
+	counts     map[string]*sampleWindow
+}
+
+// sampleWindow 一种日志在当前周期内的计数
+type sampleWindow struct {
+	start   time.Time
+	n       int
+	dropped int
+}
+
+var (
+	samplerMu sync.RWMutex
+	sampler   *logSampler
+)
+
+// SetLogSampling 设置日志采样 相同格式的日志每个 tick 周期内只输出前 first 条
+// 之后每 thereafter 条输出一条 thereafter 为0时丢弃剩余的日志
+// 下个周期的第一条日志前输出上个周期丢弃的条数 tick 为0时关闭采样
+// 例如 SetLogSampling(10, 100, time.Second) 每秒输出前10条 之后只输出1%
+func SetLogSampling(first, thereafter int, tick time.Duration) {
+	samplerMu.Lock()
+	defer samplerMu.Unlock()
+	if tick <= 0 {
+		sampler = nil
+		return
+	}
+	sampler = &logSampler{first: first, thereafter: thereafter, tick: tick, counts: make(map[string]*sampleWindow)}
+}
+
+// allow 是否输出 key 对应的日志 周期切换时返回上个周期丢弃的条数
+func (s *logSampler) allow(key string, now time.Time) (ok bool, dropped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, exist := s.counts[key]
+	if !exist || now.Sub(w.start) >= s.tick {
+		if exist {
+			dropped = w.dropped
+		}
+		w = &sampleWindow{start: now}
+		s.counts[key] = w
+	}
+	w.n++
+	if w.n <= s.first || (s.thereafter > 0 && (w.n-s.first)%s.thereafter == 0) {
+		return true, dropped
+	}
+	w.dropped++
+	return false, dropped
+}
+
+// Logf 按级别和采样配置输出日志 format 相同的日志视为同一种 参与采样
+// 错误级别的日志同样参与采样
+func Logf(level LogLevel, format string, v ...interface{}) {
+	if level < GetLogLevel() {
+		return
+	}
+	samplerMu.RLock()
+	s := sampler
+	samplerMu.RUnlock()
+	if s != nil {
+		ok, dropped := s.allow(format, time.Now())
+		if dropped > 0 {
+			_ = log.Output(2, fmt.Sprintf("rpc log: sampled out %d messages like %q", dropped, format))
+		}
+		if !ok {
+			return
+		}
+	}
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+}
@@ -46,20 +46,24 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	_ = server.Register(&foo)
 
 	// 服务端注册到注册中心
-	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	registry.Register(registryAddr, registry.Registration{
+		Addr:     "tcp@" + l.Addr().String(),
+		Services: server.Services(),
+	}, 0)
 	wg.Done()
 	server.Accept(l)
 }
 
 // 统一打印日志
-func foo(xc *xclient.XClient, ctx context.Context, typ, serviceMethod string, args *Args) {
+// 单次调用只依赖 gorpc.Caller 广播需要 XClient
+func foo(c gorpc.Caller, ctx context.Context, typ, serviceMethod string, args *Args) {
 	var reply int
 	var err error
 	switch typ {
 	case "call":
-		err = xc.Call(ctx, serviceMethod, args, &reply)
+		err = c.Call(ctx, serviceMethod, args, &reply)
 	case "broadcast":
-		err = xc.Broadcast(ctx, serviceMethod, args, &reply)
+		err = c.(*xclient.XClient).Broadcast(ctx, serviceMethod, args, &reply)
 	}
 	if err != nil {
 		log.Printf("%s %s error: %v", typ, serviceMethod, err)
@@ -97,8 +101,9 @@ func broadcast(registry string) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
 			// 验证超时 时间可以设置2～5s
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*3)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,120 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Metadata 随请求/响应一起传输的元数据
+type Metadata map[string]string
+
+// Copy 返回元数据的副本
+func (md Metadata) Copy() Metadata {
+	if md == nil {
+		return nil
+	}
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+type responseMetadataKey struct{}
+
+// responseMetadata 服务端处理一次请求期间收集的响应元数据
+type responseMetadata struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+func (rm *responseMetadata) set(key, value string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.md == nil {
+		rm.md = make(Metadata)
+	}
+	rm.md[key] = value
+}
+
+func (rm *responseMetadata) get() Metadata {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.md.Copy()
+}
+
+// newResponseMetadataContext 为一次请求准备响应元数据
+func newResponseMetadataContext(ctx context.Context) (context.Context, *responseMetadata) {
+	rm := &responseMetadata{}
+	return context.WithValue(ctx, responseMetadataKey{}, rm), rm
+}
+
+// SetResponseMetadata 在服务方法中设置响应元数据 随响应返回给客户端
+// 例如服务版本、缓存状态、retry-after
+// 服务方法需要以 context.Context 作为第一个参数
+func SetResponseMetadata(ctx context.Context, key, value string) error {
+	rm, ok := ctx.Value(responseMetadataKey{}).(*responseMetadata)
+	if !ok {
+		return errors.New("rpc server: no response metadata in context")
+	}
+	rm.set(key, value)
+	return nil
+}
+
+type responseMetadataReceiverKey struct{}
+
+// WithResponseMetadata 同步调用 Client.Call 时接收响应元数据
+// 调用返回后 *md 为服务端设置的元数据
+func WithResponseMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, responseMetadataReceiverKey{}, md)
+}
+
+// receiveResponseMetadata 将响应元数据写回 WithResponseMetadata 指定的位置
+func receiveResponseMetadata(ctx context.Context, call *Call) {
+	if md, ok := ctx.Value(responseMetadataReceiverKey{}).(*Metadata); ok && md != nil {
+		*md = call.Metadata
+	}
+}
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+// NewOutgoingContext 客户端设置请求元数据 随请求头发送给服务端
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+// AppendToOutgoingContext 在已有的请求元数据上追加键值对 kv 需要成对出现
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	if len(kv)%2 == 1 {
+		panic("rpc: AppendToOutgoingContext got an odd number of input pairs")
+	}
+	md, _ := FromOutgoingContext(ctx)
+	md = md.Copy()
+	if md == nil {
+		md = make(Metadata, len(kv)/2)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return NewOutgoingContext(ctx, md)
+}
+
+// FromOutgoingContext 返回ctx中的请求元数据
+func FromOutgoingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// NewIncomingContext 服务端收到的请求元数据 一般只在测试中使用
+func NewIncomingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+// FromIncomingContext 服务方法中读取客户端发送的请求元数据
+func FromIncomingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md, ok
+}
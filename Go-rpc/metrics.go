@@ -0,0 +1,56 @@
+package gorpc
+
+import (
+	"strings"
+	"time"
+)
+
+// 调用耗时指标名 采用 OpenTelemetry RPC 语义约定 单位毫秒
+const (
+	MetricClientDuration = "rpc.client.duration"
+	MetricServerDuration = "rpc.server.duration"
+)
+
+// Attribute 指标的一个属性
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Meter 推送式指标接口 客户端通过 Option.Meter 设置 服务端通过 Server.SetMeter 设置
+// 可以基于 OpenTelemetry 的 metric.Meter 实现 由SDK推送到collector 也可以使用内置的 OTLPExporter
+// 实现需要并发安全
+type Meter interface {
+	// RecordDuration 在直方图 name 中记录一次调用的耗时
+	RecordDuration(name string, d time.Duration, attrs []Attribute)
+}
+
+// SetMeter 设置服务端的调用指标 耗时从读完请求开始 到响应发出为止
+func (server *Server) SetMeter(m Meter) {
+	server.meter = m
+}
+
+// callAttributes 一次调用的指标属性 rpc.service rpc.method 和调用结果
+func callAttributes(serviceMethod string, err error) []Attribute {
+	service, method := serviceMethod, ""
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		service, method = serviceMethod[:dot], serviceMethod[dot+1:]
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	return []Attribute{
+		{Key: "rpc.system", Value: "gorpc"},
+		{Key: "rpc.service", Value: service},
+		{Key: "rpc.method", Value: method},
+		{Key: "rpc.gorpc.status", Value: status},
+	}
+}
+
+// recordDuration 记录服务端一次调用的耗时
+func (server *Server) recordDuration(serviceMethod string, d time.Duration, err error) {
+	if server.meter != nil {
+		server.meter.RecordDuration(MetricServerDuration, d, callAttributes(serviceMethod, err))
+	}
+}
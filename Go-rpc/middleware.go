@@ -0,0 +1,48 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+)
+
+// ServerCall 服务端一次调用的信息 供中间件读取
+type ServerCall struct {
+	ServiceMethod string
+	Seq           uint64
+	// 对端地址 无法获取时为空
+	Peer string
+	// 请求元数据
+	Metadata Metadata
+	Args     interface{}
+	Reply    interface{}
+	// 写出的响应字节数 next 返回后有效 调用被中间件拒绝时为0
+	BytesWritten int64
+}
+
+// Handler 处理一次调用 并发送响应
+type Handler func(ctx context.Context, call *ServerCall) error
+
+// Middleware 服务端中间件 包装 Handler
+// 不调用 next 直接返回错误时 该错误作为响应返回给客户端
+type Middleware func(next Handler) Handler
+
+// errRejected 中间件既没有调用 next 也没有返回错误
+var errRejected = errors.New("rpc server: call rejected by middleware")
+
+// Use 添加中间件 先添加的在外层
+func (server *Server) Use(mw ...Middleware) {
+	server.mwMu.Lock()
+	defer server.mwMu.Unlock()
+	server.middlewares = append(server.middlewares[:len(server.middlewares):len(server.middlewares)], mw...)
+}
+
+// chain 用中间件包装 h
+func (server *Server) chain(h Handler) Handler {
+	server.mwMu.RLock()
+	mws := server.middlewares
+	server.mwMu.RUnlock()
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -0,0 +1,49 @@
+package gorpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PanicError 服务方法 panic 时返回给客户端的错误 Stack 为 panic 时的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("rpc server: panic: %v", e.Value)
+}
+
+// ErrorInfo 一次失败请求的信息
+type ErrorInfo struct {
+	ServiceMethod string
+	// 服务端为客户端地址 客户端为服务端地址 无法获取时为空
+	Peer string
+	Err  error
+	// 服务方法 panic 时的调用栈 其他错误为nil
+	Stack []byte
+}
+
+// ErrorHandler 请求失败时的回调 可以将错误统一上报到 Sentry 等服务
+// 实现需要并发安全 并且不应阻塞
+type ErrorHandler func(info *ErrorInfo)
+
+// SetOnError 设置服务端请求失败的回调
+// 包括找不到方法、读取请求失败、方法返回错误或 panic、处理超时、被中间件拒绝
+func (server *Server) SetOnError(h ErrorHandler) {
+	server.onError = h
+}
+
+// reportError 调用服务端错误回调
+func (server *Server) reportError(serviceMethod, peer string, err error) {
+	if server.onError == nil || err == nil {
+		return
+	}
+	info := &ErrorInfo{ServiceMethod: serviceMethod, Peer: peer, Err: err}
+	var pe *PanicError
+	if errors.As(err, &pe) {
+		info.Stack = pe.Stack
+	}
+	server.onError(info)
+}
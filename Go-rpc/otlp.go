@@ -0,0 +1,247 @@
+package gorpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistogramBounds 耗时直方图的桶边界 单位毫秒 与 OpenTelemetry SDK 默认值相同
+var DefaultHistogramBounds = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+// OTLPExporter Meter 的内置实现 在本地聚合直方图 按间隔以 OTLP/HTTP JSON 推送到collector
+// 使用累计 (cumulative) 时间性 不依赖 OpenTelemetry SDK
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	resource []Attribute
+	start    time.Time
+
+	mu    sync.Mutex // protect hists
+	hists map[string]*otlpHistogram
+
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// otlpHistogram 一个指标名和属性组合的直方图
+type otlpHistogram struct {
+	name   string
+	attrs  []Attribute
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+	counts []uint64
+}
+
+// NewOTLPExporter 创建推送到 endpoint 的指标导出器 例如 http://otel-collector:4318/v1/metrics
+// interval 为推送间隔 默认10s serviceName 作为资源属性 service.name
+func NewOTLPExporter(endpoint, serviceName string, interval time.Duration) *OTLPExporter {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: interval},
+		resource: []Attribute{{Key: "service.name", Value: serviceName}},
+		start:    time.Now(),
+		hists:    make(map[string]*otlpHistogram),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go e.loop(interval)
+	return e
+}
+
+// RecordDuration 实现 Meter 耗时以毫秒记录
+func (e *OTLPExporter) RecordDuration(name string, d time.Duration, attrs []Attribute) {
+	ms := float64(d) / float64(time.Millisecond)
+	key := histogramKey(name, attrs)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.hists[key]
+	if !ok {
+		h = &otlpHistogram{name: name, attrs: attrs, min: ms, max: ms, counts: make([]uint64, len(DefaultHistogramBounds)+1)}
+		e.hists[key] = h
+	}
+	h.count++
+	h.sum += ms
+	if ms < h.min {
+		h.min = ms
+	}
+	if ms > h.max {
+		h.max = ms
+	}
+	// 桶 i 的范围为 (bounds[i-1], bounds[i]]
+	h.counts[sort.SearchFloat64s(DefaultHistogramBounds, ms)]++
+}
+
+// histogramKey 指标名和属性组成的聚合键
+func histogramKey(name string, attrs []Attribute) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, a := range attrs {
+		sb.WriteString("|" + a.Key + "=" + a.Value)
+	}
+	return sb.String()
+}
+
+func (e *OTLPExporter) loop(interval time.Duration) {
+	defer close(e.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.Flush(context.Background()); err != nil {
+				Logf(LevelWarn, "rpc otlp: push metrics error: %v", err)
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Flush 立即推送当前的指标
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	body, err := json.Marshal(e.export(time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("rpc otlp: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close 停止定时推送 并推送最后一次
+func (e *OTLPExporter) Close() error {
+	e.stopOnce.Do(func() { close(e.done) })
+	<-e.stopped
+	return e.Flush(context.Background())
+}
+
+// OTLP JSON 编码 见 opentelemetry-proto metrics/v1 uint64/fixed64 编码为字符串
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name      string            `json:"name"`
+	Unit      string            `json:"unit"`
+	Histogram otlpHistogramData `json:"histogram"`
+}
+
+type otlpHistogramData struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+	// 2 表示 AGGREGATION_TEMPORALITY_CUMULATIVE
+	AggregationTemporality int `json:"aggregationTemporality"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	Count             uint64         `json:"count,string"`
+	Sum               float64        `json:"sum"`
+	Min               float64        `json:"min"`
+	Max               float64        `json:"max"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: otlpAnyValue{StringValue: a.Value}})
+	}
+	return kvs
+}
+
+// export 将当前的直方图转换为 OTLP 请求 同名指标合并为一个 metric
+func (e *OTLPExporter) export(now time.Time) *otlpMetricsRequest {
+	e.mu.Lock()
+	keys := make([]string, 0, len(e.hists))
+	for k := range e.hists {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var metrics []otlpMetric
+	index := make(map[string]int)
+	for _, k := range keys {
+		h := e.hists[k]
+		buckets := make([]string, len(h.counts))
+		for i, c := range h.counts {
+			buckets[i] = fmt.Sprint(c)
+		}
+		i, ok := index[h.name]
+		if !ok {
+			i = len(metrics)
+			index[h.name] = i
+			metrics = append(metrics, otlpMetric{Name: h.name, Unit: "ms", Histogram: otlpHistogramData{AggregationTemporality: 2}})
+		}
+		metrics[i].Histogram.DataPoints = append(metrics[i].Histogram.DataPoints, otlpDataPoint{
+			Attributes:        otlpAttributes(h.attrs),
+			StartTimeUnixNano: uint64(e.start.UnixNano()),
+			TimeUnixNano:      uint64(now.UnixNano()),
+			Count:             h.count,
+			Sum:               h.sum,
+			Min:               h.min,
+			Max:               h.max,
+			BucketCounts:      buckets,
+			ExplicitBounds:    DefaultHistogramBounds,
+		})
+	}
+	e.mu.Unlock()
+	return &otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: otlpAttributes(e.resource)},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "gorpc"}, Metrics: metrics}},
+	}}}
+}
@@ -0,0 +1,84 @@
+package gorpc
+
+import "sync"
+
+// pendingShards 分片数量 必须是2的幂
+const pendingShards = 32
+
+// pendingShard 一个分片 各自加锁
+type pendingShard struct {
+	mu    sync.Mutex
+	calls map[uint64]*Call
+	// drain 之后不再接受新的请求
+	closed bool
+	// 填充到缓存行大小 避免相邻分片伪共享
+	_ [40]byte
+}
+
+// pendingMap 按序列号分片的未完成请求表
+// 大量并发请求时 注册/移除请求不再竞争同一把锁
+type pendingMap [pendingShards]pendingShard
+
+func newPendingMap() *pendingMap {
+	m := new(pendingMap)
+	for i := range m {
+		m[i].calls = make(map[uint64]*Call)
+	}
+	return m
+}
+
+func (m *pendingMap) shard(seq uint64) *pendingShard {
+	return &m[seq&(pendingShards-1)]
+}
+
+// store 保存请求 已经 drain 的返回false
+func (m *pendingMap) store(call *Call) bool {
+	s := m.shard(call.Seq)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.calls[call.Seq] = call
+	return true
+}
+
+// remove 移除并返回请求 不存在返回nil
+func (m *pendingMap) remove(seq uint64) *Call {
+	s := m.shard(seq)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := s.calls[seq]
+	if call != nil {
+		delete(s.calls, seq)
+	}
+	return call
+}
+
+// drain 移除并返回所有请求 之后 store 都会失败
+func (m *pendingMap) drain() []*Call {
+	var calls []*Call
+	for i := range m {
+		s := &m[i]
+		s.mu.Lock()
+		s.closed = true
+		for seq, call := range s.calls {
+			delete(s.calls, seq)
+			calls = append(calls, call)
+		}
+		s.mu.Unlock()
+	}
+	return calls
+}
+
+// len 未完成的请求数
+func (m *pendingMap) len() int {
+	n := 0
+	for i := range m {
+		s := &m[i]
+		s.mu.Lock()
+		n += len(s.calls)
+		s.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,40 @@
+package gorpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// pingServiceMethod 服务端内置的探活方法 不需要注册
+const pingServiceMethod = "_gorpc.Ping"
+
+// builtin 内置服务
+type builtin struct{}
+
+// Ping 原样返回参数
+func (builtin) Ping(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+var (
+	builtinOnce    sync.Once
+	builtinService *service
+)
+
+// getBuiltinService 内置服务 不出现在 serviceMap 和调试页面中
+func getBuiltinService() *service {
+	builtinOnce.Do(func() {
+		s := &service{name: "_gorpc", typ: reflect.TypeOf(builtin{}), rcvr: reflect.ValueOf(builtin{})}
+		s.registerMethods()
+		builtinService = s
+	})
+	return builtinService
+}
+
+// Ping 向服务端发送一次探活请求 确认连接和Option握手已经完成
+func (client *Client) Ping(ctx context.Context) error {
+	var reply int
+	return client.Call(ctx, pingServiceMethod, 1, &reply)
+}
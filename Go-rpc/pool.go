@@ -0,0 +1,148 @@
+package gorpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool 同一个服务地址上的连接池
+// 单个连接的编解码器是串行的 一个大的响应会阻塞该连接上的其他请求
+// 将请求轮询分散到多个连接上
+type Pool struct {
+	// 服务地址 protocol@addr
+	rpcAddr string
+	opt     *Option
+	mu      sync.Mutex // protect following
+	clients []*Client
+	// 正在建立的连接 与 clients 一一对应
+	dialing []*dialCall
+	// 轮询索引
+	index  int
+	closed bool
+}
+
+// dialCall 一次进行中的连接 同一个位置上并发的请求共享它的结果
+type dialCall struct {
+	done   chan struct{}
+	client *Client
+	err    error
+}
+
+// NewPool 创建连接池 连接数由 Option.PoolSize 决定 默认1
+// 连接在第一次使用时建立
+func NewPool(rpcAddr string, opt *Option) *Pool {
+	size := 1
+	if opt != nil && opt.PoolSize > 1 {
+		size = opt.PoolSize
+	}
+	return &Pool{
+		rpcAddr: rpcAddr,
+		opt:     opt,
+		clients: make([]*Client, size),
+		dialing: make([]*dialCall, size),
+	}
+}
+
+// Get 轮询返回一个可用的Client 不可用时重新建立连接
+func (p *Pool) Get() (*Client, error) {
+	p.mu.Lock()
+	i := p.index % len(p.clients)
+	p.index = (p.index + 1) % len(p.clients)
+	p.mu.Unlock()
+	return p.connect(i)
+}
+
+// connect 返回第i个连接 不可用时重新建立
+// 同一时刻每个位置只有一个协程在建立连接 其余协程等待并共享结果
+// 建立连接期间不持有锁 不会阻塞其他位置上的请求
+func (p *Pool) connect(i int) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	client := p.clients[i]
+	if client != nil && client.IsAvailable() {
+		p.mu.Unlock()
+		return client, nil
+	}
+	if c := p.dialing[i]; c != nil {
+		p.mu.Unlock()
+		<-c.done
+		return c.client, c.err
+	}
+	if client != nil {
+		_ = client.Close()
+		p.clients[i] = nil
+		if p.opt != nil && p.opt.Stats != nil {
+			p.opt.Stats.Reconnect(p.rpcAddr)
+		}
+	}
+	c := &dialCall{done: make(chan struct{})}
+	p.dialing[i] = c
+	p.mu.Unlock()
+
+	c.client, c.err = XDial(p.rpcAddr, p.opt)
+
+	p.mu.Lock()
+	p.dialing[i] = nil
+	if c.err == nil {
+		if p.closed {
+			// 建立连接期间连接池已关闭
+			_ = c.client.Close()
+			c.client, c.err = nil, ErrShutdown
+		} else {
+			p.clients[i] = c.client
+		}
+	}
+	p.mu.Unlock()
+	close(c.done)
+	return c.client, c.err
+}
+
+// WarmUp 提前建立所有连接 Option.PingOnConnect 为true时同时完成一次Ping
+func (p *Pool) WarmUp() error {
+	for i := range p.clients {
+		if _, err := p.connect(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Go 异步调用
+func (p *Pool) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	client, err := p.Get()
+	if err != nil {
+		call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: doneChan(done)}
+		call.done()
+		return call
+	}
+	return client.Go(serviceMethod, args, reply, done)
+}
+
+// Call 同步调用
+func (p *Pool) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	client, err := p.Get()
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+// Close 关闭所有连接
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrShutdown
+	}
+	p.closed = true
+	for i, client := range p.clients {
+		if client != nil {
+			_ = client.Close()
+			p.clients[i] = nil
+		}
+	}
+	return nil
+}
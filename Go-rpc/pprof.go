@@ -0,0 +1,37 @@
+package gorpc
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+const defaultDebugPprof = defaultDebugPath + "/pprof/"
+
+// SetPprof 设置为true时 HandleHTTP 在 /debug/gorpc/pprof/ 下挂载 net/http/pprof
+// 默认不挂载 需要在 HandleHTTP 之前设置
+func (server *Server) SetPprof(enabled bool) {
+	server.pprof = enabled
+}
+
+// debugPprof 按路径分发到 net/http/pprof 的处理函数
+type debugPprof struct{}
+
+// 路径: /debug/gorpc/pprof/ 例如 /debug/gorpc/pprof/heap /debug/gorpc/pprof/profile?seconds=30
+func (debugPprof) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch name := strings.TrimPrefix(req.URL.Path, defaultDebugPprof); name {
+	case "":
+		// 索引页中的链接为相对路径
+		pprof.Index(w, req)
+	case "cmdline":
+		pprof.Cmdline(w, req)
+	case "profile":
+		pprof.Profile(w, req)
+	case "symbol":
+		pprof.Symbol(w, req)
+	case "trace":
+		pprof.Trace(w, req)
+	default:
+		pprof.Handler(name).ServeHTTP(w, req)
+	}
+}
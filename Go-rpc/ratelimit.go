@@ -0,0 +1,113 @@
+package gorpc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限流器 并发安全
+// 可以通过 Option.RateLimiter / Option.MethodRateLimiters 在多个客户端间共享
+type RateLimiter struct {
+	// 每秒生成的令牌数
+	rate float64
+	// 桶容量 允许的突发请求数
+	burst  float64
+	mu     sync.Mutex // protect following
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter 创建限流器 rate 为每秒请求数 burst 为突发上限(至少为1)
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take 尝试取走一个令牌 失败时返回需要等待的时间
+func (l *RateLimiter) take() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// put 归还一个令牌 不超过桶容量
+func (l *RateLimiter) put() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Allow 不等待 有令牌时返回true
+func (l *RateLimiter) Allow() bool {
+	ok, _ := l.take()
+	return ok
+}
+
+// Wait 阻塞直到取得令牌或ctx结束
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		ok, wait := l.take()
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return callFailed(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// limit 依次经过方法级和全局限流器
+// 后一个限流器拒绝时 归还前面已经取走的令牌 被拒绝的调用不消耗配额
+func (client *Client) limit(ctx context.Context, serviceMethod string) error {
+	limiters := []*RateLimiter{client.opt.MethodRateLimiters[serviceMethod], client.opt.RateLimiter}
+	for i, l := range limiters {
+		if l == nil {
+			continue
+		}
+		var err error
+		if client.opt.RateLimitFailFast {
+			if !l.Allow() {
+				err = ErrRateLimited
+			}
+		} else {
+			err = l.Wait(ctx)
+		}
+		if err != nil {
+			for _, taken := range limiters[:i] {
+				if taken != nil {
+					taken.put()
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
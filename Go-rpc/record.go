@@ -0,0 +1,174 @@
+package gorpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Record 录制的一次调用 每行一个JSON对象 参数和返回值以JSON编码
+type Record struct {
+	Time          time.Time       `json:"time"`
+	ServiceMethod string          `json:"method"`
+	Metadata      Metadata        `json:"metadata,omitempty"`
+	Args          json.RawMessage `json:"args"`
+	Reply         json.RawMessage `json:"reply,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Duration      time.Duration   `json:"duration"`
+}
+
+// Recorder 录制流量的中间件 每次调用向 w 写入一条 Record
+// 参数或返回值无法编码为JSON时跳过该调用
+// 例如 server.Use(gorpc.Recorder(f))
+func Recorder(w io.Writer) Middleware {
+	var mu sync.Mutex // protect w
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *ServerCall) error {
+			start := time.Now()
+			err := next(ctx, call)
+			rec := &Record{
+				Time:          start,
+				ServiceMethod: call.ServiceMethod,
+				Metadata:      call.Metadata,
+				Duration:      time.Since(start),
+			}
+			var merr error
+			if rec.Args, merr = json.Marshal(call.Args); merr == nil && err == nil {
+				rec.Reply, merr = json.Marshal(call.Reply)
+			}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			line, _ := json.Marshal(rec)
+			if merr != nil {
+				Logf(LevelWarn, "rpc server: record %s error: %v", call.ServiceMethod, merr)
+				return err
+			}
+			mu.Lock()
+			_, _ = w.Write(append(line, '\n'))
+			mu.Unlock()
+			return err
+		}
+	}
+}
+
+// ReplayResult 回放的统计结果
+type ReplayResult struct {
+	// 回放的调用数
+	Calls int
+	// 回放时出错的调用数
+	Errors int
+	// 返回值或错误与录制时不同的调用数
+	Mismatches int
+	// 本地没有注册 无法回放的调用数
+	Skipped int
+}
+
+// Replayer 将录制的流量重新发送到服务端 例如对比候选版本与线上版本的返回值
+type Replayer struct {
+	client *Client
+	// 提供参数和返回值类型的本地服务 只用于解码 不会被调用
+	types *Server
+	speed float64
+}
+
+// NewReplayer 创建回放器 types 中需要注册与录制时相同的服务
+func NewReplayer(client *Client, types *Server) *Replayer {
+	return &Replayer{client: client, types: types}
+}
+
+// SetSpeed 设置回放速度 1 按录制时的间隔发送 2 为两倍速
+// 默认0 表示不等待 尽快发送
+func (r *Replayer) SetSpeed(speed float64) {
+	r.speed = speed
+}
+
+// Replay 读取 Recorder 写入的记录并依次回放 等待所有调用结束后返回
+func (r *Replayer) Replay(ctx context.Context, in io.Reader) (*ReplayResult, error) {
+	result := &ReplayResult{}
+	var mu sync.Mutex // protect result
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var first time.Time
+	began := time.Now()
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return result, fmt.Errorf("rpc replay: bad record: %w", err)
+		}
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if r.speed > 0 {
+			wait := time.Duration(float64(rec.Time.Sub(first))/r.speed) - time.Since(began)
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return result, ctx.Err()
+				}
+			}
+		}
+		argv, replyv, ok := r.newValues(&rec)
+		mu.Lock()
+		if !ok {
+			result.Skipped++
+			mu.Unlock()
+			continue
+		}
+		result.Calls++
+		mu.Unlock()
+		wg.Add(1)
+		go func(rec Record) {
+			defer wg.Done()
+			callCtx := ctx
+			if rec.Metadata != nil {
+				callCtx = NewOutgoingContext(ctx, rec.Metadata)
+			}
+			err := r.client.Call(callCtx, rec.ServiceMethod, argv.Interface(), replyv.Interface())
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors++
+			}
+			if !sameResult(&rec, replyv, err) {
+				result.Mismatches++
+			}
+		}(rec)
+	}
+	return result, scanner.Err()
+}
+
+// newValues 按本地注册的方法创建参数和返回值 并解码录制的参数
+func (r *Replayer) newValues(rec *Record) (argv, replyv reflect.Value, ok bool) {
+	_, mtype, err := r.types.findService(rec.ServiceMethod)
+	if err != nil {
+		return argv, replyv, false
+	}
+	argv, replyv = mtype.newArgv(), mtype.newReplyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err := json.Unmarshal(rec.Args, argvi); err != nil {
+		return argv, replyv, false
+	}
+	return argv, replyv, true
+}
+
+// sameResult 回放结果是否与录制时一致
+func sameResult(rec *Record, replyv reflect.Value, err error) bool {
+	if err != nil || rec.Error != "" {
+		return err != nil && err.Error() == rec.Error
+	}
+	reply, merr := json.Marshal(replyv.Interface())
+	return merr == nil && bytes.Equal(reply, rec.Reply)
+}
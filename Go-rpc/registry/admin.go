@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const adminPath = "/admin"
+
+const adminText = `<html>
+	<body>
+	<title>GoRPC Registry</title>
+	<h3>Registered instances ({{len .}})</h3>
+	<table border=1 cellpadding=4>
+	<tr>
+	<th>Addr</th><th>Services</th><th>State</th><th>Weight</th><th>Metadata</th>
+	<th>Last heartbeat</th><th>TTL</th><th>Expires in</th><th></th>
+	</tr>
+	{{range .}}
+		<tr>
+		<td>{{.Addr}}</td>
+		<td>{{.Services}}</td>
+		<td>{{.State}}</td>
+		<td align=center>{{.Weight}}</td>
+		<td>{{.Metadata}}</td>
+		<td>{{.Heartbeat}} ago</td>
+		<td>{{.TTL}}</td>
+		<td>{{.Expires}}</td>
+		<td>
+		<form method=post style="display:inline">
+		<input type=hidden name=addr value="{{.Addr}}">
+		{{if eq .State "draining"}}
+		<button name=action value=undrain>Undrain</button>
+		{{else}}
+		<button name=action value=drain>Drain</button>
+		{{end}}
+		<button name=action value=deregister>Deregister</button>
+		</form>
+		</td>
+		</tr>
+	{{end}}
+	</table>
+	</body>
+	</html>`
+
+var admin = template.Must(template.New("registry admin").Parse(adminText))
+
+// adminRow 管理页面中的一行
+type adminRow struct {
+	Addr      string
+	Services  string
+	State     string
+	Weight    int
+	Metadata  string
+	Heartbeat time.Duration
+	TTL       string
+	Expires   string
+}
+
+// adminRows 在持有锁时复制实例信息 避免与续约竞争
+func (r *GoRegistry) adminRows(name string) []adminRow {
+	alive, _ := r.snapshot(name)
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rows := make([]adminRow, 0, len(alive))
+	for _, s := range alive {
+		row := adminRow{
+			Addr:      s.Addr,
+			Services:  strings.Join(s.Services, ", "),
+			State:     s.State,
+			Weight:    s.Weight,
+			Heartbeat: now.Sub(s.heartbeat).Truncate(time.Second),
+			TTL:       "never expires",
+			Expires:   "-",
+		}
+		if row.State == "" {
+			row.State = StateActive
+		}
+		keys := make([]string, 0, len(s.Metadata))
+		for k, v := range s.Metadata {
+			keys = append(keys, k+"="+v)
+		}
+		sort.Strings(keys)
+		row.Metadata = strings.Join(keys, " ")
+		if s.TTL > 0 {
+			row.TTL = s.TTL.String()
+			row.Expires = s.expire.Sub(now).Truncate(time.Second).String()
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// sameOrigin 浏览器发起的跨站请求 Origin 或 Referer 的主机与请求的主机不同
+// 浏览器会缓存 Basic 认证并附加到跨站表单提交中 两者都没有时视为非浏览器的请求
+func sameOrigin(req *http.Request) bool {
+	for _, key := range []string{"Origin", "Referer"} {
+		if v := req.Header.Get(key); v != "" {
+			u, err := url.Parse(v)
+			return err == nil && u.Host == req.Host
+		}
+	}
+	return true
+}
+
+// 路径: /_gorpc_/registry/admin
+// GET 列出实例 POST 对实例执行 drain/undrain/deregister 写操作同样需要令牌 并拒绝跨站请求
+func (r *GoRegistry) serveAdmin(w http.ResponseWriter, req *http.Request, name string) {
+	switch req.Method {
+	case "GET":
+		if err := admin.Execute(w, r.adminRows(name)); err != nil {
+			_, _ = fmt.Fprintln(w, "rpc registry: error executing template:", err.Error())
+		}
+	case "POST":
+		if !sameOrigin(req) {
+			http.Error(w, "rpc registry: cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		addr := req.FormValue("addr")
+		switch req.FormValue("action") {
+		case "drain":
+			if s := r.setState(name, addr, StateDraining); s != nil {
+				r.replicate(name, s)
+			}
+		case "undrain":
+			if s := r.setState(name, addr, StateActive); s != nil {
+				r.replicate(name, s)
+			}
+		case "deregister":
+			r.removeServer(name, addr)
+			r.replicateRemove(name, addr)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		// 303 回到列表页
+		http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+	default:
+		// 405
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
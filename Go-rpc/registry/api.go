@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// JSON接口 路径相对于注册中心地址 例如 /_gorpc_/registry/v1/servers
+// 服务列表放在响应体中 不受HTTP头大小限制
+const v1ServersPath = "/v1/servers"
+
+// ServerList GET /v1/servers 的响应体
+type ServerList struct {
+	Servers []*ServerStatus `json:"servers"`
+	// 变化序号 作为下一次阻塞查询的 index 参数
+	Index uint64 `json:"index"`
+}
+
+// ServerStatus 实例信息及其健康状况 客户端可以优先选择最近确认存活的实例
+type ServerStatus struct {
+	*ServerItem
+	// 最近一次注册或续约的时间
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	// 距离租约过期的剩余时间 租约永不过期时为0
+	Remaining time.Duration `json:"remaining,omitempty"`
+}
+
+// statuses 读取实例的心跳时间 心跳在原实例上更新 需持有锁读取
+func (r *GoRegistry) statuses(items []*ServerItem) []*ServerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	list := make([]*ServerStatus, 0, len(items))
+	for _, s := range items {
+		status := &ServerStatus{ServerItem: s, LastHeartbeat: s.heartbeat}
+		if s.TTL > 0 {
+			status.Remaining = s.expire.Sub(now)
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// RegisterRequest POST /v1/servers 的请求体
+// 携带 Lease 时为续约 其余字段被忽略
+// 集群节点间同步时同时携带 Lease 与完整的实例信息
+type RegisterRequest struct {
+	Registration
+	Lease string `json:"lease,omitempty"`
+}
+
+// LeaseResponse POST /v1/servers 的响应体
+type LeaseResponse struct {
+	Lease string `json:"lease"`
+	// 租约时长 为0表示永不过期
+	TTL time.Duration `json:"ttl"`
+}
+
+// DeregisterRequest DELETE /v1/servers 的请求体
+type DeregisterRequest struct {
+	Addr string `json:"addr"`
+}
+
+// errorResponse 出错时的响应体
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (r *GoRegistry) serveV1Servers(w http.ResponseWriter, req *http.Request, name string) {
+	switch req.Method {
+	// 返回可用服务列表
+	case "GET":
+		alive, index := r.blockingSnapshot(req, name)
+		w.Header().Set("X-Gorpc-Index", strconv.FormatUint(index, 10))
+		writeJSON(w, http.StatusOK, &ServerList{Servers: r.statuses(alive), Index: index})
+	// 添加服务实例/续约
+	case "POST":
+		var body RegisterRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: err.Error()})
+			return
+		}
+		replicated := r.isReplicated(req)
+		if replicated && body.Addr != "" {
+			if err := r.validate(&body.Registration); err != nil {
+				writeJSON(w, http.StatusBadRequest, &errorResponse{Error: err.Error()})
+				return
+			}
+			// 其他节点同步的实例 沿用其租约
+			s := r.putServer(name, &body.Registration, body.Lease)
+			writeJSON(w, http.StatusOK, &LeaseResponse{Lease: s.Lease, TTL: s.TTL})
+			return
+		}
+		if body.Lease != "" {
+			s := r.renewLease(name, body.Lease)
+			if s == nil {
+				writeJSON(w, http.StatusNotFound, &errorResponse{Error: "lease not found"})
+				return
+			}
+			if !replicated {
+				r.replicate(name, s)
+			}
+			writeJSON(w, http.StatusOK, &LeaseResponse{Lease: s.Lease, TTL: s.TTL})
+			return
+		}
+		reg := &body.Registration
+		if reg.Addr == "" {
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: "missing addr"})
+			return
+		}
+		if reg.Weight <= 0 && reg.Metadata != nil {
+			reg.Weight, _ = strconv.Atoi(reg.Metadata["weight"])
+		}
+		s, err := r.register(name, reg, sourceIP(req))
+		if err != nil {
+			writeJSON(w, registerErrorStatus(err), &errorResponse{Error: err.Error()})
+			return
+		}
+		if !replicated {
+			r.replicate(name, s)
+		}
+		writeJSON(w, http.StatusOK, &LeaseResponse{Lease: s.Lease, TTL: s.TTL})
+	// 注销服务实例
+	case "DELETE":
+		var body DeregisterRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Addr == "" {
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: "missing addr"})
+			return
+		}
+		r.removeServer(name, body.Addr)
+		if !r.isReplicated(req) {
+			r.replicateRemove(name, body.Addr)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: "method not allowed"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"encoding/json"
+	"gorpc"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 审计日志 记录实例的注册 续约 过期 注销及状态变化
+// 内存中保留最近 auditSize 条 可通过 registryPath+"/events" 查询
+const auditPath = "/events"
+
+const defaultAuditSize = 1024
+
+// maxAuditPending 等待持久化的记录数上限 写入跟不上时丢弃新的记录
+const maxAuditPending = 4096
+
+// 事件类型
+const (
+	EventRegister   = "register"
+	EventRenew      = "renew"
+	EventExpire     = "expire"
+	EventDeregister = "deregister"
+	EventState      = "state"
+)
+
+// Event 一条审计记录
+type Event struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace,omitempty"`
+	Addr      string    `json:"addr"`
+	Type      string    `json:"type"`
+	// 附加信息 例如状态变化后的状态
+	Detail string `json:"detail,omitempty"`
+}
+
+// auditLog 定长环形缓冲
+type auditLog struct {
+	events []Event
+	next   int
+	full   bool
+	// 持久化 每条记录以一行JSON写入
+	w *auditWriter
+}
+
+// auditWriter 在独立的goroutine中写入持久化目标 慢速写入不会阻塞注册中心的锁
+type auditWriter struct {
+	w io.Writer
+
+	mu      sync.Mutex // protect following
+	pending [][]byte
+	dropped int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAuditWriter(w io.Writer) *auditWriter {
+	aw := &auditWriter{
+		w:    w,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+// enqueue 加入一行待写入的记录 不会阻塞
+func (aw *auditWriter) enqueue(line []byte) {
+	aw.mu.Lock()
+	if len(aw.pending) < maxAuditPending {
+		aw.pending = append(aw.pending, line)
+	} else {
+		aw.dropped++
+	}
+	aw.mu.Unlock()
+	select {
+	case aw.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (aw *auditWriter) run() {
+	defer close(aw.done)
+	for {
+		select {
+		case <-aw.wake:
+			aw.flush()
+		case <-aw.stop:
+			aw.flush()
+			return
+		}
+	}
+}
+
+func (aw *auditWriter) flush() {
+	aw.mu.Lock()
+	lines, dropped := aw.pending, aw.dropped
+	aw.pending, aw.dropped = nil, 0
+	aw.mu.Unlock()
+	if dropped > 0 {
+		gorpc.Logf(gorpc.LevelError, "rpc registry: audit log is falling behind, dropped %d events", dropped)
+	}
+	for _, line := range lines {
+		if _, err := aw.w.Write(line); err != nil {
+			gorpc.Logf(gorpc.LevelError, "rpc registry: write audit log err: %v", err)
+		}
+	}
+}
+
+// close 写完剩余的记录后退出
+func (aw *auditWriter) close() {
+	close(aw.stop)
+	<-aw.done
+}
+
+// SetAuditLog 设置内存中保留的记录数 以及可选的持久化目标(例如打开的文件)
+// size 不大于0时使用默认值1024 w 为nil表示不持久化
+// 持久化在后台进行 替换时等待之前的目标写完剩余的记录
+func (r *GoRegistry) SetAuditLog(size int, w io.Writer) {
+	if size <= 0 {
+		size = defaultAuditSize
+	}
+	r.mu.Lock()
+	prev := r.audit
+	events := r.eventsLocked()
+	r.audit = &auditLog{events: make([]Event, size)}
+	if w != nil {
+		r.audit.w = newAuditWriter(w)
+	}
+	for _, e := range events {
+		r.audit.add(e)
+	}
+	r.mu.Unlock()
+	if prev != nil && prev.w != nil {
+		prev.w.close()
+	}
+}
+
+// recordLocked 记录一条当前发生的事件 调用时需持有锁
+func (r *GoRegistry) recordLocked(name, addr, typ, detail string) {
+	r.recordAtLocked(time.Now(), name, addr, typ, detail)
+}
+
+// recordAtLocked 记录一条在t时刻发生的事件 调用时需持有锁
+func (r *GoRegistry) recordAtLocked(t time.Time, name, addr, typ, detail string) {
+	if r.audit == nil {
+		r.audit = &auditLog{events: make([]Event, defaultAuditSize)}
+	}
+	e := Event{Time: t, Namespace: name, Addr: addr, Type: typ, Detail: detail}
+	r.audit.add(e)
+	if r.audit.w != nil {
+		data, _ := json.Marshal(&e)
+		r.audit.w.enqueue(append(data, '\n'))
+	}
+}
+
+func (a *auditLog) add(e Event) {
+	a.events[a.next] = e
+	a.next = (a.next + 1) % len(a.events)
+	if a.next == 0 {
+		a.full = true
+	}
+}
+
+// eventsLocked 按时间顺序返回所有记录 调用时需持有锁
+func (r *GoRegistry) eventsLocked() []Event {
+	a := r.audit
+	if a == nil {
+		return nil
+	}
+	if !a.full {
+		return append([]Event(nil), a.events[:a.next]...)
+	}
+	return append(append([]Event(nil), a.events[a.next:]...), a.events[:a.next]...)
+}
+
+// 路径: /_gorpc_/registry/events
+// 可选参数 addr type since(RFC3339) limit(只返回最近的limit条)
+func (r *GoRegistry) serveEvents(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != "GET" {
+		// 405
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	since, _ := time.Parse(time.RFC3339, q.Get("since"))
+	r.mu.Lock()
+	all := r.eventsLocked()
+	r.mu.Unlock()
+	events := make([]Event, 0, len(all))
+	for _, e := range all {
+		if e.Namespace != name ||
+			(q.Get("addr") != "" && e.Addr != q.Get("addr")) ||
+			(q.Get("type") != "" && e.Type != q.Get("type")) ||
+			e.Time.Before(since) {
+			continue
+		}
+		events = append(events, e)
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 && limit < len(events) {
+		events = events[len(events)-limit:]
+	}
+	writeJSON(w, http.StatusOK, map[string][]Event{"events": events})
+}
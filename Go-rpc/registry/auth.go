@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// SetToken 设置写请求(注册 续约 注销)所需的令牌 为空表示不鉴权
+// 客户端通过 Authorization: Bearer <token> 携带令牌
+// 也可以把令牌写在注册中心地址中 例如 http://:token@10.0.0.1:9999/_gorpc_/registry
+// 此时 net/http 以 Basic 认证发送 密码部分即为令牌
+// 集群节点之间同步同样需要令牌 SetPeers 的地址中应带上令牌
+func (r *GoRegistry) SetToken(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = token
+}
+
+// authorized 校验写请求的令牌 GET 请求不需要鉴权
+func (r *GoRegistry) authorized(req *http.Request) bool {
+	if req.Method == "GET" {
+		return true
+	}
+	var got string
+	if _, password, ok := req.BasicAuth(); ok {
+		got = password
+	} else {
+		got = bearerToken(req.Header.Get("Authorization"))
+	}
+	return r.checkToken(got)
+}
+
+// checkToken 未设置令牌时总是通过
+func (r *GoRegistry) checkToken(got string) bool {
+	r.mu.Lock()
+	token := r.token
+	r.mu.Unlock()
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// bearerToken 解析 Bearer <token> 格式的认证信息
+func bearerToken(auth string) string {
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
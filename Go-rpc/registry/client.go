@@ -0,0 +1,274 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorpc"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLeaseNotFound 续约时注册中心找不到该租约 (已过期或注册中心重启) 需要重新注册
+var ErrLeaseNotFound = errors.New("rpc registry: lease not found")
+
+// Client 注册中心客户端 在集群的各节点间依次尝试直到成功
+// 注册 续约和注销使用HTTP头接口 兼容旧版本注册中心 查询使用JSON接口
+type Client struct {
+	// 注册中心节点地址 注册到命名空间时附加 /{namespace}
+	registries []string
+	httpClient *http.Client
+	// 单次请求的超时时间
+	timeout time.Duration
+	// 写请求的令牌 以 Bearer 方式发送
+	token string
+}
+
+// NewClient 创建注册中心客户端 registry 可以是以,分隔的多个集群节点地址
+// 例如 http://10.0.0.1:9999/_gorpc_/registry,http://10.0.0.2:9999/_gorpc_/registry
+func NewClient(registry string) *Client {
+	return &Client{
+		registries: splitRegistries(registry),
+		httpClient: http.DefaultClient,
+		timeout:    heartbeatTimeout,
+	}
+}
+
+// SetHTTPClient 使用自定义的HTTP客户端 例如配置了TLS的客户端 应在使用前调用
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.httpClient = client
+}
+
+// SetTimeout 设置单次请求的超时时间 默认10s 应在使用前调用
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetToken 设置注册中心要求的写令牌 见 GoRegistry.SetToken 应在使用前调用
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// do 发送请求 状态码为200且 out 不为nil时解析JSON响应体 返回的响应体已关闭
+func (c *Client) do(req *http.Request, timeout time.Duration, out interface{}) (*http.Response, error) {
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp, err
+}
+
+// Register 注册实例 返回租约ID 之后通过 Renew 续约
+func (c *Client) Register(reg Registration) (string, error) {
+	err := errors.New("rpc registry: no registry address")
+	for _, registry := range c.registries {
+		var lease string
+		if lease, err = c.registerAt(registry, &reg); err == nil {
+			return lease, nil
+		}
+	}
+	return "", err
+}
+
+func (c *Client) registerAt(registry string, reg *Registration) (string, error) {
+	req, err := http.NewRequest("POST", registry, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Gorpc-Server", reg.Addr)
+	if reg.Weight > 0 {
+		req.Header.Set("X-Gorpc-Weight", strconv.Itoa(reg.Weight))
+	}
+	if len(reg.Services) > 0 {
+		req.Header.Set("X-Gorpc-Services", strings.Join(reg.Services, ";"))
+	}
+	if len(reg.Metadata) > 0 {
+		req.Header.Set("X-Gorpc-Metadata", encodeMetadata(reg.Metadata))
+	}
+	if reg.TTL > 0 {
+		req.Header.Set("X-Gorpc-TTL", reg.TTL.String())
+	}
+	if reg.State != "" {
+		req.Header.Set("X-Gorpc-State", reg.State)
+	}
+	resp, err := c.do(req, c.timeout, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("rpc registry: register %s: %s", reg.Addr, resp.Status)
+	}
+	return resp.Header.Get("X-Gorpc-Lease"), nil
+}
+
+// Renew 续约 所有节点都找不到该租约时返回 ErrLeaseNotFound
+func (c *Client) Renew(lease string) error {
+	err := errors.New("rpc registry: no registry address")
+	notFound := false
+	for _, registry := range c.registries {
+		if err = c.renewAt(registry, lease); err == nil {
+			return nil
+		}
+		notFound = notFound || err == ErrLeaseNotFound
+	}
+	if notFound {
+		return ErrLeaseNotFound
+	}
+	return err
+}
+
+func (c *Client) renewAt(registry, lease string) error {
+	req, err := http.NewRequest("POST", registry, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gorpc-Lease", lease)
+	resp, err := c.do(req, c.timeout, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrLeaseNotFound
+	}
+	return fmt.Errorf("rpc registry: renew lease: %s", resp.Status)
+}
+
+// Deregister 注销实例 使实例立即下线而不必等待租约过期 任一节点成功即可
+// 注销后仍在发送的心跳会重新注册该实例 需先调用 Heartbeater.Stop
+func (c *Client) Deregister(addr string) error {
+	err := errors.New("rpc registry: no registry address")
+	for _, registry := range c.registries {
+		if err = c.deregisterAt(registry, addr); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *Client) deregisterAt(registry, addr string) error {
+	req, err := http.NewRequest("DELETE", registry, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gorpc-Server", addr)
+	resp, err := c.do(req, c.timeout, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc registry: deregister %s: %s", addr, resp.Status)
+	}
+	return nil
+}
+
+// ListServers 返回可用的服务实例 以及作为 Watch 起点的变化序号
+func (c *Client) ListServers() (*ServerList, error) {
+	return c.list(context.Background(), "", c.timeout)
+}
+
+// Watch 阻塞直到服务列表的变化序号不同于 index 或等待 wait 后返回当前列表
+// 循环调用并传入上次返回的 Index 即可持续跟踪变化 wait 为0时使用注册中心的默认值30s
+func (c *Client) Watch(ctx context.Context, index uint64, wait time.Duration) (*ServerList, error) {
+	query := url.Values{"index": {strconv.FormatUint(index, 10)}}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	} else {
+		wait = defaultBlockingWait
+	}
+	return c.list(ctx, query.Encode(), wait+c.timeout)
+}
+
+func (c *Client) list(ctx context.Context, query string, timeout time.Duration) (*ServerList, error) {
+	err := errors.New("rpc registry: no registry address")
+	for _, registry := range c.registries {
+		u := strings.TrimSuffix(registry, "/") + v1ServersPath
+		if query != "" {
+			u += "?" + query
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, "GET", u, nil); err != nil {
+			continue
+		}
+		var list ServerList
+		var resp *http.Response
+		if resp, err = c.do(req, timeout, &list); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("rpc registry: list servers: %s", resp.Status)
+			continue
+		}
+		return &list, nil
+	}
+	return nil, err
+}
+
+// Heartbeat 注册实例并在后台定时续约 租约过期或丢失时自动重新注册
+// 发送失败不会停止心跳 以指数退避重试 心跳周期带有随机抖动 避免大量实例同时发送
+// duration 小于等于0时为租约时长的4/5
+func (c *Client) Heartbeat(reg Registration, duration time.Duration) *Heartbeater {
+	return startHeartbeat(heartbeatPeriod(&reg, duration), reg, func(reg *Registration, lease string) (string, error) {
+		gorpc.Logf(gorpc.LevelDebug, "%s send heart beat to registry %s", reg.Addr, strings.Join(c.registries, ","))
+		return beat(c, reg, lease)
+	})
+}
+
+// heartbeatPeriod duration 小于等于0时 发送心跳周期默认为租约时长的4/5 默认5min租约时为4min
+func heartbeatPeriod(reg *Registration, duration time.Duration) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	ttl := reg.TTL
+	if ttl <= 0 {
+		ttl = defaultTimeout
+	}
+	return ttl - ttl/5
+}
+
+// leaseClient Client 与 RPCClient 共用心跳逻辑
+type leaseClient interface {
+	Register(reg Registration) (string, error)
+	Renew(lease string) error
+}
+
+// beat 持有租约时续约 否则(或租约已失效)注册实例 返回当前租约ID
+func beat(c leaseClient, reg *Registration, lease string) (string, error) {
+	if lease != "" {
+		err := c.Renew(lease)
+		if err == nil {
+			return lease, nil
+		}
+		if err != ErrLeaseNotFound {
+			gorpc.Logf(gorpc.LevelWarn, "rpc server: heart beat err: %v", err)
+			return "", err
+		}
+		gorpc.Logf(gorpc.LevelWarn, "rpc server: lease lost, register again: %s", reg.Addr)
+	}
+	lease, err := c.Register(*reg)
+	if err != nil {
+		gorpc.Logf(gorpc.LevelWarn, "rpc server: heart beat err: %v", err)
+	}
+	return lease, err
+}
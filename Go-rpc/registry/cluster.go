@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"gorpc"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 集群模式 每个注册中心将收到的写请求(注册 续约 注销)转发给其他节点
+// 转发的请求携带 X-Gorpc-Replicated 头 收到的节点不再继续转发
+const replicatedHeader = "X-Gorpc-Replicated"
+
+// 转发请求超时时间
+const replicateTimeout = time.Second * 5
+
+var replicateClient = &http.Client{Timeout: replicateTimeout}
+
+// SetPeers 设置集群中其他注册中心的地址 例如 http://10.0.0.2:9999/_gorpc_/registry
+// 续约同样转发完整的实例信息 新加入的节点在下一次心跳后即可追平
+func (r *GoRegistry) SetPeers(peers ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = append([]string(nil), peers...)
+}
+
+// isReplicated 请求是否为其他节点转发的同步请求
+// 同步请求不受限流和实例数限制 只有携带了令牌 或来自 SetPeers 中的节点时才信任该请求头
+// 否则按普通请求处理
+func (r *GoRegistry) isReplicated(req *http.Request) bool {
+	if req.Header.Get(replicatedHeader) == "" {
+		return false
+	}
+	r.mu.Lock()
+	token, peers := r.token, r.peers
+	r.mu.Unlock()
+	if token != "" && r.authorized(req) {
+		return true
+	}
+	return isPeer(sourceIP(req), peers)
+}
+
+// isPeer 来源IP是否为某个节点的地址 节点地址为域名时解析后比较
+func isPeer(source string, peers []string) bool {
+	ip := net.ParseIP(source)
+	if ip == nil {
+		return false
+	}
+	for _, peer := range peers {
+		u, err := url.Parse(peer)
+		if err != nil {
+			continue
+		}
+		hosts := []string{u.Hostname()}
+		if net.ParseIP(u.Hostname()) == nil {
+			hosts, _ = net.LookupHost(u.Hostname())
+		}
+		for _, host := range hosts {
+			if ip.Equal(net.ParseIP(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replicate 异步将实例及其租约同步到其他节点的同一命名空间
+func (r *GoRegistry) replicate(name string, s *ServerItem) {
+	r.forward("POST", name, &RegisterRequest{
+		Registration: Registration{
+			Addr:     s.Addr,
+			Weight:   s.Weight,
+			Services: s.Services,
+			Metadata: s.Metadata,
+			TTL:      s.TTL,
+			State:    s.State,
+		},
+		Lease: s.Lease,
+	})
+}
+
+// replicateRemove 异步通知其他节点注销实例
+func (r *GoRegistry) replicateRemove(name, addr string) {
+	r.forward("DELETE", name, &DeregisterRequest{Addr: addr})
+}
+
+func (r *GoRegistry) forward(method, name string, body interface{}) {
+	r.mu.Lock()
+	peers := r.peers
+	r.mu.Unlock()
+	if len(peers) == 0 {
+		return
+	}
+	data, _ := json.Marshal(body)
+	if name != "" {
+		name = "/" + name
+	}
+	for _, peer := range peers {
+		go func(peer string) {
+			req, _ := http.NewRequest(method, strings.TrimSuffix(peer, "/")+name+v1ServersPath, bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(replicatedHeader, "1")
+			resp, err := replicateClient.Do(req)
+			if err != nil {
+				gorpc.Logf(gorpc.LevelWarn, "rpc registry: replicate to %s err: %v", peer, err)
+				return
+			}
+			_ = resp.Body.Close()
+		}(peer)
+	}
+}
+
+// splitRegistries 解析以,分隔的多个注册中心地址
+func splitRegistries(registry string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(registry, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrDraining 实例正在下线 Heartbeater.Ready 返回
+var ErrDraining = errors.New("rpc registry: draining")
+
+// 单次心跳请求的超时时间 避免注册中心无响应时心跳协程被挂起
+const heartbeatTimeout = time.Second * 10
+
+var heartbeatClient = &http.Client{Timeout: heartbeatTimeout}
+
+const (
+	// 发送失败后的首次重试间隔 之后指数增长 不超过心跳周期
+	heartbeatRetryMin = time.Millisecond * 100
+	// 心跳周期的随机抖动比例 避免大量实例同时发送心跳
+	heartbeatJitter = 0.2
+)
+
+// Heartbeater 后台发送心跳的句柄 由 Register/Heartbeat 返回
+type Heartbeater struct {
+	// 发送一次心跳 持有租约时续约 否则注册 返回当前租约
+	beat func(reg *Registration, lease string) (string, error)
+	// 串行化发送 SetState 与后台心跳不会同时进行
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	reg     Registration
+	lease   string
+	err     error
+	onError func(error)
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// OnError 设置心跳发送失败时的回调 在心跳协程中调用 不应阻塞
+func (h *Heartbeater) OnError(fn func(err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onError = fn
+}
+
+// Err 返回最近一次心跳的错误 成功时为nil
+func (h *Heartbeater) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Stop 停止发送心跳 并等待心跳协程退出
+// 服务关闭时 先 Stop 再 Deregister 使实例立即下线
+func (h *Heartbeater) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	<-h.done
+}
+
+// SetState 修改实例状态 并立即重新注册使注册中心获知 返回本次注册的错误
+// 滚动发布时 先 SetState(StateDraining) 等待客户端停止发送新的请求 再关闭服务
+func (h *Heartbeater) SetState(state string) error {
+	h.mu.Lock()
+	if h.reg.State == state {
+		h.mu.Unlock()
+		return nil
+	}
+	h.reg.State = state
+	// 续约不携带实例信息 清除租约以重新注册
+	h.lease = ""
+	h.mu.Unlock()
+	return h.send()
+}
+
+// Ready 心跳失败或实例正在下线时返回错误 可以作为服务端的就绪检查
+// 例如 server.AddReadinessCheck("registry", h.Ready)
+func (h *Heartbeater) Ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return h.err
+	}
+	if h.reg.State == StateDraining {
+		return ErrDraining
+	}
+	return nil
+}
+
+// State 返回实例当前上报的状态
+func (h *Heartbeater) State() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reg.State
+}
+
+// startHeartbeat 同步发送首次心跳 之后在后台以 duration 为周期续约
+func startHeartbeat(duration time.Duration, reg Registration, beat func(reg *Registration, lease string) (string, error)) *Heartbeater {
+	h := &Heartbeater{
+		beat: beat,
+		reg:  reg,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	h.send()
+	go h.run(duration)
+	return h
+}
+
+func (h *Heartbeater) run(duration time.Duration) {
+	defer close(h.done)
+	retry := heartbeatRetryMin
+	next := jitter(duration)
+	if h.Err() != nil {
+		next, retry = retry, retry*2
+	}
+	t := time.NewTimer(next)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-h.stop:
+			return
+		}
+		if h.send() == nil {
+			retry = heartbeatRetryMin
+			next = jitter(duration)
+		} else {
+			// 失败后指数退避重试
+			next, retry = retry, retry*2
+			if next > duration {
+				next = duration
+			}
+		}
+		t.Reset(next)
+	}
+}
+
+// jitter 在 d 上下浮动 heartbeatJitter/2
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()-0.5)*heartbeatJitter*float64(d))
+}
+
+// send 发送一次心跳 失败时调用 OnError 回调
+func (h *Heartbeater) send() error {
+	h.sendMu.Lock()
+	defer h.sendMu.Unlock()
+	h.mu.Lock()
+	reg, lease := h.reg, h.lease
+	h.mu.Unlock()
+	lease, err := h.beat(&reg, lease)
+	h.mu.Lock()
+	// 网络错误时保留租约 下次继续尝试续约
+	if err == nil {
+		h.lease = lease
+	}
+	h.err = err
+	onError := h.onError
+	h.mu.Unlock()
+	if err != nil && onError != nil {
+		onError(err)
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"gorpc"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// 实例地址的默认最大长度
+	defaultMaxAddrLen = 256
+	// 写请求体的默认最大字节数
+	defaultMaxBodySize = 1 << 20
+	// 超过该时间没有写请求的来源 其限流状态被清理
+	sourceIdleTimeout = time.Minute
+)
+
+var (
+	// ErrInvalidAddr 实例地址为空 过长或包含分隔符
+	ErrInvalidAddr = errors.New("rpc registry: invalid addr")
+	// ErrTooManyInstances 同一来源注册的实例超过上限
+	ErrTooManyInstances = errors.New("rpc registry: too many instances from source")
+	// ErrRateLimited 来源的写请求超过限流 通过 gorpc 调用时返回
+	ErrRateLimited = errors.New("rpc registry: rate limit exceeded")
+)
+
+// Limits 注册中心的请求限制 防止异常或恶意的客户端刷注册 耗尽注册中心内存
+// 来源按连接的对端IP区分 不信任 X-Forwarded-For
+// 集群节点间同步的请求不受限流和实例数限制 未设置令牌时同步请求头可被伪造 应同时使用 SetToken
+type Limits struct {
+	// 每个来源IP每秒允许的写请求数 (注册 续约 注销) 不大于0表示不限制
+	Rate float64
+	// 允许的突发写请求数 默认与 Rate 相同
+	Burst int
+	// 每个来源IP最多注册的实例数 不大于0表示不限制
+	MaxInstancesPerSource int
+	// 实例地址的最大长度 默认256
+	MaxAddrLen int
+	// 写请求体的最大字节数 默认1MB
+	MaxBodySize int64
+}
+
+// sourceLimiter 一个来源的限流状态
+type sourceLimiter struct {
+	limiter  *gorpc.RateLimiter
+	lastSeen time.Time
+}
+
+// SetLimits 设置请求限制 HTTP接口和 gorpc 接口共用同一份来源限流和实例数限制
+func (r *GoRegistry) SetLimits(limits Limits) {
+	if limits.MaxAddrLen <= 0 {
+		limits.MaxAddrLen = defaultMaxAddrLen
+	}
+	if limits.MaxBodySize <= 0 {
+		limits.MaxBodySize = defaultMaxBodySize
+	}
+	if limits.Burst <= 0 {
+		limits.Burst = int(limits.Rate)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+	r.sources = make(map[string]*sourceLimiter)
+}
+
+// sourceIP 请求的来源IP
+func sourceIP(req *http.Request) string {
+	return hostOf(req.RemoteAddr)
+}
+
+// hostOf 去掉地址中的端口
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// allow 来源的写请求是否在限流范围内
+func (r *GoRegistry) allow(source string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limits.Rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	// 顺带清理长时间没有请求的来源 避免大量来源IP占用内存
+	if now.Sub(r.sourcesSweep) > sourceIdleTimeout {
+		r.sourcesSweep = now
+		for ip, s := range r.sources {
+			if now.Sub(s.lastSeen) > sourceIdleTimeout {
+				delete(r.sources, ip)
+			}
+		}
+	}
+	s := r.sources[source]
+	if s == nil {
+		s = &sourceLimiter{limiter: gorpc.NewRateLimiter(r.limits.Rate, r.limits.Burst)}
+		r.sources[source] = s
+	}
+	s.lastSeen = now
+	return s.limiter.Allow()
+}
+
+// validateLocked 校验实例地址与状态 地址中不能有HTTP头接口使用的分隔符 调用时需持有锁
+func (r *GoRegistry) validateLocked(reg *Registration) error {
+	max := r.limits.MaxAddrLen
+	if max <= 0 {
+		max = defaultMaxAddrLen
+	}
+	if reg.Addr == "" || len(reg.Addr) > max || strings.ContainsAny(reg.Addr, ",; \t\r\n") {
+		return fmt.Errorf("%w: %.64q", ErrInvalidAddr, reg.Addr)
+	}
+	if reg.State != "" && reg.State != StateActive && reg.State != StateDraining {
+		return fmt.Errorf("rpc registry: invalid state %.32q", reg.State)
+	}
+	return nil
+}
+
+// register 校验并添加本节点直接收到的注册 source 为空表示不限制实例数
+func (r *GoRegistry) register(name string, reg *Registration, source string) (*ServerItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.validateLocked(reg); err != nil {
+		return nil, err
+	}
+	if max := r.limits.MaxInstancesPerSource; max > 0 && source != "" {
+		// 同一实例重新注册不计入
+		if r.countSourceLocked(source, name, reg.Addr) >= max {
+			return nil, ErrTooManyInstances
+		}
+	}
+	return r.putServerLocked(name, reg, "", source), nil
+}
+
+// countSourceLocked 统计来源注册的未过期实例数 不包括 name 下的 addr 调用时需持有锁
+func (r *GoRegistry) countSourceLocked(source, name, addr string) int {
+	now := time.Now()
+	n := 0
+	for nsName, ns := range r.namespaces {
+		for _, s := range ns.servers {
+			if s.source == source && !s.expired(now) && (nsName != name || s.Addr != addr) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// limitBody 限制写请求体的大小
+func (r *GoRegistry) limitBody(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	max := r.limits.MaxBodySize
+	r.mu.Unlock()
+	if max <= 0 {
+		max = defaultMaxBodySize
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, max)
+}
+
+// validate 校验实例地址
+func (r *GoRegistry) validate(reg *Registration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.validateLocked(reg)
+}
+
+// registerErrorStatus 注册失败对应的HTTP状态码
+func registerErrorStatus(err error) int {
+	if errors.Is(err, ErrTooManyInstances) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
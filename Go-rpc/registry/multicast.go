@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// 局域网组播发现 无需注册中心
+// 服务端定时向组播地址发送公告 客户端监听公告并维护服务列表 超过 TTL 未收到公告的实例被移除
+const (
+	// DefaultMulticastGroup 默认组播地址
+	DefaultMulticastGroup = "239.255.77.77:7077"
+	// 默认公告周期
+	defaultAnnounceInterval = time.Second * 5
+	// 单个公告的最大长度
+	MaxAnnouncementSize = 8192
+)
+
+// Announcement 组播公告
+type Announcement struct {
+	Registration
+	// 实例下线 客户端收到后立即移除
+	Leave bool `json:"leave,omitempty"`
+}
+
+// Announce 定时向组播地址发送实例公告 group 为空时使用 DefaultMulticastGroup
+// interval 小于等于0时默认5s 未设置 TTL 时为3个公告周期 允许偶尔丢包
+// 服务关闭时 先 Stop 再 Leave 使实例立即下线
+func Announce(group string, reg Registration, interval time.Duration) *Heartbeater {
+	if group == "" {
+		group = DefaultMulticastGroup
+	}
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	if reg.TTL <= 0 {
+		reg.TTL = interval * 3
+	}
+	return startHeartbeat(interval, reg, func(reg *Registration, _ string) (string, error) {
+		return "", sendAnnouncement(group, &Announcement{Registration: *reg})
+	})
+}
+
+// Leave 发送下线公告
+func Leave(group, addr string) error {
+	if group == "" {
+		group = DefaultMulticastGroup
+	}
+	return sendAnnouncement(group, &Announcement{Registration: Registration{Addr: addr}, Leave: true})
+}
+
+func sendAnnouncement(group string, a *Announcement) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxAnnouncementSize {
+		return fmt.Errorf("rpc registry: announcement too large: %d bytes", len(data))
+	}
+	conn, err := net.Dial("udp", group)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorpc"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nacos 通过 Nacos Open API (v1) 注册实例 不依赖 Nacos SDK
+// 实例的 protocol 与 gorpc 服务名保存在元数据中 由 xclient.NacosDiscovery 还原为 protocol@addr
+
+// Nacos 默认的心跳周期 服务端默认15s未收到心跳即标记为不健康
+const defaultNacosBeat = time.Second * 5
+
+// nacos 返回的资源不存在错误码 心跳时表示实例需要重新注册
+const nacosNotFound = 20404
+
+// NacosConfig Nacos 服务端及服务定位信息
+type NacosConfig struct {
+	// Nacos 地址 例如 http://127.0.0.1:8848/nacos
+	Server string
+	// 命名空间ID 为空使用 public
+	Namespace string
+	// 分组 为空使用 DEFAULT_GROUP
+	Group string
+	// Nacos 中的服务名
+	Service string
+}
+
+// Values 返回定位服务的公共请求参数
+func (c *NacosConfig) Values() url.Values {
+	v := url.Values{}
+	v.Set("serviceName", c.Service)
+	if c.Group != "" {
+		v.Set("groupName", c.Group)
+	}
+	if c.Namespace != "" {
+		v.Set("namespaceId", c.Namespace)
+	}
+	return v
+}
+
+// URL 返回 Nacos Open API 的完整地址
+func (c *NacosConfig) URL(api string, v url.Values) string {
+	return strings.TrimSuffix(c.Server, "/") + api + "?" + v.Encode()
+}
+
+// RegisterNacos 将实例注册到 Nacos 并定时发送心跳 duration 小于等于0时使用5s
+// reg.Addr 为 protocol@ip:port 格式 reg.Services 与 protocol 写入实例元数据
+func RegisterNacos(cfg NacosConfig, reg Registration, duration time.Duration) *Heartbeater {
+	if duration <= 0 {
+		duration = defaultNacosBeat
+	}
+	return startHeartbeat(duration, reg, func(reg *Registration, lease string) (string, error) {
+		if lease != "" {
+			err := nacosBeat(&cfg, reg)
+			if err != errNacosNotFound {
+				return lease, err
+			}
+			gorpc.Logf(gorpc.LevelWarn, "rpc server: nacos instance lost, register again")
+		}
+		if err := nacosRegister(&cfg, reg); err != nil {
+			gorpc.Logf(gorpc.LevelWarn, "rpc server: nacos register err: %v", err)
+			return "", err
+		}
+		// Nacos 没有租约 以非空值表示已注册
+		return "nacos", nil
+	})
+}
+
+// DeregisterNacos 从 Nacos 注销实例
+func DeregisterNacos(cfg NacosConfig, addr string) error {
+	_, ip, port, err := splitAddr(addr)
+	if err != nil {
+		return err
+	}
+	v := cfg.Values()
+	v.Set("ip", ip)
+	v.Set("port", port)
+	v.Set("ephemeral", "true")
+	_, err = nacosDo("DELETE", cfg.URL("/v1/ns/instance", v))
+	return err
+}
+
+var errNacosNotFound = fmt.Errorf("rpc server: nacos instance not found")
+
+// nacosInstance 注册和心跳所需的实例信息
+func nacosInstance(reg *Registration) (ip, port string, weight float64, metadata map[string]string, err error) {
+	protocol, ip, port, err := splitAddr(reg.Addr)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	metadata = make(map[string]string, len(reg.Metadata)+2)
+	for k, v := range reg.Metadata {
+		metadata[k] = v
+	}
+	metadata["protocol"] = protocol
+	if len(reg.Services) > 0 {
+		metadata["services"] = strings.Join(reg.Services, ";")
+	}
+	if reg.State != "" {
+		metadata["state"] = reg.State
+	}
+	weight = 1
+	if reg.Weight > 0 {
+		weight = float64(reg.Weight)
+	}
+	return ip, port, weight, metadata, nil
+}
+
+func nacosRegister(cfg *NacosConfig, reg *Registration) error {
+	ip, port, weight, metadata, err := nacosInstance(reg)
+	if err != nil {
+		return err
+	}
+	md, _ := json.Marshal(metadata)
+	v := cfg.Values()
+	v.Set("ip", ip)
+	v.Set("port", port)
+	v.Set("weight", strconv.FormatFloat(weight, 'f', -1, 64))
+	v.Set("metadata", string(md))
+	v.Set("enabled", "true")
+	v.Set("healthy", "true")
+	v.Set("ephemeral", "true")
+	_, err = nacosDo("POST", cfg.URL("/v1/ns/instance", v))
+	return err
+}
+
+func nacosBeat(cfg *NacosConfig, reg *Registration) error {
+	ip, port, weight, metadata, err := nacosInstance(reg)
+	if err != nil {
+		return err
+	}
+	p, _ := strconv.Atoi(port)
+	beat, _ := json.Marshal(map[string]interface{}{
+		"serviceName": cfg.Service,
+		"ip":          ip,
+		"port":        p,
+		"weight":      weight,
+		"metadata":    metadata,
+		"cluster":     "DEFAULT",
+	})
+	v := cfg.Values()
+	v.Set("beat", string(beat))
+	body, err := nacosDo("PUT", cfg.URL("/v1/ns/instance/beat", v))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Code int `json:"code"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Code == nacosNotFound {
+		return errNacosNotFound
+	}
+	return nil
+}
+
+func nacosDo(method, url string) ([]byte, error) {
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := heartbeatClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc server: nacos %s: %s %s", method, resp.Status, body)
+	}
+	return body, nil
+}
+
+// splitAddr 将 protocol@ip:port 拆分
+func splitAddr(addr string) (protocol, ip, port string, err error) {
+	protocol, hostPort := "tcp", addr
+	if parts := strings.SplitN(addr, "@", 2); len(parts) == 2 {
+		protocol, hostPort = parts[0], parts[1]
+	}
+	ip, port, err = net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", "", "", fmt.Errorf("rpc server: invalid address %s: %v", addr, err)
+	}
+	return protocol, ip, port, nil
+}
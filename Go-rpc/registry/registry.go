@@ -1,9 +1,14 @@
 package registry
 
 import (
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"gorpc"
 	"net/http"
+	"net/url"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,14 +16,84 @@ import (
 
 // GoRegistry 注册中心
 type GoRegistry struct {
+	// 默认租约时长 注册时未指定TTL时使用 为0表示永不过期
 	timeout time.Duration
 	mu      sync.Mutex
+	// 命名空间 -> 该命名空间下的实例 默认命名空间为空字符串
+	namespaces map[string]*namespace
+	// 创建命名空间时关闭并置空 用于唤醒等待尚不存在的命名空间的请求
+	created chan struct{}
+	// 注册中心的HTTP路径 其后的一级路径为命名空间
+	path string
+	// 集群中的其他注册中心
+	peers []string
+	// 写请求所需的令牌
+	token string
+	// 审计日志
+	audit *auditLog
+	// 请求限制 以及各来源的限流状态
+	limits       Limits
+	sources      map[string]*sourceLimiter
+	sourcesSweep time.Time
+	// 后台清理过期实例的goroutine是否在运行
+	sweeping bool
+}
+
+// namespace 相互隔离的一组实例 例如 staging production
+type namespace struct {
 	servers map[string]*ServerItem
+	// 租约ID -> 服务地址
+	leases map[string]string
+	// 服务列表的变化序号 每次实例增删或信息变化时加1
+	index uint64
+	// 服务列表变化时关闭并替换 用于唤醒等待者
+	changed chan struct{}
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr string `json:"addr"`
+	// 权重 用于加权轮询 默认1
+	Weight int `json:"weight,omitempty"`
+	// 实例提供的服务名 为空表示未上报
+	Services []string `json:"services,omitempty"`
+	// 元数据 例如 version zone 及自定义标签
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// 实例状态 StateActive 或 StateDraining 为空视为 StateActive
+	State string `json:"state,omitempty"`
+	// 租约ID 心跳凭此续约
+	Lease string `json:"-"`
+	// 租约时长 为0表示永不过期
+	TTL time.Duration `json:"ttl,omitempty"`
+	// 最近一次注册或续约的时间
+	heartbeat time.Time
+	expire    time.Time
+	// 注册请求的来源IP 用于限制同一来源的实例数
+	source string
+}
+
+// 实例状态
+const (
+	// StateActive 正常提供服务
+	StateActive = "active"
+	// StateDraining 即将下线 不再接收新的流量
+	StateDraining = "draining"
+)
+
+// Registration 服务实例向注册中心上报的信息
+type Registration struct {
+	// protocol@addr 格式 例如 tcp@10.0.0.1:9999
+	Addr string `json:"addr"`
+	// 权重 不大于0时 客户端按权重1处理
+	Weight int `json:"weight,omitempty"`
+	// 实例提供的服务名 例如 Server.Services()
+	Services []string `json:"services,omitempty"`
+	// 元数据 例如 version=v2 zone=us-east-1a
+	// 未设置 Weight 时 使用 Metadata["weight"]
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// 租约时长 为0时使用注册中心的默认值
+	TTL time.Duration `json:"ttl,omitempty"`
+	// 实例状态 为空视为 StateActive
+	State string `json:"state,omitempty"`
 }
 
 const (
@@ -27,73 +102,392 @@ const (
 	defaultTimeout = time.Minute * 5
 )
 
-// New 创建一个带timeout的注册中心实例
+// New 创建一个带timeout的注册中心实例 timeout 为未指定TTL的实例的默认租约时长
 func New(timeout time.Duration) *GoRegistry {
 	return &GoRegistry{
-		servers: make(map[string]*ServerItem),
-		timeout: timeout,
+		namespaces: make(map[string]*namespace),
+		path:       defaultPath,
+		timeout:    timeout,
+	}
+}
+
+// namespaceLocked 返回命名空间 不存在时创建 只用于注册 调用时需持有锁
+func (r *GoRegistry) namespaceLocked(name string) *namespace {
+	ns := r.namespaces[name]
+	if ns == nil {
+		ns = &namespace{
+			servers: make(map[string]*ServerItem),
+			leases:  make(map[string]string),
+			changed: make(chan struct{}),
+		}
+		r.namespaces[name] = ns
+		if r.created != nil {
+			close(r.created)
+			r.created = nil
+		}
+	}
+	return ns
+}
+
+// lookupLocked 返回命名空间 不存在时返回nil 调用时需持有锁
+// 读取和修改已有实例时使用 避免任意路径的请求创建命名空间
+func (r *GoRegistry) lookupLocked(name string) *namespace {
+	return r.namespaces[name]
+}
+
+// createdLocked 返回下一次创建命名空间时关闭的通道 调用时需持有锁
+func (r *GoRegistry) createdLocked() chan struct{} {
+	if r.created == nil {
+		r.created = make(chan struct{})
 	}
+	return r.created
 }
 
 var DefaultGoRegister = New(defaultTimeout)
 
-// 添加服务实例,服务已存在则更新
-func (r *GoRegistry) putServer(addr string) {
+// 添加服务实例并授予租约,服务已存在则替换
+// lease 为空时生成新的租约ID 否则沿用(来自集群中的其他节点)
+func (r *GoRegistry) putServer(name string, reg *Registration, lease string) *ServerItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.putServerLocked(name, reg, lease, "")
+}
+
+// putServerLocked source 为注册请求的来源IP 调用时需持有锁
+func (r *GoRegistry) putServerLocked(name string, reg *Registration, lease, source string) *ServerItem {
+	ns := r.namespaceLocked(name)
+	ttl := reg.TTL
+	if ttl <= 0 {
+		ttl = r.timeout
+	}
+	old := ns.servers[reg.Addr]
+	if old != nil {
+		delete(ns.leases, old.Lease)
+	}
+	// 只有续租不算变化
+	if old == nil || old.Weight != reg.Weight || old.TTL != ttl || old.State != reg.State ||
+		!reflect.DeepEqual(old.Services, reg.Services) || !reflect.DeepEqual(old.Metadata, reg.Metadata) {
+		ns.bump()
+	}
+	s := &ServerItem{
+		Addr:     reg.Addr,
+		Weight:   reg.Weight,
+		Services: reg.Services,
+		Metadata: reg.Metadata,
+		State:    reg.State,
+		Lease:    lease,
+		TTL:      ttl,
+		source:   source,
+	}
+	if s.Lease == "" {
+		s.Lease = newLeaseID()
+	}
+	s.renew()
+	ns.servers[reg.Addr] = s
+	ns.leases[s.Lease] = s.Addr
+	r.recordLocked(name, s.Addr, EventRegister, "")
+	if s.TTL > 0 && !r.sweeping {
+		r.sweeping = true
+		go r.sweepLoop()
+	}
+	return s
+}
+
+// 续约 租约不存在或已过期时返回nil
+func (r *GoRegistry) renewLease(name, lease string) *ServerItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := r.lookupLocked(name)
+	if ns == nil {
+		return nil
+	}
+	s := ns.servers[ns.leases[lease]]
+	if s == nil || s.Lease != lease || s.expired(time.Now()) {
+		return nil
+	}
+	s.renew()
+	r.recordLocked(name, s.Addr, EventRenew, "")
+	return s
+}
+
+// 删除服务实例
+func (r *GoRegistry) removeServer(name, addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	s := r.servers[addr]
-	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
-	} else {
-		// 更新时间
-		s.start = time.Now()
+	ns := r.lookupLocked(name)
+	if ns == nil {
+		return
+	}
+	if s := ns.servers[addr]; s != nil {
+		delete(ns.leases, s.Lease)
+		delete(ns.servers, addr)
+		ns.bump()
+		r.recordLocked(name, addr, EventDeregister, "")
+	}
+}
+
+// setState 修改实例状态 实例不存在时返回nil
+func (r *GoRegistry) setState(name, addr, state string) *ServerItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := r.lookupLocked(name)
+	if ns == nil {
+		return nil
+	}
+	old := ns.servers[addr]
+	if old == nil {
+		return nil
 	}
+	if old.State == state {
+		return old
+	}
+	// 信息变化时替换为新的 ServerItem 推送依赖指针区分变化
+	s := *old
+	s.State = state
+	ns.servers[addr] = &s
+	ns.bump()
+	r.recordLocked(name, addr, EventState, state)
+	return &s
+}
+
+// bump 记录一次服务列表变化并唤醒等待者 调用时需持有锁
+func (ns *namespace) bump() {
+	ns.index++
+	close(ns.changed)
+	ns.changed = make(chan struct{})
+}
+
+func (s *ServerItem) renew() {
+	s.heartbeat = time.Now()
+	if s.TTL > 0 {
+		s.expire = time.Now().Add(s.TTL)
+	}
+}
+
+func (s *ServerItem) expired(now time.Time) bool {
+	return s.TTL > 0 && !s.expire.After(now)
+}
+
+// newLeaseID 生成随机租约ID
+func newLeaseID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // 返回可用服务列表
-func (r *GoRegistry) aliveServers() []string {
+func (r *GoRegistry) aliveServers(name string) []*ServerItem {
+	alive, _ := r.snapshot(name)
+	return alive
+}
+
+// snapshot 返回可用服务列表及对应的变化序号
+func (r *GoRegistry) snapshot(name string) ([]*ServerItem, uint64) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
-	for addr, s := range r.servers {
-		// 未超时服务
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
-		} else {
-			// 删除 超时服务
-			delete(r.servers, addr)
-		}
+	ns := r.lookupLocked(name)
+	if ns == nil {
+		return nil, 0
+	}
+	r.expireLocked(name, ns, time.Now())
+	var alive []*ServerItem
+	for _, s := range ns.servers {
+		alive = append(alive, s)
 	}
 	// 根据服务名 排序
-	sort.Strings(alive)
-	return alive
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
+	return alive, ns.index
+}
+
+// expireLocked 删除命名空间中租约已过期的实例 返回剩余会过期的实例数 调用时需持有锁
+// expire 事件的时间为租约到期的时间 而不是被发现的时间
+func (r *GoRegistry) expireLocked(name string, ns *namespace, now time.Time) int {
+	remaining := 0
+	for addr, s := range ns.servers {
+		if !s.expired(now) {
+			if s.TTL > 0 {
+				remaining++
+			}
+			continue
+		}
+		delete(ns.leases, s.Lease)
+		delete(ns.servers, addr)
+		ns.bump()
+		r.recordAtLocked(s.expire, name, addr, EventExpire, "")
+	}
+	return remaining
+}
+
+// sweepLoop 定期清理所有命名空间中过期的实例 没有读请求时也能及时记录 expire 事件
+// 没有会过期的实例时退出 下次注册时重新启动
+func (r *GoRegistry) sweepLoop() {
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+	for range t.C {
+		r.mu.Lock()
+		remaining := 0
+		now := time.Now()
+		for name, ns := range r.namespaces {
+			remaining += r.expireLocked(name, ns, now)
+		}
+		if remaining == 0 {
+			r.sweeping = false
+		}
+		r.mu.Unlock()
+		if remaining == 0 {
+			return
+		}
+	}
+}
+
+// route 从请求路径中解析命名空间与接口
+// 例如 /_gorpc_/registry/staging/v1/servers -> staging /v1/servers
+func (r *GoRegistry) route(path string) (name, endpoint string) {
+	for _, e := range []string{v1ServersPath, watchPath, adminPath, auditPath} {
+		if strings.HasSuffix(path, e) {
+			endpoint = e
+			path = strings.TrimSuffix(path, e)
+			break
+		}
+	}
+	r.mu.Lock()
+	base := r.path
+	r.mu.Unlock()
+	if strings.HasPrefix(path, base) {
+		name = strings.Trim(strings.TrimPrefix(path, base), "/")
+	}
+	return name, endpoint
 }
 
 //  注册中心信息采用HTTP提供服务 /_gorpc_/registry
 func (r *GoRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.authorized(req) {
+		// 401
+		// Basic 使浏览器弹出登录框 用于管理页面
+		w.Header().Add("WWW-Authenticate", `Basic realm="gorpc registry"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="gorpc registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.Method == "POST" || req.Method == "DELETE" {
+		if !r.isReplicated(req) && !r.allow(sourceIP(req)) {
+			// 429
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		r.limitBody(w, req)
+	}
+	name, endpoint := r.route(req.URL.Path)
+	switch endpoint {
+	case v1ServersPath:
+		r.serveV1Servers(w, req, name)
+		return
+	case watchPath:
+		r.serveWatch(w, req, name)
+		return
+	case adminPath:
+		r.serveAdmin(w, req, name)
+		return
+	case auditPath:
+		r.serveEvents(w, req, name)
+		return
+	}
 	switch req.Method {
 	// 返回可用服务列表
 	case "GET":
-		w.Header().Set("X-Gorpc-Servers", strings.Join(r.aliveServers(), ","))
+		alive, index := r.blockingSnapshot(req, name)
+		w.Header().Set("X-Gorpc-Index", strconv.FormatUint(index, 10))
+		addrs := make([]string, 0, len(alive))
+		weights := make([]string, 0, len(alive))
+		services := make([]string, 0, len(alive))
+		metadata := make([]string, 0, len(alive))
+		states := make([]string, 0, len(alive))
+		for _, s := range alive {
+			addrs = append(addrs, s.Addr)
+			weights = append(weights, strconv.Itoa(s.Weight))
+			// 同一实例的服务名以;分隔
+			services = append(services, strings.Join(s.Services, ";"))
+			metadata = append(metadata, encodeMetadata(s.Metadata))
+			states = append(states, s.State)
+		}
+		w.Header().Set("X-Gorpc-Servers", strings.Join(addrs, ","))
+		w.Header().Set("X-Gorpc-Weights", strings.Join(weights, ","))
+		w.Header().Set("X-Gorpc-Services", strings.Join(services, ","))
+		w.Header().Set("X-Gorpc-Metadata", strings.Join(metadata, ","))
+		w.Header().Set("X-Gorpc-States", strings.Join(states, ","))
 	// 添加服务实例/发送心跳
 	case "POST":
+		// 携带租约ID 为续约
+		if lease := req.Header.Get("X-Gorpc-Lease"); lease != "" {
+			s := r.renewLease(name, lease)
+			if s == nil {
+				// 404 租约不存在 需重新注册
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			r.replicate(name, s)
+			writeLease(w, s)
+			return
+		}
 		addr := req.Header.Get("X-Gorpc-Server")
 		if addr == "" {
 			// 500
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		reg := &Registration{Addr: addr}
+		reg.Weight, _ = strconv.Atoi(req.Header.Get("X-Gorpc-Weight"))
+		reg.Services = splitServices(req.Header.Get("X-Gorpc-Services"))
+		if v := req.Header.Get("X-Gorpc-Metadata"); v != "" {
+			reg.Metadata = decodeMetadata(v)
+		}
+		if reg.Weight <= 0 && reg.Metadata != nil {
+			reg.Weight, _ = strconv.Atoi(reg.Metadata["weight"])
+		}
+		if v := req.Header.Get("X-Gorpc-TTL"); v != "" {
+			reg.TTL, _ = time.ParseDuration(v)
+		}
+		reg.State = req.Header.Get("X-Gorpc-State")
+		s, err := r.register(name, reg, sourceIP(req))
+		if err != nil {
+			http.Error(w, err.Error(), registerErrorStatus(err))
+			return
+		}
+		r.replicate(name, s)
+		writeLease(w, s)
+	// 注销服务实例
+	case "DELETE":
+		addr := req.Header.Get("X-Gorpc-Server")
+		if addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.removeServer(name, addr)
+		r.replicateRemove(name, addr)
 	default:
 		// 405
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// writeLease 返回租约ID与租约时长
+func writeLease(w http.ResponseWriter, s *ServerItem) {
+	w.Header().Set("X-Gorpc-Lease", s.Lease)
+	w.Header().Set("X-Gorpc-TTL", s.TTL.String())
+}
+
 // HandleHTTP 注册HTTP处理程序
+// 同时在 registryPath+"/v1/servers" 上提供JSON接口 在 registryPath+"/watch" 上推送变化
+// 在 registryPath+"/admin" 上提供管理页面 在 registryPath+"/events" 上查询审计日志
+// registryPath+"/{namespace}" 为独立的命名空间 其下同样提供以上接口
 func (r *GoRegistry) HandleHTTP(registryPath string) {
+	r.mu.Lock()
+	r.path = registryPath
+	r.mu.Unlock()
 	http.Handle(registryPath, r)
-	log.Println("rpc registry path:", registryPath)
+	http.Handle(registryPath+"/", r)
+	gorpc.Logf(gorpc.LevelInfo, "rpc registry path: %s", registryPath)
 }
 
 func HandleHTTP() {
@@ -101,32 +495,59 @@ func HandleHTTP() {
 }
 
 // Heartbeat 定时向注册中心发送心跳
-func Heartbeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		// 发送心跳周期默认比 注册中心过期时间少1min
-		duration = defaultTimeout - time.Duration(1)*time.Minute
-	}
-	var err error
-	err = sendHeartbeat(registry, addr)
-	// 定时器
-	go func() {
-		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
-		}
-	}()
-}
-
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-Gorpc-Server", addr)
-
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
-		return err
-	}
-	return nil
+// addr 为 protocol@addr 格式 例如 tcp@10.0.0.1:9999 或 tls@10.0.0.1:9443
+func Heartbeat(registry, addr string, duration time.Duration) *Heartbeater {
+	return HeartbeatWeight(registry, addr, 0, duration)
+}
+
+// HeartbeatWeight 定时向注册中心发送心跳 并携带实例权重
+// weight 不大于0时 客户端按权重1处理
+func HeartbeatWeight(registry, addr string, weight int, duration time.Duration) *Heartbeater {
+	return Register(registry, Registration{Addr: addr, Weight: weight}, duration)
+}
+
+// Register 向注册中心注册实例 (地址 权重 提供的服务) 并定时发送心跳续约
+// 首次注册同步进行 之后在后台定时续约 租约过期或丢失时自动重新注册
+// registry 可以是以,分隔的多个集群节点地址 依次尝试直到成功
+// 注册到命名空间时在地址后附加 /{namespace} 例如 http://10.0.0.1:9999/_gorpc_/registry/staging
+// 需要配置HTTP客户端 超时或令牌时使用 NewClient
+func Register(registry string, reg Registration, duration time.Duration) *Heartbeater {
+	return NewClient(registry).Heartbeat(reg, duration)
+}
+
+// Deregister 从注册中心注销实例 通常在服务关闭前调用 使实例立即下线而不必等待超时
+// 注销后仍在发送的心跳会重新注册该实例 需先调用 Heartbeater.Stop
+// registry 可以是以,分隔的多个集群节点地址 任一节点成功即可
+func Deregister(registry, addr string) error {
+	return NewClient(registry).Deregister(addr)
+}
+
+// splitServices 解析以;分隔的服务名
+func splitServices(v string) []string {
+	var names []string
+	for _, name := range strings.Split(v, ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// encodeMetadata 将元数据编码为 k=v&k=v 格式 逗号等分隔符会被转义
+func encodeMetadata(metadata map[string]string) string {
+	values := make(url.Values, len(metadata))
+	for k, v := range metadata {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// decodeMetadata 解析 encodeMetadata 编码的元数据 格式错误的部分被忽略
+func decodeMetadata(v string) map[string]string {
+	values, _ := url.ParseQuery(v)
+	metadata := make(map[string]string, len(values))
+	for k := range values {
+		metadata[k] = values.Get(k)
+	}
+	return metadata
 }
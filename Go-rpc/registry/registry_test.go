@@ -0,0 +1,500 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorpc"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func doJSON(method, url string, body, reply interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "request failed: %v", err)
+	defer func() { _ = resp.Body.Close() }()
+	if reply != nil {
+		_ = json.NewDecoder(resp.Body).Decode(reply)
+	}
+	return resp.StatusCode
+}
+
+func TestGoRegistry_V1Servers(t *testing.T) {
+	ts := httptest.NewServer(New(time.Minute))
+	defer ts.Close()
+	url := ts.URL + defaultPath + v1ServersPath
+
+	var lease LeaseResponse
+	code := doJSON("POST", url, &RegisterRequest{Registration: Registration{
+		Addr:     "tcp@a",
+		Services: []string{"Foo"},
+		Metadata: map[string]string{"weight": "3", "zone": "a"},
+	}}, &lease)
+	_assert(code == http.StatusOK && lease.Lease != "" && lease.TTL == time.Minute, "unexpected lease: %d %+v", code, lease)
+	code = doJSON("POST", url, &RegisterRequest{Lease: lease.Lease}, nil)
+	_assert(code == http.StatusOK, "expect renew ok, got %d", code)
+	code = doJSON("POST", url, &RegisterRequest{Lease: "unknown"}, nil)
+	_assert(code == http.StatusNotFound, "expect unknown lease not found, got %d", code)
+
+	var list ServerList
+	_assert(doJSON("GET", url, nil, &list) == http.StatusOK, "failed to list servers")
+	_assert(len(list.Servers) == 1, "expect 1 server, got %d", len(list.Servers))
+	s := list.Servers[0]
+	_assert(s.Addr == "tcp@a" && s.Weight == 3 && s.Services[0] == "Foo" && s.Metadata["zone"] == "a", "unexpected server: %+v", s)
+	_assert(time.Since(s.LastHeartbeat) < time.Second, "unexpected last heartbeat: %v", s.LastHeartbeat)
+	_assert(s.Remaining > 59*time.Second && s.Remaining <= time.Minute, "unexpected remaining ttl: %v", s.Remaining)
+
+	code = doJSON("DELETE", url, &DeregisterRequest{Addr: "tcp@a"}, nil)
+	_assert(code == http.StatusNoContent, "expect deregister ok, got %d", code)
+	list = ServerList{}
+	doJSON("GET", url, nil, &list)
+	_assert(len(list.Servers) == 0, "expect no servers, got %d", len(list.Servers))
+}
+
+func TestGoRegistry_Peers(t *testing.T) {
+	a, b := New(time.Minute), New(time.Minute)
+	tsA, tsB := httptest.NewServer(a), httptest.NewServer(b)
+	defer tsA.Close()
+	defer tsB.Close()
+	a.SetPeers(tsB.URL + defaultPath)
+	b.SetPeers(tsA.URL + defaultPath)
+	waitFor := func(r *GoRegistry, n int) {
+		for i := 0; i < 100 && len(r.aliveServers("")) != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(len(r.aliveServers("")) == n, "expect %d servers, got %d", n, len(r.aliveServers("")))
+	}
+
+	// 第一个节点不可用时 注册到下一个节点 并同步到其他节点
+	Register("http://127.0.0.1:1"+defaultPath+","+tsA.URL+defaultPath, Registration{Addr: "tcp@a"}, time.Hour)
+	waitFor(b, 1)
+	// 租约在集群内通用
+	lease := b.aliveServers("")[0].Lease
+	_assert(lease == a.aliveServers("")[0].Lease, "expect the same lease on all peers")
+	code := doJSON("POST", tsB.URL+defaultPath+v1ServersPath, &RegisterRequest{Lease: lease}, nil)
+	_assert(code == http.StatusOK, "expect renew on peer ok, got %d", code)
+
+	_assert(Deregister(tsB.URL+defaultPath, "tcp@a") == nil, "failed to deregister")
+	waitFor(a, 0)
+}
+
+func TestGoRegistry_Token(t *testing.T) {
+	r := New(time.Minute)
+	r.SetToken("secret")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	url := ts.URL + defaultPath
+
+	_, err := NewClient(url).Register(Registration{Addr: "tcp@a"})
+	_assert(err != nil, "expect register without token rejected")
+	code := doJSON("POST", url+v1ServersPath, &RegisterRequest{Registration: Registration{Addr: "tcp@a"}}, nil)
+	_assert(code == http.StatusUnauthorized, "expect 401, got %d", code)
+	_assert(len(r.aliveServers("")) == 0, "expect no servers registered")
+
+	// 令牌写在地址中
+	authURL := strings.Replace(url, "http://", "http://:secret@", 1)
+	_, err = NewClient(authURL).Register(Registration{Addr: "tcp@a"})
+	_assert(err == nil, "failed to register with token: %v", err)
+	// GET 不需要鉴权
+	var list ServerList
+	_assert(doJSON("GET", url+v1ServersPath, nil, &list) == http.StatusOK && len(list.Servers) == 1, "failed to list servers")
+
+	_assert(Deregister(url, "tcp@a") != nil, "expect deregister without token rejected")
+	req, _ := http.NewRequest("DELETE", url, nil)
+	req.Header.Set("X-Gorpc-Server", "tcp@a")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "failed to deregister with bearer token")
+	_ = resp.Body.Close()
+	_assert(len(r.aliveServers("")) == 0, "expect tcp@a removed")
+}
+
+func TestGoRegistry_BlockingQuery(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	url := ts.URL + defaultPath + v1ServersPath
+	var list ServerList
+	doJSON("GET", url, nil, &list)
+
+	// 没有变化时等待超时
+	start := time.Now()
+	doJSON("GET", fmt.Sprintf("%s?index=%d&wait=100ms", url, list.Index), nil, &list)
+	_assert(time.Since(start) >= 100*time.Millisecond && len(list.Servers) == 0, "expect blocking until timeout")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.putServer("", &Registration{Addr: "tcp@a"}, "")
+	}()
+	index := list.Index
+	doJSON("GET", fmt.Sprintf("%s?index=%d&wait=10s", url, index), nil, &list)
+	_assert(list.Index != index && len(list.Servers) == 1, "expect return on change, got %+v", list)
+}
+
+func TestGoRegistry_UnknownNamespace(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	// 读取不存在的命名空间不会创建它
+	for i := 0; i < 3; i++ {
+		var list ServerList
+		doJSON("GET", fmt.Sprintf("%s%s/random-%d%s", ts.URL, defaultPath, i, v1ServersPath), nil, &list)
+		_assert(len(list.Servers) == 0, "expect empty list, got %+v", list)
+	}
+	_assert(r.renewLease("random-0", "lease") == nil && r.setState("random-0", "tcp@a", StateDraining) == nil, "expect no instance")
+	r.removeServer("random-0", "tcp@a")
+	r.mu.Lock()
+	n := len(r.namespaces)
+	r.mu.Unlock()
+	_assert(n == 0, "expect no namespace allocated by reads, got %d", n)
+
+	// 等待中的阻塞查询在命名空间创建后立即返回
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.putServer("staging", &Registration{Addr: "tcp@a"}, "")
+	}()
+	start := time.Now()
+	alive, _, changed := r.waitChange(context.Background(), "staging", 0)
+	_assert(changed && len(alive) == 1 && time.Since(start) < 500*time.Millisecond, "expect wake up on namespace creation")
+}
+
+func TestGoRegistry_Admin(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	url := ts.URL + defaultPath + adminPath
+	r.putServer("", &Registration{Addr: "tcp@a", Services: []string{"Foo"}, Metadata: map[string]string{"zone": "a"}}, "")
+
+	resp, err := http.Get(url)
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "failed to get admin page: %v", err)
+	var page bytes.Buffer
+	_, _ = page.ReadFrom(resp.Body)
+	_ = resp.Body.Close()
+	_assert(strings.Contains(page.String(), "tcp@a") && strings.Contains(page.String(), "zone=a"), "unexpected page: %s", page.String())
+
+	resp, err = http.PostForm(url, map[string][]string{"addr": {"tcp@a"}, "action": {"drain"}})
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "failed to drain: %v", err)
+	_ = resp.Body.Close()
+	_assert(r.aliveServers("")[0].State == StateDraining, "expect tcp@a draining")
+
+	// 跨站的表单提交被拒绝
+	req, _ := http.NewRequest("POST", url, strings.NewReader("addr=tcp%40a&action=deregister"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	_assert(err == nil && resp.StatusCode == http.StatusForbidden, "expect cross-origin post rejected: %v", err)
+	_ = resp.Body.Close()
+	_assert(len(r.aliveServers("")) == 1, "expect tcp@a kept")
+
+	resp, err = http.PostForm(url, map[string][]string{"addr": {"tcp@a"}, "action": {"deregister"}})
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "failed to deregister: %v", err)
+	_ = resp.Body.Close()
+	_assert(len(r.aliveServers("")) == 0, "expect tcp@a removed")
+}
+
+func TestGoRegistry_Events(t *testing.T) {
+	r := New(time.Minute)
+	var persisted bytes.Buffer
+	r.SetAuditLog(3, &persisted)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	s := r.putServer("", &Registration{Addr: "tcp@a"}, "")
+	r.renewLease("", s.Lease)
+	r.putServer("", &Registration{Addr: "tcp@b", TTL: time.Millisecond}, "")
+	time.Sleep(10 * time.Millisecond)
+	r.aliveServers("")
+	r.removeServer("", "tcp@a")
+	r.putServer("staging", &Registration{Addr: "tcp@c"}, "")
+
+	var body struct{ Events []Event }
+	doJSON("GET", ts.URL+defaultPath+auditPath, nil, &body)
+	var got []string
+	for _, e := range body.Events {
+		got = append(got, e.Type+":"+e.Addr)
+	}
+	// 只保留最近3条 且只返回默认命名空间的记录
+	_assert(fmt.Sprint(got) == "[expire:tcp@b deregister:tcp@a]", "unexpected events: %v", got)
+	doJSON("GET", ts.URL+defaultPath+"/staging"+auditPath, nil, &body)
+	_assert(len(body.Events) == 1 && body.Events[0].Addr == "tcp@c", "unexpected staging events: %+v", body.Events)
+	// 持久化在后台进行 替换后等待之前的写入完成
+	r.SetAuditLog(3, nil)
+	_assert(strings.Count(persisted.String(), "\n") == 6, "expect all events persisted, got %q", persisted.String())
+}
+
+func TestGoRegistry_ExpireSweep(t *testing.T) {
+	r := New(time.Minute)
+	s := r.putServer("", &Registration{Addr: "tcp@a", TTL: time.Millisecond * 10}, "")
+	// 没有任何读请求 后台清理也会记录 expire 事件
+	var expired []Event
+	for deadline := time.Now().Add(sweepInterval * 3); time.Now().Before(deadline) && len(expired) == 0; time.Sleep(time.Millisecond * 50) {
+		r.mu.Lock()
+		for _, e := range r.eventsLocked() {
+			if e.Type == EventExpire {
+				expired = append(expired, e)
+			}
+		}
+		r.mu.Unlock()
+	}
+	_assert(len(expired) == 1 && expired[0].Addr == "tcp@a", "expect an expire event, got %+v", expired)
+	_assert(expired[0].Time.Equal(s.expire), "expect the event at the lease expiry %v, got %v", s.expire, expired[0].Time)
+	r.mu.Lock()
+	sweeping := r.sweeping
+	r.mu.Unlock()
+	_assert(!sweeping, "expect the sweeper to stop when no leases remain")
+}
+
+func TestHeartbeater(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewUnstartedServer(r)
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	url := "http://" + addr + defaultPath
+	// 关闭监听 使连接被拒绝
+	_ = ts.Listener.Close()
+
+	// 注册中心尚未启动 心跳失败后继续重试
+	h := Register(url, Registration{Addr: "tcp@a"}, 20*time.Millisecond)
+	_assert(h.Err() != nil, "expect the first heartbeat to fail")
+	failed := make(chan error, 100)
+	h.OnError(func(err error) { failed <- err })
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		_assert(false, "expect OnError called")
+	}
+	l, err := net.Listen("tcp", addr)
+	_assert(err == nil, "failed to listen: %v", err)
+	ts.Listener = l
+	ts.Start()
+	for i := 0; i < 100 && len(r.aliveServers("")) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(r.aliveServers("")) == 1, "expect tcp@a registered after the registry is up")
+
+	h.Stop()
+	_assert(Deregister(url, "tcp@a") == nil, "failed to deregister")
+	time.Sleep(60 * time.Millisecond)
+	_assert(len(r.aliveServers("")) == 0, "expect no heartbeat after Stop")
+}
+
+func TestHeartbeater_Backoff(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewUnstartedServer(r)
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	_ = ts.Listener.Close()
+
+	// 心跳周期很长 失败后仍会很快重试
+	h := Register("http://"+addr+defaultPath, Registration{Addr: "tcp@a"}, time.Hour)
+	defer h.Stop()
+	_assert(h.Err() != nil, "expect the first heartbeat to fail")
+	time.Sleep(150 * time.Millisecond)
+	l, err := net.Listen("tcp", addr)
+	_assert(err == nil, "failed to listen: %v", err)
+	ts.Listener = l
+	ts.Start()
+	// 注册中心收到请求后 心跳协程才会清除错误
+	for i := 0; i < 300 && (len(r.aliveServers("")) == 0 || h.Err() != nil); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(r.aliveServers("")) == 1 && h.Err() == nil, "expect tcp@a registered by retries")
+}
+
+func TestHeartbeatPeriod(t *testing.T) {
+	reg := &Registration{TTL: 10 * time.Second}
+	_assert(heartbeatPeriod(reg, time.Second) == time.Second, "expect explicit period")
+	_assert(heartbeatPeriod(reg, 0) == 8*time.Second && heartbeatPeriod(reg, -time.Second) == 8*time.Second,
+		"expect non-positive period to default to 4/5 of the ttl")
+}
+
+func TestJitter(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Minute)
+		_assert(d >= 54*time.Second && d <= 66*time.Second, "jitter out of range: %v", d)
+		seen[d] = true
+	}
+	_assert(len(seen) > 1, "expect randomized periods")
+}
+
+func TestClient(t *testing.T) {
+	r := New(time.Minute)
+	r.SetToken("secret")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	c := NewClient("http://127.0.0.1:1," + ts.URL + defaultPath)
+	c.SetTimeout(time.Second)
+	_, err := c.Register(Registration{Addr: "tcp@a"})
+	_assert(err != nil, "expect register without token rejected")
+
+	c.SetToken("secret")
+	lease, err := c.Register(Registration{Addr: "tcp@a", Weight: 2})
+	_assert(err == nil && lease != "", "failed to register: %v", err)
+	_assert(c.Renew(lease) == nil, "failed to renew")
+	_assert(c.Renew("unknown") == ErrLeaseNotFound, "expect ErrLeaseNotFound")
+
+	list, err := c.ListServers()
+	_assert(err == nil && len(list.Servers) == 1 && list.Servers[0].Weight == 2, "unexpected servers: %+v %v", list, err)
+
+	changed := make(chan *ServerList)
+	go func() {
+		l, _ := c.Watch(context.Background(), list.Index, time.Minute)
+		changed <- l
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_assert(c.Deregister("tcp@a") == nil, "failed to deregister")
+	select {
+	case l := <-changed:
+		_assert(l != nil && len(l.Servers) == 0 && l.Index > list.Index, "unexpected watch result: %+v", l)
+	case <-time.After(3 * time.Second):
+		_assert(false, "expect watch to return after change")
+	}
+}
+
+func TestRegistry_RPC(t *testing.T) {
+	r := New(time.Minute)
+	r.SetToken("secret")
+	server := gorpc.NewServer()
+	_assert(server.Register(r.RPCService()) == nil, "failed to register service")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, err := gorpc.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	c := NewRPCClient(client)
+	c.SetNamespace("staging")
+	_, err = c.Register(Registration{Addr: "tcp@a"})
+	_assert(err != nil && strings.Contains(err.Error(), "unauthorized"), "expect unauthorized, got %v", err)
+
+	c.SetToken("secret")
+	h := c.Heartbeat(Registration{Addr: "tcp@a", Services: []string{"Foo"}}, time.Hour)
+	defer h.Stop()
+	_assert(h.Err() == nil, "failed to register: %v", h.Err())
+	_assert(len(r.aliveServers("staging")) == 1 && len(r.aliveServers("")) == 0, "expect registered in namespace")
+	_assert(c.Renew("unknown") == ErrLeaseNotFound, "expect ErrLeaseNotFound")
+
+	list, err := c.ListServers()
+	_assert(err == nil && len(list.Servers) == 1 && list.Servers[0].Services[0] == "Foo", "unexpected servers: %+v %v", list, err)
+	changed := make(chan *ServerList)
+	go func() {
+		l, _ := c.Watch(context.Background(), list.Index, time.Minute)
+		changed <- l
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_assert(c.Deregister("tcp@a") == nil, "failed to deregister")
+	select {
+	case l := <-changed:
+		_assert(l != nil && len(l.Servers) == 0, "unexpected watch result: %+v", l)
+	case <-time.After(3 * time.Second):
+		_assert(false, "expect watch to return after change")
+	}
+}
+
+func TestGoRegistry_Limits(t *testing.T) {
+	r := New(time.Minute)
+	r.SetLimits(Limits{Rate: 1, Burst: 3, MaxInstancesPerSource: 2, MaxAddrLen: 16})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	c := NewClient(ts.URL)
+
+	_, err := c.Register(Registration{Addr: "tcp@a,tcp@b"})
+	_assert(err != nil, "expect addr with separator rejected")
+	_, err = c.Register(Registration{Addr: "tcp@" + strings.Repeat("a", 16)})
+	_assert(err != nil, "expect long addr rejected")
+	_assert(len(r.aliveServers("")) == 0, "expect nothing registered")
+
+	lease, err := c.Register(Registration{Addr: "tcp@a"})
+	_assert(err == nil, "failed to register: %v", err)
+	// 令牌桶已用尽
+	err = c.Renew(lease)
+	_assert(err != nil && strings.Contains(err.Error(), "429"), "expect rate limited, got %v", err)
+
+	r.SetLimits(Limits{MaxInstancesPerSource: 2})
+	for _, addr := range []string{"tcp@a", "tcp@b", "tcp@a"} {
+		_, err = c.Register(Registration{Addr: addr})
+		_assert(err == nil, "failed to register %s: %v", addr, err)
+	}
+	_, err = c.Register(Registration{Addr: "tcp@c"})
+	_assert(err != nil && strings.Contains(err.Error(), "429"), "expect too many instances, got %v", err)
+	_assert(c.Deregister("tcp@b") == nil, "failed to deregister")
+	_, err = c.Register(Registration{Addr: "tcp@c"})
+	_assert(err == nil, "expect register after deregister: %v", err)
+
+	// 普通客户端伪造同步请求头 仍然受限制
+	replicated := func(token string) int {
+		body, _ := json.Marshal(&RegisterRequest{Registration: Registration{Addr: "tcp@d"}})
+		req, _ := http.NewRequest("POST", ts.URL+defaultPath+v1ServersPath, bytes.NewReader(body))
+		req.Header.Set(replicatedHeader, "1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "failed to post: %v", err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	_assert(replicated("") == http.StatusTooManyRequests, "expect a forged replicated request limited")
+	// 来自集群节点 或携带令牌的同步请求不受限制
+	r.SetPeers("http://127.0.0.1:1" + defaultPath)
+	_assert(replicated("") == http.StatusOK, "expect a peer's replicated request accepted")
+	r.SetPeers()
+	r.SetToken("secret")
+	_assert(replicated("secret") == http.StatusOK, "expect a replicated request with the token accepted")
+}
+
+func TestRegistry_RPCLimits(t *testing.T) {
+	r := New(time.Minute)
+	r.SetLimits(Limits{MaxInstancesPerSource: 2})
+	server := gorpc.NewServer()
+	_ = server.Register(r.RPCService())
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, _ := gorpc.Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	c := NewRPCClient(client)
+
+	// 与HTTP接口相同 按来源IP限制实例数
+	for _, addr := range []string{"tcp@a", "tcp@b"} {
+		_, err := c.Register(Registration{Addr: addr})
+		_assert(err == nil, "failed to register %s: %v", addr, err)
+	}
+	_, err := c.Register(Registration{Addr: "tcp@c"})
+	_assert(err != nil && strings.Contains(err.Error(), ErrTooManyInstances.Error()), "expect too many instances, got %v", err)
+
+	r.SetLimits(Limits{Rate: 1, Burst: 1})
+	_ = c.Deregister("tcp@a")
+	err = c.Deregister("tcp@b")
+	_assert(err != nil && strings.Contains(err.Error(), ErrRateLimited.Error()), "expect rate limited, got %v", err)
+}
+
+func TestHeartbeater_Ready(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	h := Register(ts.URL+defaultPath, Registration{Addr: "tcp@a"}, time.Minute)
+	defer h.Stop()
+	_assert(h.Ready() == nil, "expect ready after registering: %v", h.Ready())
+	_ = h.SetState(StateDraining)
+	_assert(errors.Is(h.Ready(), ErrDraining), "expect draining, got %v", h.Ready())
+}
@@ -0,0 +1,226 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"gorpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过 gorpc 调用注册中心 服务节点不需要HTTP客户端即可注册 续约和跟踪变化
+// 与HTTP接口共享同一份实例数据 同样会同步到集群中的其他节点
+// 设置了令牌时 写操作需在请求元数据 authorization 中携带 Bearer <token>
+
+// ErrUnauthorized 写操作没有携带正确的令牌
+var ErrUnauthorized = errors.New("rpc registry: unauthorized")
+
+// RegisterArgs Registry.Register 的参数
+type RegisterArgs struct {
+	// 命名空间 为空时使用默认命名空间
+	Namespace string
+	Registration
+}
+
+// RenewArgs Registry.Renew 的参数
+type RenewArgs struct {
+	Namespace string
+	Lease     string
+}
+
+// DeregisterArgs Registry.Deregister 的参数
+type DeregisterArgs struct {
+	Namespace string
+	Addr      string
+}
+
+// ListArgs Registry.List 的参数
+type ListArgs struct {
+	Namespace string
+	// 不为0时阻塞 直到变化序号不同于 Index 或等待 Wait 后返回
+	Index uint64
+	// 阻塞等待时间 默认30s 最长5min
+	Wait time.Duration
+}
+
+// Registry 注册中心的 gorpc 服务 服务名为 Registry
+type Registry struct {
+	r *GoRegistry
+}
+
+// RPCService 返回注册中心的 gorpc 服务 通过 Server.Register 注册
+func (r *GoRegistry) RPCService() *Registry {
+	return &Registry{r: r}
+}
+
+// authorize 校验请求元数据中的令牌 并按来源IP限流 返回来源IP
+func (s *Registry) authorize(ctx context.Context) (string, error) {
+	md, _ := gorpc.FromIncomingContext(ctx)
+	if !s.r.checkToken(bearerToken(md["authorization"])) {
+		return "", ErrUnauthorized
+	}
+	peer, _ := gorpc.PeerFromContext(ctx)
+	source := hostOf(peer)
+	if !s.r.allow(source) {
+		return "", ErrRateLimited
+	}
+	return source, nil
+}
+
+// Register 注册实例 返回租约
+func (s *Registry) Register(ctx context.Context, args RegisterArgs, reply *LeaseResponse) error {
+	source, err := s.authorize(ctx)
+	if err != nil {
+		return err
+	}
+	reg := &args.Registration
+	if reg.Addr == "" {
+		return errors.New("rpc registry: missing addr")
+	}
+	if reg.Weight <= 0 && reg.Metadata != nil {
+		reg.Weight, _ = strconv.Atoi(reg.Metadata["weight"])
+	}
+	item, err := s.r.register(args.Namespace, reg, source)
+	if err != nil {
+		return err
+	}
+	s.r.replicate(args.Namespace, item)
+	*reply = LeaseResponse{Lease: item.Lease, TTL: item.TTL}
+	return nil
+}
+
+// Renew 续约 租约不存在时返回 ErrLeaseNotFound
+func (s *Registry) Renew(ctx context.Context, args RenewArgs, reply *LeaseResponse) error {
+	if _, err := s.authorize(ctx); err != nil {
+		return err
+	}
+	item := s.r.renewLease(args.Namespace, args.Lease)
+	if item == nil {
+		return ErrLeaseNotFound
+	}
+	s.r.replicate(args.Namespace, item)
+	*reply = LeaseResponse{Lease: item.Lease, TTL: item.TTL}
+	return nil
+}
+
+// Deregister 注销实例
+func (s *Registry) Deregister(ctx context.Context, args DeregisterArgs, reply *bool) error {
+	if _, err := s.authorize(ctx); err != nil {
+		return err
+	}
+	s.r.removeServer(args.Namespace, args.Addr)
+	s.r.replicateRemove(args.Namespace, args.Addr)
+	*reply = true
+	return nil
+}
+
+// List 返回可用的服务实例 Index 不为0时为阻塞查询
+func (s *Registry) List(ctx context.Context, args ListArgs, reply *ServerList) error {
+	if args.Index == 0 {
+		alive, index := s.r.snapshot(args.Namespace)
+		*reply = ServerList{Servers: s.r.statuses(alive), Index: index}
+		return nil
+	}
+	wait := args.Wait
+	if wait <= 0 {
+		wait = defaultBlockingWait
+	}
+	if wait > maxBlockingWait {
+		wait = maxBlockingWait
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	alive, index, _ := s.r.waitChange(ctx, args.Namespace, args.Index)
+	*reply = ServerList{Servers: s.r.statuses(alive), Index: index}
+	return nil
+}
+
+// RPCClient 通过 gorpc 访问注册中心的客户端 与 Client 对应
+type RPCClient struct {
+	client    *gorpc.Client
+	namespace string
+	token     string
+	// 单次调用的超时时间
+	timeout time.Duration
+}
+
+// NewRPCClient 使用已连接到注册中心的 gorpc 客户端
+func NewRPCClient(client *gorpc.Client) *RPCClient {
+	return &RPCClient{client: client, timeout: heartbeatTimeout}
+}
+
+// SetNamespace 注册到该命名空间 应在使用前调用
+func (c *RPCClient) SetNamespace(namespace string) {
+	c.namespace = namespace
+}
+
+// SetToken 设置注册中心要求的写令牌 应在使用前调用
+func (c *RPCClient) SetToken(token string) {
+	c.token = token
+}
+
+// SetTimeout 设置单次调用的超时时间 默认10s 应在使用前调用
+func (c *RPCClient) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *RPCClient) call(ctx context.Context, timeout time.Duration, method string, args, reply interface{}) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if c.token != "" {
+		ctx = gorpc.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+	}
+	err := c.client.Call(ctx, "Registry."+method, args, reply)
+	// 服务端错误只保留了错误信息
+	if err != nil && strings.HasPrefix(err.Error(), ErrLeaseNotFound.Error()) {
+		return ErrLeaseNotFound
+	}
+	return err
+}
+
+// Register 注册实例 返回租约ID
+func (c *RPCClient) Register(reg Registration) (string, error) {
+	var reply LeaseResponse
+	err := c.call(context.Background(), c.timeout, "Register", RegisterArgs{Namespace: c.namespace, Registration: reg}, &reply)
+	return reply.Lease, err
+}
+
+// Renew 续约 租约不存在时返回 ErrLeaseNotFound
+func (c *RPCClient) Renew(lease string) error {
+	var reply LeaseResponse
+	return c.call(context.Background(), c.timeout, "Renew", RenewArgs{Namespace: c.namespace, Lease: lease}, &reply)
+}
+
+// Deregister 注销实例
+func (c *RPCClient) Deregister(addr string) error {
+	var reply bool
+	return c.call(context.Background(), c.timeout, "Deregister", DeregisterArgs{Namespace: c.namespace, Addr: addr}, &reply)
+}
+
+// ListServers 返回可用的服务实例 以及作为 Watch 起点的变化序号
+func (c *RPCClient) ListServers() (*ServerList, error) {
+	var list ServerList
+	err := c.call(context.Background(), c.timeout, "List", ListArgs{Namespace: c.namespace}, &list)
+	return &list, err
+}
+
+// Watch 阻塞直到服务列表的变化序号不同于 index 或等待 wait 后返回当前列表 见 Client.Watch
+func (c *RPCClient) Watch(ctx context.Context, index uint64, wait time.Duration) (*ServerList, error) {
+	if wait <= 0 {
+		wait = defaultBlockingWait
+	}
+	var list ServerList
+	err := c.call(ctx, wait+c.timeout, "List", ListArgs{Namespace: c.namespace, Index: index, Wait: wait}, &list)
+	return &list, err
+}
+
+// Heartbeat 注册实例并在后台定时续约 租约丢失时自动重新注册 见 Client.Heartbeat
+func (c *RPCClient) Heartbeat(reg Registration, duration time.Duration) *Heartbeater {
+	return startHeartbeat(heartbeatPeriod(&reg, duration), reg, func(reg *Registration, lease string) (string, error) {
+		return beat(c, reg, lease)
+	})
+}
@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// /watch 以 Server-Sent Events 推送服务列表的变化
+// 连接建立后先以 add 事件推送全部实例 之后只推送变化
+// 每批变化以 sync 事件结束 客户端收到 sync 后再应用这一批变化
+//
+//	event: add      data: ServerItem (新增或信息变化的实例)
+//	event: remove   data: {"addr": "..."}
+//	event: sync     data: {"index": N}
+const watchPath = "/watch"
+
+const (
+	// 检查过期实例的周期
+	sweepInterval = time.Second
+	// 没有变化时发送注释行 防止连接被中间代理断开
+	watchKeepAlive = time.Second * 15
+)
+
+// waitChange 等待服务列表的变化序号不同于 index 期间定期清理过期实例
+// ctx 结束时返回当前列表 changed 为false
+func (r *GoRegistry) waitChange(ctx context.Context, name string, index uint64) (alive []*ServerItem, cur uint64, changed bool) {
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+	for {
+		alive, cur = r.snapshot(name)
+		if cur != index {
+			return alive, cur, true
+		}
+		r.mu.Lock()
+		var ch chan struct{}
+		pending := false
+		if ns := r.lookupLocked(name); ns != nil {
+			ch, pending = ns.changed, ns.index != index
+		} else {
+			// 命名空间还不存在 等待其被创建
+			ch = r.createdLocked()
+		}
+		r.mu.Unlock()
+		if pending {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-t.C:
+		case <-ctx.Done():
+			return alive, cur, false
+		}
+	}
+}
+
+// 阻塞查询 GET 请求携带 ?index=N 时 注册中心挂起请求直到变化序号不同于N或超时
+// wait 指定最长等待时间 默认30s 最长5min 响应头 X-Gorpc-Index 返回当前序号
+const (
+	defaultBlockingWait = time.Second * 30
+	maxBlockingWait     = time.Minute * 5
+)
+
+// blockingSnapshot 按请求参数返回服务列表 必要时阻塞等待变化
+func (r *GoRegistry) blockingSnapshot(req *http.Request, name string) ([]*ServerItem, uint64) {
+	q := req.URL.Query()
+	index, err := strconv.ParseUint(q.Get("index"), 10, 64)
+	if err != nil {
+		return r.snapshot(name)
+	}
+	wait := defaultBlockingWait
+	if v, err := time.ParseDuration(q.Get("wait")); err == nil && v > 0 {
+		wait = v
+	}
+	if wait > maxBlockingWait {
+		wait = maxBlockingWait
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), wait)
+	defer cancel()
+	alive, cur, _ := r.waitChange(ctx, name, index)
+	return alive, cur
+}
+
+func (r *GoRegistry) serveWatch(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != "GET" {
+		// 405
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]*ServerItem)
+	alive, index := r.snapshot(name)
+	for {
+		cur := make(map[string]*ServerItem, len(alive))
+		for _, s := range alive {
+			cur[s.Addr] = s
+			// putServer 在信息变化时创建新的 ServerItem 指针不同即需推送
+			if sent[s.Addr] != s {
+				writeEvent(w, "add", s)
+			}
+		}
+		for addr := range sent {
+			if cur[addr] == nil {
+				writeEvent(w, "remove", &DeregisterRequest{Addr: addr})
+			}
+		}
+		writeEvent(w, "sync", map[string]uint64{"index": index})
+		flusher.Flush()
+		sent = cur
+
+		var changed bool
+		for !changed {
+			ctx, cancel := context.WithTimeout(req.Context(), watchKeepAlive)
+			alive, index, changed = r.waitChange(ctx, name, index)
+			cancel()
+			if req.Context().Err() != nil {
+				return
+			}
+			if !changed {
+				_, _ = fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, _ := json.Marshal(v)
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,30 @@
+package gorpc
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// SetRuntimeTrace 设置为true时 每次请求创建一个 runtime/trace 任务
+// 并在读取请求、调用方法、发送响应外标记区域 配合 go tool trace 分析请求耗时
+// 默认不启用 只在 trace.Start 之后生效
+func (server *Server) SetRuntimeTrace(enabled bool) {
+	server.rtrace = enabled
+}
+
+// traceTask 开始一个请求任务
+func (server *Server) traceTask(ctx context.Context, serviceMethod string) (context.Context, func()) {
+	if !server.rtrace || !trace.IsEnabled() {
+		return ctx, func() {}
+	}
+	ctx, task := trace.NewTask(ctx, "gorpc "+serviceMethod)
+	return ctx, task.End
+}
+
+// traceRegion 在当前协程上开始一个区域 返回的函数必须在同一协程中调用
+func (server *Server) traceRegion(ctx context.Context, name string) func() {
+	if !server.rtrace || !trace.IsEnabled() {
+		return func() {}
+	}
+	return trace.StartRegion(ctx, name).End
+}
@@ -1,17 +1,24 @@
 package gorpc
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gorpc/codec"
 	"io"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -26,6 +33,41 @@ type Option struct {
 	ConnectTimeout time.Duration
 	// 处理请求超时 默认0 表示不设限
 	HandleTimeout time.Duration
+	// 客户端最多同时等待响应的请求数 默认0 表示不设限
+	MaxPendingCalls int `json:"-"`
+	// 达到上限时 true 直接返回错误 false 阻塞等待
+	PendingFailFast bool `json:"-"`
+	// HTTP代理 用法同 http.Transport.Proxy 默认nil 表示直连
+	// 例如 http.ProxyFromEnvironment 或 http.ProxyURL(u)
+	Proxy func(*http.Request) (*url.URL, error) `json:"-"`
+	// HTTP/2 传输使用的客户端 默认 http.DefaultClient
+	HTTPClient *http.Client `json:"-"`
+	// 每个服务地址的连接数 默认1
+	PoolSize int `json:"-"`
+	// 全局限流器 所有方法共享
+	RateLimiter *RateLimiter `json:"-"`
+	// 方法级限流器 k:v -> 方法名:限流器 先于全局限流器生效
+	MethodRateLimiters map[string]*RateLimiter `json:"-"`
+	// 超过限流时 true 直接返回 ErrRateLimited false 阻塞等待
+	RateLimitFailFast bool `json:"-"`
+	// 自定义拨号函数 优先于内置网络和 RegisterTransport 注册的传输层
+	Dialer DialFunc `json:"-"`
+	// 建立连接后发送一次Ping 确认服务端可用
+	PingOnConnect bool `json:"-"`
+	// 同步调用的默认超时 ctx 没有截止时间时生效 默认0 表示不设限
+	CallTimeout time.Duration `json:"-"`
+	// 对冲请求延迟 同步调用超过该时间未返回时再发起一次相同的请求 默认0 表示不启用
+	HedgeDelay time.Duration `json:"-"`
+	// 客户端指标回调 例如 NewClientStats()
+	Stats StatsHandler `json:"-"`
+	// 链路追踪 每次调用开始一个客户端span 并通过请求元数据传递追踪上下文
+	Tracer Tracer `json:"-"`
+	// 请求失败的回调 包括服务端返回的错误、超时、取消和连接错误
+	OnError ErrorHandler `json:"-"`
+	// 推送式调用指标 例如 NewOTLPExporter(...)
+	Meter Meter `json:"-"`
+	// tls@ 地址使用的TLS配置 未设置 ServerName 时使用地址中的主机名
+	TLSConfig *tls.Config `json:"-"`
 }
 
 // DefaultOption 默认选择为GobType
@@ -37,6 +79,40 @@ var DefaultOption = &Option{
 // Server 一次rpc服务
 type Server struct {
 	serviceMap sync.Map
+	tracer     Tracer
+	// 慢调用阈值 全局和方法级
+	slowMu      sync.RWMutex
+	slow        time.Duration
+	slowMethods map[string]time.Duration
+	// 中间件 先添加的在外层
+	mwMu        sync.RWMutex
+	middlewares []Middleware
+	// 打开的连接 k:v -> id:*connInfo
+	conns   sync.Map
+	connSeq uint64
+	// HandleHTTP 是否挂载pprof和运行时设置接口
+	pprof            bool
+	settingsEndpoint bool
+	closeEndpoint    bool
+	// 是否标记 runtime/trace 任务和区域
+	rtrace bool
+	// 请求失败的回调
+	onError ErrorHandler
+	// 编解码指标回调
+	codecStats CodecStatsHandler
+	// 推送式调用指标
+	meter Meter
+	// 运行时可调整的处理超时上限和并发上限 原子操作
+	handleTimeout  int64
+	maxConcurrency int64
+	// 按阶段统计的协程数
+	goTracking int32
+	goStages   [numGoStages]int64
+	// 就绪检查和正在处理的请求数
+	readyMu          sync.Mutex
+	readyChecks      []readinessCheck
+	readyMaxInFlight int64
+	inFlight         int64
 }
 
 // NewServer 构造函数
@@ -44,34 +120,73 @@ func NewServer() *Server {
 	return &Server{}
 }
 
+// SetTracer 设置服务端链路追踪 每次调用在 svc.call 外开始一个服务端span
+func (server *Server) SetTracer(tracer Tracer) {
+	server.tracer = tracer
+}
+
 // ServeConn 处理一次rpc连接下的请求 直到客户端断开请求
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
+	var identity *Identity
+	if tc, ok := conn.(*tls.Conn); ok {
+		var err error
+		if identity, err = tlsIdentity(tc); err != nil {
+			Logf(LevelWarn, "rpc server: tls handshake error: %v", err)
+			return
+		}
+	}
 	var opt Option
 	// 反序列化得到Option实例
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		Logf(LevelWarn, "rpc server: options error: %v", err)
 		return
 	}
 	// 检查 Number值
 	if opt.Number != Number {
-		log.Printf("rpc server: invalid magic number %x", opt.Number)
+		Logf(LevelWarn, "rpc server: invalid magic number %x", opt.Number)
 		return
 	}
 	// 检查 编码格式
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+		Logf(LevelWarn, "rpc server: invalid codec type %s", opt.CodecType)
 		return
 	}
-	server.serveCodec(f(conn), &opt)
+	info := &connInfo{peer: peerAddr(conn), codec: string(opt.CodecType), closer: conn, identity: identity}
+	defer server.trackConn(info)()
+	cc := newStatsCodec(f, opt.CodecType, &countingConn{ReadWriteCloser: newBufferedConn(conn, dec.Buffered()), info: info}, server.codecStats)
+	server.serveCodec(cc, &opt, info)
+}
+
+// bufferedConn 将json解码器预读的数据拼接回连接
+// 客户端可能紧接着Option发送请求 避免这部分报文被吞掉
+type bufferedConn struct {
+	r *bufio.Reader
+	io.ReadWriteCloser
+}
+
+func newBufferedConn(conn io.ReadWriteCloser, buffered io.Reader) *bufferedConn {
+	r := bufio.NewReader(io.MultiReader(buffered, conn))
+	// 跳过Option编码末尾的换行符
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	return &bufferedConn{r: r, ReadWriteCloser: conn}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
 }
 
 // invalidRequest 发生错误时候的 argv 占位符
 var invalidRequest = struct{}{}
 
 // serveCodec 编解码处理
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
+// info 记录对端地址和写出的字节数
+func (server *Server) serveCodec(cc codec.Codec, opt *Option, info *connInfo) {
+	leave := server.trackGoroutine(stageConn)
 	// 互斥锁 确保一个respone完整的发出
 	sending := new(sync.Mutex)
 	// 用于同步 等到所有请求处理完
@@ -85,16 +200,24 @@ func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
 				// 请求无法恢复 直接断开连接
 				break
 			}
+			server.reportError(req.h.ServiceMethod, info.peer, err)
 			req.h.Error = err.Error()
 			// 3.回复请求
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.sendResponse(cc, req.h, invalidRequest, sending, info)
 			continue
 		}
 		// 2.处理请求 计数器+1
+		req.conn = info
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		atomic.AddInt64(&info.inFlight, 1)
+		go func() {
+			defer atomic.AddInt64(&info.inFlight, -1)
+			server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		}()
 	}
+	leave()
 	// 阻塞 直到请求处理完
+	defer server.trackGoroutine(stageConnDrain)()
 	wg.Wait()
 	_ = cc.Close()
 }
@@ -109,6 +232,8 @@ type request struct {
 	replyv reflect.Value
 	mtype  *methodType
 	svc    *service
+	// 所在连接
+	conn *connInfo
 }
 
 // readRequestHeader 读取请求头
@@ -116,7 +241,7 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+			Logf(LevelWarn, "rpc server: read header error: %v", err)
 		}
 		return nil, err
 	}
@@ -124,10 +249,14 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 }
 
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	if serviceMethod == pingServiceMethod {
+		svc = getBuiltinService()
+		return svc, svc.method["Ping"], nil
+	}
 	// 检查请求服务格式
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = fmt.Errorf("%w: %s", ErrIllFormed, serviceMethod)
 		return
 	}
 	// 根据dot划分 服务名.方法名
@@ -136,14 +265,14 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	// svci -> 找到对应Service实例
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = fmt.Errorf("%w %s", ErrServiceNotFound, serviceName)
 		return
 	}
 	// 在对应 Service实例中 找到对应 methodType
 	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = fmt.Errorf("%w %s", ErrMethodNotFound, methodName)
 	}
 	return
 }
@@ -154,10 +283,14 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 从读到请求头开始 不包含等待下一个请求的时间
+	defer server.traceRegion(context.Background(), "gorpc.readRequest")()
 	req := &request{h: h}
 	//
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// 丢弃请求体 防止被当作下一个请求头读取
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 
@@ -171,26 +304,37 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
+		Logf(LevelWarn, "rpc server: read body err: %v", err)
 		return req, err
 	}
 	return req, nil
 }
 
-// sendResponse 发送响应
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
+// sendResponse 发送响应 返回写出的字节数
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex, info *connInfo) int64 {
 	// 这里上锁 保证响应的有序发送 防止其他goroutine也在往同一个缓冲区写入
 	sending.Lock()
 	defer sending.Unlock()
+	before := atomic.LoadInt64(&info.written)
 	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+		Logf(LevelWarn, "rpc server: write response error: %v", err)
 	}
+	return atomic.LoadInt64(&info.written) - before
 }
 
 // handleRequest 处理请求
 // 处理超时
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
+	defer server.trackGoroutine(stageHandle)()
+	begin := time.Now()
+	n := atomic.AddInt64(&server.inFlight, 1)
+	defer atomic.AddInt64(&server.inFlight, -1)
+	if max := atomic.LoadInt64(&server.maxConcurrency); max > 0 && n > max {
+		server.rejectBusy(cc, req, sending)
+		return
+	}
+	timeout = server.effectiveHandleTimeout(timeout)
 
 	// 一次处理 分为两个过程
 	// 用于事件通信
@@ -198,17 +342,88 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	called := make(chan struct{})
 	sent := make(chan struct{})
 
-	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
+	// 超时或处理结束后取消ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, endTask := server.traceTask(ctx, req.h.ServiceMethod)
+	defer endTask()
+	if req.h.Metadata != nil {
+		ctx = NewIncomingContext(ctx, req.h.Metadata)
+	}
+	if req.conn.peer != "" {
+		ctx = withPeer(ctx, req.conn.peer)
+	}
+	if req.conn.identity != nil {
+		ctx = WithIdentity(ctx, req.conn.identity)
+	}
+	var span Span
+	if server.tracer != nil {
+		ctx, span = server.tracer.Start(server.tracer.Extract(ctx, req.h.Metadata), req.h.ServiceMethod, SpanServer)
+	}
+	ctx, rm := newResponseMetadataContext(ctx)
+
+	// invoke 中间件链的最内层 调用服务方法并发送响应
+	invoked := false
+	invoke := func(ctx context.Context, call *ServerCall) error {
+		invoked = true
+		start := time.Now()
+		endRegion := server.traceRegion(ctx, "gorpc.call")
+		err := req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+		endRegion()
+		server.logSlowCall(req, time.Since(start), err)
+		server.reportError(req.h.ServiceMethod, req.conn.peer, err)
+		server.recordDuration(req.h.ServiceMethod, time.Since(begin), err)
+		if span != nil {
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.End()
+		}
+		// 复制请求头 超时分支可能同时在发送响应
+		h := *req.h
+		h.Metadata = rm.get()
 
 		called <- struct{}{}
+		defer server.traceRegion(ctx, "gorpc.sendResponse")()
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+			h.Error = err.Error()
+			call.BytesWritten = server.sendResponse(cc, &h, invalidRequest, sending, req.conn)
+			return err
+		}
+		call.BytesWritten = server.sendResponse(cc, &h, req.replyv.Interface(), sending, req.conn)
+		return nil
+	}
+
+	go func() {
+		defer server.trackGoroutine(stageCall)()
+		call := &ServerCall{
+			ServiceMethod: req.h.ServiceMethod,
+			Seq:           req.h.Seq,
+			Peer:          req.conn.peer,
+			Metadata:      req.h.Metadata,
+			Args:          req.argv.Interface(),
+			Reply:         req.replyv.Interface(),
+		}
+		err := server.chain(invoke)(ctx, call)
+		if !invoked {
+			// 被中间件拒绝
+			if err == nil {
+				err = errRejected
+			}
+			server.reportError(req.h.ServiceMethod, req.conn.peer, err)
+			server.recordDuration(req.h.ServiceMethod, time.Since(begin), err)
+			if span != nil {
+				span.RecordError(err)
+				span.End()
+			}
+			called <- struct{}{}
+			if err != errFaultDrop {
+				h := *req.h
+				h.Metadata = nil
+				h.Error = err.Error()
+				server.sendResponse(cc, &h, invalidRequest, sending, req.conn)
+			}
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
 		sent <- struct{}{}
 	}()
 
@@ -221,8 +436,16 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	case <-called:
 		<-sent
 	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+		cancel()
+		h := *req.h
+		h.Metadata = nil
+		h.Error = handleTimeoutError(timeout)
+		server.reportError(req.h.ServiceMethod, req.conn.peer, NewServerError(h.Error))
+		if span != nil {
+			span.RecordError(errors.New(h.Error))
+		}
+		server.sendResponse(cc, &h, invalidRequest, sending, req.conn)
+		defer server.trackGoroutine(stageTimeoutWait)()
 		// 如果为缓存信道，则可以将下面注释掉
 		<-called
 		<-sent
@@ -238,7 +461,7 @@ func (server *Server) Accept(lis net.Listener) {
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			Logf(LevelError, "rpc server: accept error: %v", err)
 			return
 		}
 		// 开启 子协程 处理连接请求
@@ -252,6 +475,44 @@ func (server *Server) Accept(lis net.Listener) {
 // gorpc.Accept(lis)
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
+// Listen 按 protocol@addr 格式监听地址 例如 tcp@:9999, unix@/tmp/gorpc.sock
+// unix socket 文件已存在且无人监听(连接被拒绝)时 先删除残留的文件
+// 仍有服务在监听时不删除 返回地址已被占用的错误
+func Listen(rpcAddr string) (net.Listener, error) {
+	protocol, addr, err := parseRPCAddr(rpcAddr)
+	if err != nil {
+		return nil, err
+	}
+	if t := getTransport(protocol); t != nil {
+		return t.Listen(addr)
+	}
+	if protocol == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			conn, err := net.DialTimeout("unix", addr, time.Second)
+			if err == nil {
+				_ = conn.Close()
+			} else if errors.Is(err, syscall.ECONNREFUSED) {
+				_ = os.Remove(addr)
+			}
+		}
+	}
+	return net.Listen(protocol, addr)
+}
+
+// ListenAndServe 监听 protocol@addr 并处理连接
+func (server *Server) ListenAndServe(rpcAddr string) error {
+	lis, err := Listen(rpcAddr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lis.Close() }()
+	server.Accept(lis)
+	return nil
+}
+
+// ListenAndServe 以 DefaultServer 监听并处理连接
+func ListenAndServe(rpcAddr string) error { return DefaultServer.ListenAndServe(rpcAddr) }
+
 // Register 在服务器中注册
 func (server *Server) Register(rcvr interface{}) error {
 	s := newService(rcvr)
@@ -267,10 +528,23 @@ func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+// Services 返回已注册的服务名 按名称排序 可用于向注册中心上报
+func (server *Server) Services() []string {
+	var names []string
+	server.serviceMap.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
 const (
-	connected        = "200 Connected to Go RPC"
-	defaultRPCPath   = "/gorpc"
-	defaultDebugPath = "/debug/gorpc"
+	connected         = "200 Connected to Go RPC"
+	defaultRPCPath    = "/gorpc"
+	defaultDebugPath  = "/debug/gorpc"
+	defaultDebugJSON  = "/debug/gorpc.json"
+	defaultDebugClose = "/debug/gorpc/close"
 )
 
 // ServeHTTP 实现 http.Handler 去接收RPC请求
@@ -286,7 +560,7 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// TODO 使用Hijack使  HTTP/1.1 来支持 GRPC 的 stream rpc
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		Logf(LevelWarn, "rpc hijacking %s: %v", req.RemoteAddr, err)
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
@@ -299,7 +573,19 @@ func (server *Server) HandleHTTP() {
 	http.Handle(defaultRPCPath, server)
 	//  debugHTTP 实例绑定到地址 /debug/gorpc
 	http.Handle(defaultDebugPath, debugHTTP{server})
-	log.Println("rpc server debug path:", defaultDebugPath)
+	http.Handle(defaultDebugJSON, debugJSON{server})
+	if server.closeEndpoint {
+		http.Handle(defaultDebugClose, debugClose{server})
+	}
+	if server.settingsEndpoint {
+		http.Handle(defaultDebugSettings, server.SettingsHandler())
+	}
+	if server.pprof {
+		http.Handle(defaultDebugPprof, debugPprof{})
+	}
+	http.Handle(defaultHealthzPath, server.HealthzHandler())
+	http.Handle(defaultReadyzPath, server.ReadyzHandler())
+	Logf(LevelInfo, "rpc server debug path: %s", defaultDebugPath)
 }
 
 // HandleHTTP 默认服务器注册HTTP注册程序
@@ -1,10 +1,13 @@
 package gorpc
 
 import (
+	"context"
 	"go/ast"
 	"log"
 	"reflect"
+	runtimedebug "runtime/debug"
 	"sync/atomic"
+	"time"
 )
 
 // 方法实例
@@ -17,6 +20,10 @@ type methodType struct {
 	ReplyType reflect.Type
 	// RPC调用序号
 	numCalls uint64
+	// 方法第一个参数是否为 context.Context
+	withContext bool
+	// 错误次数和耗时 用于调试页面
+	stats methodStats
 }
 
 // NumCalls 随机生成
@@ -84,37 +91,63 @@ func (s *service) registerMethods() {
 		method := s.typ.Method(i)
 		mType := method.Type
 		// 筛选条件: 入参为3 出参为1
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 或 入参为4 且第一个参数为 context.Context
+		if (mType.NumIn() != 3 && mType.NumIn() != 4) || mType.NumOut() != 1 {
 			continue
 		}
 		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		withContext := mType.NumIn() == 4
+		if withContext && mType.In(1) != typeOfContext {
+			continue
+		}
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			method:      method,
+			ArgType:     argType,
+			ReplyType:   replyType,
+			withContext: withContext,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+		Logf(LevelInfo, "rpc server: register %s.%s", s.name, method.Name)
 	}
 }
 
 // call 通过反射值调用方法
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext 通过反射值调用方法 方法需要时传入ctx
+// 方法 panic 时返回 *PanicError
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
 	// TODO 通过反射 根据入参 获得返回值
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	start := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: runtimedebug.Stack()}
+			m.stats.record(time.Since(start), err)
+		}
+	}()
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+		err = errInter.(error)
 	}
-	return nil
+	m.stats.record(time.Since(start), err)
+	return err
 }
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // 判断该是否为导出方法
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
@@ -0,0 +1,66 @@
+package gorpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow 计算分位数时保留的最近调用耗时个数
+const latencyWindow = 1024
+
+// methodStats 服务端单个方法的调用指标
+type methodStats struct {
+	mu        sync.Mutex // protect fields below
+	numErrors uint64
+	lastError string
+	lastErrAt time.Time
+	// 最近 latencyWindow 次调用的耗时 环形缓冲区
+	latencies []time.Duration
+	next      int
+}
+
+// record 记录一次调用
+func (s *methodStats) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.numErrors++
+		s.lastError, s.lastErrAt = err.Error(), time.Now()
+	}
+	if len(s.latencies) < latencyWindow {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	s.latencies[s.next] = latency
+	s.next = (s.next + 1) % latencyWindow
+}
+
+// NumErrors 返回出错的调用次数
+func (m *methodType) NumErrors() uint64 {
+	m.stats.mu.Lock()
+	defer m.stats.mu.Unlock()
+	return m.stats.numErrors
+}
+
+// LastError 返回最近一次错误及发生时间 没有错误时返回空字符串
+func (m *methodType) LastError() (string, time.Time) {
+	m.stats.mu.Lock()
+	defer m.stats.mu.Unlock()
+	return m.stats.lastError, m.stats.lastErrAt
+}
+
+// Percentiles 返回最近调用耗时的 p50 p95 p99 没有调用时均为0
+func (m *methodType) Percentiles() (p50, p95, p99 time.Duration) {
+	m.stats.mu.Lock()
+	sorted := append([]time.Duration(nil), m.stats.latencies...)
+	m.stats.mu.Unlock()
+	if len(sorted) == 0 {
+		return
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	return at(0.50), at(0.95), at(0.99)
+}
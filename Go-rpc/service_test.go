@@ -1,8 +1,16 @@
 package gorpc
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -21,6 +29,35 @@ func (f Foo) sum(args Args, reply *int) error {
 	return nil
 }
 
+type Baz int
+
+// Version 以 context.Context 作为第一个参数
+func (b Baz) Version(ctx context.Context, args Args, reply *int) error {
+	_ = SetResponseMetadata(ctx, "version", "1.0")
+	// 回显请求元数据
+	if md, ok := FromIncomingContext(ctx); ok {
+		_ = SetResponseMetadata(ctx, "echo", md["user"])
+	}
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+type Calc int
+
+// Div 除数为0时返回错误
+func (c Calc) Div(args Args, reply *int) error {
+	if args.Num2 == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.Num1 / args.Num2
+	return nil
+}
+
+// Panic 用于测试服务端 panic
+func (c Calc) Panic(args Args, reply *int) error {
+	panic("calc panic")
+}
+
 func _assert(condition bool, msg string, v ...interface{}) {
 	if !condition {
 		panic(fmt.Sprintf("assertion failed: "+msg, v...))
@@ -46,3 +83,110 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+func TestNewService_Context(t *testing.T) {
+	var b Baz
+	s := newService(&b)
+	mType := s.method["Version"]
+	_assert(mType != nil && mType.withContext, "wrong Method, Version shouldn't nil")
+
+	argv := mType.newArgv()
+	replyv := mType.newReplyv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+	ctx, rm := newResponseMetadataContext(context.Background())
+	err := s.callContext(ctx, mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 4, "failed to call Baz.Version")
+	_assert(rm.get()["version"] == "1.0", "expect response metadata")
+}
+
+func TestMethodType_Stats(t *testing.T) {
+	server := NewServer()
+	var c Calc
+	_ = server.Register(&c)
+	svci, _ := server.serviceMap.Load("Calc")
+	s := svci.(*service)
+	mType := s.method["Div"]
+	for _, num2 := range []int{1, 2, 0} {
+		argv := mType.newArgv()
+		argv.Set(reflect.ValueOf(Args{Num1: 4, Num2: num2}))
+		_ = s.call(mType, argv, mType.newReplyv())
+	}
+	_assert(mType.NumCalls() == 3 && mType.NumErrors() == 1, "unexpected stats %d %d", mType.NumCalls(), mType.NumErrors())
+	lastErr, at := mType.LastError()
+	_assert(lastErr == "divide by zero" && !at.IsZero(), "unexpected last error %q", lastErr)
+	p50, _, p99 := mType.Percentiles()
+	_assert(p50 > 0 && p99 >= p50, "unexpected percentiles %v %v", p50, p99)
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	body := w.Body.String()
+	_assert(strings.Contains(body, "Service Calc") && strings.Contains(body, "divide by zero"), "unexpected debug page %s", body)
+
+	w = httptest.NewRecorder()
+	debugJSON{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugJSON, nil))
+	var page struct {
+		Services []debugService `json:"services"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	_assert(len(page.Services) == 1 && page.Services[0].Name == "Calc", "unexpected debug json %s", w.Body.String())
+	div := page.Services[0].Methods["Div"]
+	_assert(div.Calls == 3 && div.Errors == 1 && div.LastError == "divide by zero" && div.ArgType == "gorpc.Args", "unexpected method stats %+v", div)
+}
+
+var expvarRuns int32
+
+func TestServer_PublishExpvar(t *testing.T) {
+	server := NewServer()
+	var c Calc
+	_ = server.Register(&c)
+	// expvar 是进程级的 -count>1 时每次使用不同的名字
+	name := fmt.Sprintf("gorpc_test_%d", atomic.AddInt32(&expvarRuns, 1))
+	_assert(server.PublishExpvar(name) == nil, "failed to publish expvar")
+	_assert(server.PublishExpvar(name) != nil, "expect duplicate name to fail")
+
+	svci, _ := server.serviceMap.Load("Calc")
+	s := svci.(*service)
+	argv := s.method["Div"].newArgv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1}))
+	_ = s.call(s.method["Div"], argv, s.method["Div"].newReplyv())
+	var stats struct {
+		Calls  uint64 `json:"calls"`
+		Errors uint64 `json:"errors"`
+	}
+	_ = json.Unmarshal([]byte(expvar.Get(name).String()), &stats)
+	_assert(stats.Calls == 1 && stats.Errors == 1, "unexpected expvar stats %s", expvar.Get(name))
+}
+
+func TestDebugPprof(t *testing.T) {
+	w := httptest.NewRecorder()
+	debugPprof{}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPprof, nil))
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "goroutine"), "unexpected pprof index %d", w.Code)
+	w = httptest.NewRecorder()
+	debugPprof{}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPprof+"goroutine?debug=1", nil))
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "TestDebugPprof"), "unexpected goroutine profile %d", w.Code)
+}
+
+func TestServer_Readyz(t *testing.T) {
+	server := NewServer()
+	ready := func() int {
+		w := httptest.NewRecorder()
+		server.ReadyzHandler().ServeHTTP(w, httptest.NewRequest("GET", defaultReadyzPath, nil))
+		return w.Code
+	}
+	w := httptest.NewRecorder()
+	server.HealthzHandler().ServeHTTP(w, httptest.NewRequest("GET", defaultHealthzPath, nil))
+	_assert(w.Code == http.StatusOK, "expect healthz ok, got %d", w.Code)
+	_assert(ready() == http.StatusServiceUnavailable, "expect not ready without services")
+
+	var c Calc
+	_ = server.Register(&c)
+	_assert(ready() == http.StatusOK, "expect ready after registering services")
+	var draining error
+	server.AddReadinessCheck("registry", func() error { return draining })
+	draining = errors.New("draining")
+	_assert(ready() == http.StatusServiceUnavailable, "expect not ready while draining")
+	draining = nil
+	server.SetReadyMaxInFlight(1)
+	server.inFlight = 1
+	_assert(ready() == http.StatusServiceUnavailable, "expect not ready above the in-flight limit")
+}
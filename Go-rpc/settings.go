@@ -0,0 +1,179 @@
+package gorpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorpc/codec"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDebugSettings = "/debug/gorpc/settings"
+
+// SetSettingsEndpoint 设置为true时 HandleHTTP 在 /debug/gorpc/settings 挂载 SettingsHandler
+// 默认不挂载 该接口没有认证 需要认证时不要启用 而是自行挂载 SettingsHandler
+// 需要在 HandleHTTP 之前设置
+func (server *Server) SetSettingsEndpoint(enabled bool) {
+	server.settingsEndpoint = enabled
+}
+
+// SetHandleTimeout 设置服务端处理超时的上限 客户端 Option.HandleTimeout 为0或更长时使用该值
+// 默认0 表示只使用客户端的设置 可以在运行时修改 对之后的请求生效
+func (server *Server) SetHandleTimeout(d time.Duration) {
+	atomic.StoreInt64(&server.handleTimeout, int64(d))
+}
+
+// SetMaxConcurrency 设置同时处理的请求数上限 超过时直接返回 ErrServerBusy
+// 默认0 表示不限制 可以在运行时修改
+func (server *Server) SetMaxConcurrency(n int) {
+	atomic.StoreInt64(&server.maxConcurrency, int64(n))
+}
+
+// effectiveHandleTimeout 客户端设置的处理超时与服务端上限中较小的一个 0表示不限制
+func (server *Server) effectiveHandleTimeout(timeout time.Duration) time.Duration {
+	limit := time.Duration(atomic.LoadInt64(&server.handleTimeout))
+	if limit > 0 && (timeout == 0 || timeout > limit) {
+		return limit
+	}
+	return timeout
+}
+
+// rejectBusy 超过并发上限 不调用服务方法直接返回错误
+func (server *Server) rejectBusy(cc codec.Codec, req *request, sending *sync.Mutex) {
+	server.reportError(req.h.ServiceMethod, req.conn.peer, ErrServerBusy)
+	server.recordDuration(req.h.ServiceMethod, 0, ErrServerBusy)
+	h := *req.h
+	h.Metadata = nil
+	h.Error = ErrServerBusy.Error()
+	server.sendResponse(cc, &h, invalidRequest, sending, req.conn)
+}
+
+// settingsView 运行时设置 日志级别对整个进程生效 耗时以 time.Duration 的字符串形式表示 例如 250ms
+type settingsView struct {
+	LogLevel             string            `json:"logLevel"`
+	SlowThreshold        string            `json:"slowThreshold"`
+	MethodSlowThresholds map[string]string `json:"methodSlowThresholds,omitempty"`
+	HandleTimeout        string            `json:"handleTimeout"`
+	MaxConcurrency       int64             `json:"maxConcurrency"`
+}
+
+// settingsUpdate 修改运行时设置的请求 只修改出现的字段
+// MethodSlowThresholds 中的值为0时移除该方法的设置
+type settingsUpdate struct {
+	LogLevel             *string           `json:"logLevel"`
+	SlowThreshold        *string           `json:"slowThreshold"`
+	MethodSlowThresholds map[string]string `json:"methodSlowThresholds"`
+	HandleTimeout        *string           `json:"handleTimeout"`
+	MaxConcurrency       *int64            `json:"maxConcurrency"`
+}
+
+// settings 返回当前的运行时设置
+func (server *Server) settings() *settingsView {
+	v := &settingsView{
+		LogLevel:       GetLogLevel().String(),
+		HandleTimeout:  time.Duration(atomic.LoadInt64(&server.handleTimeout)).String(),
+		MaxConcurrency: atomic.LoadInt64(&server.maxConcurrency),
+	}
+	server.slowMu.RLock()
+	v.SlowThreshold = server.slow.String()
+	if len(server.slowMethods) > 0 {
+		v.MethodSlowThresholds = make(map[string]string, len(server.slowMethods))
+		for method, d := range server.slowMethods {
+			v.MethodSlowThresholds[method] = d.String()
+		}
+	}
+	server.slowMu.RUnlock()
+	return v
+}
+
+// applySettings 先校验全部字段 全部合法后再生效 返回修改过的字段
+func (server *Server) applySettings(u *settingsUpdate) ([]string, error) {
+	var apply []func()
+	var changed []string
+	parse := func(name string, s *string, set func(time.Duration)) error {
+		if s == nil {
+			return nil
+		}
+		d, err := time.ParseDuration(*s)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q", name, *s)
+		}
+		apply = append(apply, func() { set(d) })
+		changed = append(changed, name+"="+d.String())
+		return nil
+	}
+	if u.LogLevel != nil {
+		l, err := ParseLogLevel(*u.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		apply = append(apply, func() { SetLogLevel(l) })
+		changed = append(changed, "logLevel="+l.String())
+	}
+	if err := parse("slowThreshold", u.SlowThreshold, server.SetSlowThreshold); err != nil {
+		return nil, err
+	}
+	for method, s := range u.MethodSlowThresholds {
+		method, s := method, s
+		if err := parse("slowThreshold."+method, &s, func(d time.Duration) { server.SetMethodSlowThreshold(method, d) }); err != nil {
+			return nil, err
+		}
+	}
+	if err := parse("handleTimeout", u.HandleTimeout, server.SetHandleTimeout); err != nil {
+		return nil, err
+	}
+	if n := u.MaxConcurrency; n != nil {
+		if *n < 0 {
+			return nil, fmt.Errorf("invalid maxConcurrency %d", *n)
+		}
+		apply = append(apply, func() { server.SetMaxConcurrency(int(*n)) })
+		changed = append(changed, fmt.Sprintf("maxConcurrency=%d", *n))
+	}
+	for _, f := range apply {
+		f()
+	}
+	return changed, nil
+}
+
+// SettingsHandler 运行时设置的管理接口 GET 返回当前设置
+// POST 以JSON修改部分设置 例如 {"logLevel":"debug","handleTimeout":"2s","maxConcurrency":100}
+// 修改请求必须为 application/json 并拒绝跨站请求 该接口可以修改服务行为 挂载时需要自行加上认证
+func (server *Server) SettingsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt != "application/json" {
+				http.Error(w, "rpc server: settings must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+			if !sameOrigin(req) {
+				http.Error(w, "rpc server: cross-origin request rejected", http.StatusForbidden)
+				return
+			}
+			var u settingsUpdate
+			if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
+				http.Error(w, "rpc server: bad settings: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			changed, err := server.applySettings(&u)
+			if err != nil {
+				http.Error(w, "rpc server: bad settings: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(changed) > 0 {
+				Logf(LevelWarn, "rpc server: settings changed by %s: %s", req.RemoteAddr, strings.Join(changed, " "))
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(server.settings())
+	})
+}
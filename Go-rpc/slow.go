@@ -0,0 +1,70 @@
+package gorpc
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// requestIDKey 客户端通过请求元数据传递的请求ID 未设置时使用请求序列号
+const requestIDKey = "request-id"
+
+// SetSlowThreshold 设置慢调用阈值 处理耗时超过阈值的调用会输出一条警告日志
+// 默认0 表示不记录
+func (server *Server) SetSlowThreshold(d time.Duration) {
+	server.slowMu.Lock()
+	defer server.slowMu.Unlock()
+	server.slow = d
+}
+
+// SetMethodSlowThreshold 设置单个方法的慢调用阈值 优先于 SetSlowThreshold
+// d 为0时 移除该方法的设置
+func (server *Server) SetMethodSlowThreshold(serviceMethod string, d time.Duration) {
+	server.slowMu.Lock()
+	defer server.slowMu.Unlock()
+	if d == 0 {
+		delete(server.slowMethods, serviceMethod)
+		return
+	}
+	if server.slowMethods == nil {
+		server.slowMethods = make(map[string]time.Duration)
+	}
+	server.slowMethods[serviceMethod] = d
+}
+
+// slowThreshold 返回方法的慢调用阈值
+func (server *Server) slowThreshold(serviceMethod string) time.Duration {
+	server.slowMu.RLock()
+	defer server.slowMu.RUnlock()
+	if d, ok := server.slowMethods[serviceMethod]; ok {
+		return d
+	}
+	return server.slow
+}
+
+// logSlowCall 调用耗时超过阈值时输出警告日志
+func (server *Server) logSlowCall(req *request, elapsed time.Duration, err error) {
+	threshold := server.slowThreshold(req.h.ServiceMethod)
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	requestID := req.h.Metadata[requestIDKey]
+	if requestID == "" {
+		requestID = strconv.FormatUint(req.h.Seq, 10)
+	}
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	Logf(LevelWarn, "rpc server: slow call method=%s duration=%s threshold=%s peer=%s request_id=%s error=%q",
+		req.h.ServiceMethod, elapsed, threshold, req.conn.peer, requestID, errText)
+}
+
+// peerAddr 返回连接的对端地址 无法获取时返回空字符串
+func peerAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
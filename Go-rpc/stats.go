@@ -0,0 +1,230 @@
+package gorpc
+
+import (
+	"gorpc/codec"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsHandler 客户端指标回调 通过 Option.Stats 设置
+// 实现需要并发安全 可以对接任意指标系统
+type StatsHandler interface {
+	// CallStart 请求发出 in-flight +1
+	CallStart(serviceMethod string)
+	// CallEnd 请求结束(成功、失败或取消) in-flight -1
+	CallEnd(serviceMethod string, latency time.Duration, err error)
+	// BytesWritten 连接上写出的字节数
+	BytesWritten(n int)
+	// BytesRead 连接上读取的字节数
+	BytesRead(n int)
+	// Reconnect 连接不可用后重新建立连接
+	Reconnect(rpcAddr string)
+}
+
+// statsConn 统计连接上读写的字节数
+type statsConn struct {
+	net.Conn
+	stats StatsHandler
+}
+
+func (c *statsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.stats.BytesRead(n)
+	}
+	return n, err
+}
+
+func (c *statsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.stats.BytesWritten(n)
+	}
+	return n, err
+}
+
+// reportStart 记录请求开始
+func (call *Call) reportStart(stats StatsHandler) {
+	if stats == nil {
+		return
+	}
+	call.stats = stats
+	stats.CallStart(call.ServiceMethod)
+}
+
+// reportEnd 记录请求结束 只记录一次
+func (call *Call) reportEnd(err error) {
+	onError, meter := call.onError(), call.meter()
+	if (call.stats == nil && call.span == nil && onError == nil && meter == nil) || !atomic.CompareAndSwapInt32(&call.reported, 0, 1) {
+		return
+	}
+	call.endSpan(err)
+	if meter != nil {
+		meter.RecordDuration(MetricClientDuration, time.Since(call.start), callAttributes(call.ServiceMethod, err))
+	}
+	if err != nil && onError != nil {
+		onError(&ErrorInfo{ServiceMethod: call.ServiceMethod, Peer: call.client.peer, Err: err})
+	}
+	if call.stats == nil {
+		return
+	}
+	call.stats.CallEnd(call.ServiceMethod, time.Since(call.start), err)
+}
+
+// onError 返回客户端的错误回调
+func (call *Call) onError() ErrorHandler {
+	if call.client == nil {
+		return nil
+	}
+	return call.client.opt.OnError
+}
+
+// meter 返回客户端的推送式指标
+func (call *Call) meter() Meter {
+	if call.client == nil {
+		return nil
+	}
+	return call.client.opt.Meter
+}
+
+// MethodStats 单个方法的指标
+type MethodStats struct {
+	Calls    uint64
+	Errors   uint64
+	InFlight int64
+	// 累计耗时 平均耗时 = TotalLatency / Calls
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// ClientStats StatsHandler 的内置实现 按方法汇总指标
+type ClientStats struct {
+	mu           sync.Mutex // protect methods and codecs
+	methods      map[string]*MethodStats
+	codecs       map[CodecKey]*CodecStats
+	bytesWritten uint64
+	bytesRead    uint64
+	reconnects   uint64
+}
+
+var _ StatsHandler = (*ClientStats)(nil)
+var _ CodecStatsHandler = (*ClientStats)(nil)
+
+// CodecKey 编解码指标按编码方式和方法分组
+type CodecKey struct {
+	Codec         codec.Type
+	ServiceMethod string
+}
+
+// CodecStats 编解码指标 平均大小 = EncodedBytes / Encodes
+type CodecStats struct {
+	Encodes      uint64
+	EncodeTime   time.Duration
+	EncodedBytes uint64
+	Decodes      uint64
+	DecodeTime   time.Duration
+	DecodedBytes uint64
+}
+
+// NewClientStats 创建指标汇总实例
+func NewClientStats() *ClientStats {
+	return &ClientStats{methods: make(map[string]*MethodStats), codecs: make(map[CodecKey]*CodecStats)}
+}
+
+func (s *ClientStats) codec(codecType codec.Type, serviceMethod string) *CodecStats {
+	key := CodecKey{Codec: codecType, ServiceMethod: serviceMethod}
+	c := s.codecs[key]
+	if c == nil {
+		c = &CodecStats{}
+		s.codecs[key] = c
+	}
+	return c
+}
+
+func (s *ClientStats) Encode(codecType codec.Type, serviceMethod string, d time.Duration, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.codec(codecType, serviceMethod)
+	c.Encodes++
+	c.EncodeTime += d
+	c.EncodedBytes += uint64(size)
+}
+
+func (s *ClientStats) Decode(codecType codec.Type, serviceMethod string, d time.Duration, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.codec(codecType, serviceMethod)
+	c.Decodes++
+	c.DecodeTime += d
+	c.DecodedBytes += uint64(size)
+}
+
+// Codecs 返回编解码指标的快照
+func (s *ClientStats) Codecs() map[CodecKey]CodecStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[CodecKey]CodecStats, len(s.codecs))
+	for key, c := range s.codecs {
+		out[key] = *c
+	}
+	return out
+}
+
+func (s *ClientStats) method(serviceMethod string) *MethodStats {
+	m := s.methods[serviceMethod]
+	if m == nil {
+		m = &MethodStats{}
+		s.methods[serviceMethod] = m
+	}
+	return m
+}
+
+func (s *ClientStats) CallStart(serviceMethod string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.method(serviceMethod).InFlight++
+}
+
+func (s *ClientStats) CallEnd(serviceMethod string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.method(serviceMethod)
+	m.InFlight--
+	m.Calls++
+	if err != nil {
+		m.Errors++
+	}
+	m.TotalLatency += latency
+	if latency > m.MaxLatency {
+		m.MaxLatency = latency
+	}
+}
+
+func (s *ClientStats) BytesWritten(n int) { atomic.AddUint64(&s.bytesWritten, uint64(n)) }
+
+func (s *ClientStats) BytesRead(n int) { atomic.AddUint64(&s.bytesRead, uint64(n)) }
+
+func (s *ClientStats) Reconnect(string) { atomic.AddUint64(&s.reconnects, 1) }
+
+// Methods 返回每个方法指标的快照
+func (s *ClientStats) Methods() map[string]MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]MethodStats, len(s.methods))
+	for name, m := range s.methods {
+		out[name] = *m
+	}
+	return out
+}
+
+// Bytes 返回累计写出和读取的字节数
+func (s *ClientStats) Bytes() (written, read uint64) {
+	return atomic.LoadUint64(&s.bytesWritten), atomic.LoadUint64(&s.bytesRead)
+}
+
+// Reconnects 返回累计重连次数
+func (s *ClientStats) Reconnects() uint64 {
+	return atomic.LoadUint64(&s.reconnects)
+}
@@ -0,0 +1,69 @@
+package gorpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// MutualTLSConfig 双向TLS的服务端配置 使用 cert 作为服务端证书 要求客户端证书由 clientCAs 签发
+func MutualTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+// ServeTLS 在 lis 上接受TLS连接 并处理请求
+// config 设置了 ClientCAs 而没有设置 ClientAuth 时 要求并验证客户端证书
+// 验证通过的客户端身份通过 IdentityFromContext 获取
+func (server *Server) ServeTLS(lis net.Listener, config *tls.Config) {
+	if config.ClientCAs != nil && config.ClientAuth == tls.NoClientCert {
+		config = config.Clone()
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// ServeTLS 默认服务器接受TLS连接
+func ServeTLS(lis net.Listener, config *tls.Config) { DefaultServer.ServeTLS(lis, config) }
+
+// tlsIdentity 完成TLS握手 返回验证通过的客户端证书对应的身份 没有验证客户端证书时返回nil
+func tlsIdentity(conn *tls.Conn) (*Identity, error) {
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	return stateIdentity(conn.ConnectionState()), nil
+}
+
+// stateIdentity 返回验证通过的客户端证书对应的身份 没有验证客户端证书时返回nil
+func stateIdentity(state tls.ConnectionState) *Identity {
+	chains := state.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return newCertIdentity(chains[0][0])
+}
+
+// newCertIdentity 以证书的 CN 作为名称 CN 为空时使用第一个 SAN
+func newCertIdentity(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		Name:           cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	if id.Name == "" {
+		for _, sans := range [][]string{id.URIs, id.DNSNames, id.EmailAddresses} {
+			if len(sans) > 0 {
+				id.Name = sans[0]
+				break
+			}
+		}
+	}
+	return id
+}
@@ -0,0 +1,185 @@
+package gorpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 追踪上下文使用 W3C Trace Context 格式 随请求元数据传递
+// 例如 traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+const traceparentKey = "traceparent"
+
+// SpanKind span的类型
+type SpanKind int
+
+const (
+	SpanClient SpanKind = iota
+	SpanServer
+)
+
+func (k SpanKind) String() string {
+	if k == SpanServer {
+		return "server"
+	}
+	return "client"
+}
+
+// Span 一次调用对应的span 实现需要并发安全
+type Span interface {
+	// RecordError 记录调用失败
+	RecordError(err error)
+	// End 结束span 只调用一次
+	End()
+}
+
+// Tracer 链路追踪接口 客户端通过 Option.Tracer 设置 服务端通过 Server.SetTracer 设置
+// 可以基于 OpenTelemetry 的 Tracer 和 TextMapPropagator 实现 将 Metadata 作为 carrier
+type Tracer interface {
+	// Start 以ctx中的span为父span开始一个新的span
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+	// Inject 客户端将ctx中的追踪上下文写入请求元数据
+	Inject(ctx context.Context, md Metadata)
+	// Extract 服务端从请求元数据中恢复追踪上下文
+	Extract(ctx context.Context, md Metadata) context.Context
+}
+
+// SpanData 内置Tracer结束的span
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	Duration     time.Duration
+	Error        error
+}
+
+// spanContext 追踪上下文
+type spanContext struct {
+	traceID string
+	spanID  string
+	flags   string
+}
+
+type spanContextKey struct{}
+
+// TraceIDFromContext 返回ctx中的 trace id 和 span id 例如在服务方法中打印日志
+func TraceIDFromContext(ctx context.Context) (traceID, spanID string, ok bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc.traceID, sc.spanID, ok
+}
+
+// traceTracer Tracer 的内置实现 使用 W3C traceparent 传递追踪上下文
+type traceTracer struct {
+	export func(*SpanData)
+}
+
+// NewTracer 创建内置的Tracer span结束时调用 export 导出 export 需要并发安全
+func NewTracer(export func(*SpanData)) Tracer {
+	return &traceTracer{export: export}
+}
+
+func (t *traceTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	parent, ok := ctx.Value(spanContextKey{}).(spanContext)
+	sc := spanContext{traceID: parent.traceID, spanID: randomHex(8), flags: parent.flags}
+	if !ok {
+		sc.traceID, sc.flags = randomHex(16), "01"
+	}
+	span := &traceSpan{tracer: t, data: SpanData{
+		TraceID:      sc.traceID,
+		SpanID:       sc.spanID,
+		ParentSpanID: parent.spanID,
+		Name:         name,
+		Kind:         kind,
+		Start:        time.Now(),
+	}}
+	return context.WithValue(ctx, spanContextKey{}, sc), span
+}
+
+func (t *traceTracer) Inject(ctx context.Context, md Metadata) {
+	if sc, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		md[traceparentKey] = "00-" + sc.traceID + "-" + sc.spanID + "-" + sc.flags
+	}
+}
+
+func (t *traceTracer) Extract(ctx context.Context, md Metadata) context.Context {
+	if sc, ok := parseTraceparent(md[traceparentKey]); ok {
+		return context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	return ctx
+}
+
+// parseTraceparent 解析 version-traceid-spanid-flags 格式
+func parseTraceparent(v string) (spanContext, bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, false
+	}
+	for _, p := range parts[1:] {
+		if _, err := hex.DecodeString(p); err != nil {
+			return spanContext{}, false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return spanContext{}, false
+	}
+	return spanContext{traceID: parts[1], spanID: parts[2], flags: parts[3]}, true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type traceSpan struct {
+	tracer *traceTracer
+	mu     sync.Mutex // protect data
+	data   SpanData
+}
+
+func (s *traceSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err
+}
+
+func (s *traceSpan) End() {
+	s.mu.Lock()
+	s.data.Duration = time.Since(s.data.Start)
+	data := s.data
+	s.mu.Unlock()
+	if s.tracer.export != nil {
+		s.tracer.export(&data)
+	}
+}
+
+// startSpan 客户端开始span 并将追踪上下文写入请求元数据
+func (call *Call) startSpan(ctx context.Context, tracer Tracer) {
+	if tracer == nil {
+		return
+	}
+	ctx, call.span = tracer.Start(ctx, call.ServiceMethod, SpanClient)
+	md := call.outgoing.Copy()
+	if md == nil {
+		md = make(Metadata)
+	}
+	tracer.Inject(ctx, md)
+	call.outgoing = md
+}
+
+// endSpan 结束客户端span
+func (call *Call) endSpan(err error) {
+	if call.span == nil {
+		return
+	}
+	if err != nil {
+		call.span.RecordError(err)
+	}
+	call.span.End()
+}
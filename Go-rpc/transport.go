@@ -0,0 +1,48 @@
+package gorpc
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Transport 自定义传输层
+// 编解码器只依赖 io.ReadWriteCloser 任何可靠的字节流都可以作为传输层
+// gorpc/kcp 提供了基于UDP的KCP(可靠UDP) 传输 导入后即注册 kcp 协议：
+//
+//	import _ "gorpc/kcp"
+//
+// 注册后即可使用 kcp@10.0.0.1:9999 调用 XDial 和 Listen
+type Transport interface {
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+	Listen(addr string) (net.Listener, error)
+}
+
+// DialFunc 自定义拨号函数 通过 Option.Dialer 注入
+// 可以经由 SOCKS5、SSH隧道建立连接 或在测试中返回模拟连接
+// golang.org/x/net/proxy 的 Dialer 可以这样适配：
+//
+//	socks5, _ := proxy.SOCKS5("tcp", "127.0.0.1:1080", nil, proxy.Direct)
+//	opt.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+//		return socks5.Dial(network, addr)
+//	}
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]Transport)
+)
+
+// RegisterTransport 注册自定义传输层 protocol 已存在时覆盖
+func RegisterTransport(protocol string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[protocol] = t
+}
+
+// getTransport 返回已注册的传输层 不存在返回nil
+func getTransport(protocol string) Transport {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	return transports[protocol]
+}
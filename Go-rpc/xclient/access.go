@@ -0,0 +1,90 @@
+package xclient
+
+import "context"
+
+// Deny 将实例加入黑名单 立即生效 不再被选中
+// 通过 WithTargetServer 固定到黑名单实例的调用返回 ErrNoAvailableServers
+func (xc *XClient) Deny(rpcAddrs ...string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.denied == nil {
+		xc.denied = make(map[string]bool)
+	}
+	for _, rpcAddr := range rpcAddrs {
+		xc.denied[rpcAddr] = true
+	}
+}
+
+// Undeny 将实例移出黑名单
+func (xc *XClient) Undeny(rpcAddrs ...string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for _, rpcAddr := range rpcAddrs {
+		delete(xc.denied, rpcAddr)
+	}
+}
+
+// SetAllowList 只在白名单中的实例中选择 立即生效 nil 表示不限制
+func (xc *XClient) SetAllowList(rpcAddrs []string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if rpcAddrs == nil {
+		xc.allowed = nil
+		return
+	}
+	xc.allowed = make(map[string]bool, len(rpcAddrs))
+	for _, rpcAddr := range rpcAddrs {
+		xc.allowed[rpcAddr] = true
+	}
+}
+
+// permitted 实例是否允许被选中
+func (xc *XClient) permitted(rpcAddr string) bool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.denied[rpcAddr] {
+		return false
+	}
+	return xc.allowed == nil || xc.allowed[rpcAddr]
+}
+
+// filterPermitted 过滤掉不允许被选中的实例
+func (xc *XClient) filterPermitted(servers []string) []string {
+	permitted := servers[:0]
+	for _, s := range servers {
+		if xc.permitted(s) {
+			permitted = append(permitted, s)
+		}
+	}
+	return permitted
+}
+
+// enforceAccess 选中的实例不允许被选中或不满足本次调用的筛选条件时 在其余实例中重新选择
+func (xc *XClient) enforceAccess(ctx context.Context, mode SelectMode, rpcAddr string) (string, error) {
+	if xc.permitted(rpcAddr) && xc.selected(ctx, rpcAddr) {
+		return rpcAddr, nil
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", err
+	}
+	return xc.selectFrom(ctx, mode, servers)
+}
+
+// selectFrom 在 servers 中按负载均衡模式选择
+// Discovery 没有实现 CandidateDiscovery 时 一致性哈希在 servers 构成的哈希环上选择 其余模式随机选择
+func (xc *XClient) selectFrom(ctx context.Context, mode SelectMode, servers []string) (string, error) {
+	if len(servers) == 0 {
+		return "", ErrNoAvailableServers
+	}
+	key, _ := HashKeyFromContext(ctx)
+	if cd, ok := xc.d.(CandidateDiscovery); ok && mode != P2CSelect {
+		return cd.SelectFrom(servers, mode, key)
+	}
+	if mode == ConsistentHashSelect {
+		return newHashRing(servers, defaultReplicas).get(key), nil
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return servers[xc.r.Intn(len(servers))], nil
+}
@@ -0,0 +1,121 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type sessionKey struct{}
+
+// WithSessionKey 设置会话key 开启 SetAffinityTTL 后
+// 相同key的调用会发往上一次成功处理该key的实例
+func WithSessionKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, key)
+}
+
+// SessionKeyFromContext 获取ctx中的会话key
+func SessionKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(sessionKey{}).(string)
+	return key, ok
+}
+
+type affinityEntry struct {
+	rpcAddr string
+	expire  time.Time
+}
+
+// affinity 会话key到实例的映射 超过ttl没有调用则过期
+type affinity struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*affinityEntry
+	lastSweep time.Time
+}
+
+func newAffinity(ttl time.Duration) *affinity {
+	return &affinity{
+		ttl:       ttl,
+		entries:   make(map[string]*affinityEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// get 返回key绑定的实例 实例需要在 servers 中
+func (a *affinity) get(key string, servers []string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.expire) {
+		delete(a.entries, key)
+		return "", false
+	}
+	for _, s := range servers {
+		if s == e.rpcAddr {
+			return s, true
+		}
+	}
+	// 实例已经下线
+	delete(a.entries, key)
+	return "", false
+}
+
+// set 绑定key到实例 并顺带清理过期的记录
+func (a *affinity) set(key, rpcAddr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	a.entries[key] = &affinityEntry{rpcAddr: rpcAddr, expire: now.Add(a.ttl)}
+	if now.Sub(a.lastSweep) < a.ttl {
+		return
+	}
+	for k, e := range a.entries {
+		if now.After(e.expire) {
+			delete(a.entries, k)
+		}
+	}
+	a.lastSweep = now
+}
+
+// SetAffinityTTL 开启会话保持 ttl 内没有调用的会话会被遗忘
+// ttl 不大于0时关闭
+func (xc *XClient) SetAffinityTTL(ttl time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if ttl <= 0 {
+		xc.affinity = nil
+		return
+	}
+	xc.affinity = newAffinity(ttl)
+}
+
+func (xc *XClient) getAffinity() *affinity {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.affinity
+}
+
+// sticky 返回会话绑定的实例
+func (xc *XClient) sticky(ctx context.Context) (string, bool) {
+	key, ok := SessionKeyFromContext(ctx)
+	a := xc.getAffinity()
+	if !ok || a == nil {
+		return "", false
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", false
+	}
+	return a.get(key, servers)
+}
+
+// bind 调用成功后 将会话绑定到处理该调用的实例
+func (xc *XClient) bind(ctx context.Context, rpcAddr string) {
+	key, ok := SessionKeyFromContext(ctx)
+	if a := xc.getAffinity(); ok && a != nil {
+		a.set(key, rpcAddr)
+	}
+}
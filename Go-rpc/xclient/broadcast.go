@@ -0,0 +1,347 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrQuorumNotReached 没有足够的实例返回相同的结果
+var ErrQuorumNotReached = errors.New("rpc xclient: quorum not reached")
+
+// BroadcastPolicy 广播时部分实例失败的处理策略
+type BroadcastPolicy int
+
+const (
+	// BroadcastFailFast 任意一个实例失败即取消其余调用 返回该错误 默认
+	BroadcastFailFast BroadcastPolicy = iota
+	// BroadcastBestEffort 调用所有实例 至少一个成功即返回成功
+	BroadcastBestEffort
+	// BroadcastQuorum 超过半数实例成功即返回成功
+	BroadcastQuorum
+)
+
+// SetBroadcast 设置广播的失败处理策略 以及最大并发数
+func (xc *XClient) SetBroadcast(policy BroadcastPolicy, maxParallel int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.broadcastPolicy = policy
+	xc.maxParallel = maxParallel
+}
+
+// Broadcast 广播服务 包括处于 draining 的实例
+// 成功时 reply 为其中一个实例的结果 失败时返回其中一个错误
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return xc.invoke(ctx, serviceMethod, args, reply, xc.broadcast)
+}
+
+func (xc *XClient) broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	ctx = withService(ctx, serviceMethod)
+	servers, err := xc.allServers(ctx)
+	if err != nil {
+		return err
+	}
+	xc.mu.Lock()
+	policy, maxParallel := xc.broadcastPolicy, xc.maxParallel
+	xc.mu.Unlock()
+
+	var wg sync.WaitGroup
+	// 并发 广播
+	var mu sync.Mutex
+	var e error
+	succeeded, failed := 0, 0
+	// 法定数量 超过半数
+	quorum := len(servers)/2 + 1
+
+	replyDone := reply == nil // if reply is nil, don't need to set value
+	// 确保有错误发生的时候 快速失败
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 限制并发数
+	var sem chan struct{}
+	if maxParallel > 0 {
+		sem = make(chan struct{}, maxParallel)
+	}
+loop:
+	for _, rpcAddr := range servers {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				mu.Lock()
+				if e == nil {
+					e = ctx.Err()
+				}
+				mu.Unlock()
+				break loop
+			}
+		}
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			// 如果调用成功，则返回其中一个的结果
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				if e == nil {
+					e = err
+				}
+				// 快速失败 或已经不可能达到法定数量时 取消其余调用
+				if policy == BroadcastFailFast || (policy == BroadcastQuorum && failed > len(servers)-quorum) {
+					cancel()
+				}
+				return
+			}
+			succeeded++
+			if !replyDone {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				replyDone = true
+			}
+		}(rpcAddr)
+	}
+	wg.Wait()
+	switch policy {
+	case BroadcastBestEffort:
+		if succeeded > 0 {
+			return nil
+		}
+	case BroadcastQuorum:
+		if succeeded >= quorum {
+			return nil
+		}
+		if e == nil {
+			return ErrNoAvailableServers
+		}
+	}
+	return e
+}
+
+// Result 广播中单个实例的调用结果
+type Result struct {
+	// 与传入的reply类型相同的新实例
+	Reply interface{}
+	Error error
+	// 调用耗时
+	Duration time.Duration
+}
+
+// BroadcastDetailed 向所有实例广播 返回每个实例的调用结果 k:v -> 服务地址:结果
+// reply 仅作为响应类型的模板 不会被写入
+// 单个实例失败不会中断其他实例的调用 只有获取服务列表失败时返回error
+func (xc *XClient) BroadcastDetailed(ctx context.Context, serviceMethod string, args, reply interface{}) (map[string]Result, error) {
+	var results map[string]Result
+	err := xc.invoke(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+		results, err = xc.broadcastDetailed(ctx, serviceMethod, args, reply)
+		return
+	})
+	return results, err
+}
+
+func (xc *XClient) broadcastDetailed(ctx context.Context, serviceMethod string, args, reply interface{}) (map[string]Result, error) {
+	ctx = withService(ctx, serviceMethod)
+	servers, err := xc.allServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoAvailableServers
+	}
+	results := make(map[string]Result, len(servers))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			var r interface{}
+			if reply != nil {
+				r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			start := time.Now()
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, r)
+			mu.Lock()
+			results[rpcAddr] = Result{Reply: r, Error: err, Duration: time.Since(start)}
+			mu.Unlock()
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// Fork 向所有实例发送请求 返回最先成功的结果 并取消其余调用
+// 全部失败时返回其中一个错误
+func (xc *XClient) Fork(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return xc.ForkN(ctx, 0, serviceMethod, args, reply)
+}
+
+// ForkN 向随机选取的n个实例发送请求 n不大于0时发送给所有实例
+func (xc *XClient) ForkN(ctx context.Context, n int, serviceMethod string, args, reply interface{}) error {
+	return xc.invoke(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return xc.fork(ctx, n, serviceMethod, args, reply)
+	})
+}
+
+func (xc *XClient) fork(ctx context.Context, n int, serviceMethod string, args, reply interface{}) error {
+	ctx = withService(ctx, serviceMethod)
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return ErrNoAvailableServers
+	}
+	if n > 0 && n < len(servers) {
+		xc.mu.Lock()
+		xc.r.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+		xc.mu.Unlock()
+		servers = servers[:n]
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	// 缓存信道 被取消的请求返回后不会阻塞
+	ch := make(chan result, len(servers))
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			var r interface{}
+			if reply != nil {
+				r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			ch <- result{reply: r, err: xc.call(rpcAddr, ctx, serviceMethod, args, r)}
+		}(rpcAddr)
+	}
+	for range servers {
+		res := <-ch
+		if res.err == nil {
+			if reply != nil {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(res.reply).Elem())
+			}
+			return nil
+		}
+		err = res.err
+	}
+	return err
+}
+
+// QuorumBroadcast 向所有实例广播 k个实例返回相同的结果即成功 reply 为该结果
+// 成功后不再等待其余实例 其余请求可能已被服务端处理
+// 无法达到k个相同结果时返回 ErrQuorumNotReached 或其中一个错误
+func (xc *XClient) QuorumBroadcast(ctx context.Context, k int, serviceMethod string, args, reply interface{}) error {
+	return xc.invoke(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return xc.quorumBroadcast(ctx, k, serviceMethod, args, reply)
+	})
+}
+
+func (xc *XClient) quorumBroadcast(ctx context.Context, k int, serviceMethod string, args, reply interface{}) error {
+	ctx = withService(ctx, serviceMethod)
+	servers, err := xc.allServers(ctx)
+	if err != nil {
+		return err
+	}
+	if k <= 0 || k > len(servers) {
+		return fmt.Errorf("%w: need %d of %d instances", ErrQuorumNotReached, k, len(servers))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	ch := make(chan result, len(servers))
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			var r interface{}
+			if reply != nil {
+				r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			ch <- result{reply: r, err: xc.call(rpcAddr, ctx, serviceMethod, args, r)}
+		}(rpcAddr)
+	}
+	// 相同结果的分组
+	type group struct {
+		reply interface{}
+		count int
+	}
+	var groups []*group
+	failed := 0
+	var e error
+	for range servers {
+		res := <-ch
+		if res.err != nil {
+			failed++
+			e = res.err
+			// 剩余实例全部成功也无法达到法定数量
+			if failed > len(servers)-k {
+				return e
+			}
+			continue
+		}
+		var g *group
+		for _, candidate := range groups {
+			if reflect.DeepEqual(candidate.reply, res.reply) {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			g = &group{reply: res.reply}
+			groups = append(groups, g)
+		}
+		if g.count++; g.count >= k {
+			if reply != nil {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(g.reply).Elem())
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no %d instances agreed", ErrQuorumNotReached, k)
+}
+
+// Map 分散-汇总 argsPerServer k:v -> 服务地址:该实例的参数
+// 每个实例的调用结果依次交给 reduce 处理 reduce 不会被并发调用
+// reply 仅作为响应类型的模板 传给 reduce 的是新的实例
+// 所有调用结束后返回 ctx 提前结束时返回 ctx 的错误
+func (xc *XClient) Map(ctx context.Context, serviceMethod string, argsPerServer map[string]interface{}, reply interface{},
+	reduce func(rpcAddr string, reply interface{}, err error)) error {
+	return xc.invoke(ctx, serviceMethod, argsPerServer, reply, func(ctx context.Context, serviceMethod string, _, reply interface{}) error {
+		return xc.scatter(ctx, serviceMethod, argsPerServer, reply, reduce)
+	})
+}
+
+func (xc *XClient) scatter(ctx context.Context, serviceMethod string, argsPerServer map[string]interface{}, reply interface{},
+	reduce func(rpcAddr string, reply interface{}, err error)) error {
+	ctx = withService(ctx, serviceMethod)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for rpcAddr, args := range argsPerServer {
+		wg.Add(1)
+		go func(rpcAddr string, args interface{}) {
+			defer wg.Done()
+			var r interface{}
+			if reply != nil {
+				r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, r)
+			mu.Lock()
+			defer mu.Unlock()
+			reduce(rpcAddr, r, err)
+		}(rpcAddr, args)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,90 @@
+package xclient
+
+import (
+	"sync"
+	"time"
+)
+
+// 重试预算统计的时间窗口 按秒分桶
+const budgetWindow = 10
+
+// retryBudget 限制最近一段时间内重试次数占调用次数的比例
+// 下游整体不可用时 避免重试把流量放大数倍
+type retryBudget struct {
+	mu    sync.Mutex
+	ratio float64
+	// 每秒至少允许的重试次数 调用量很小时也能重试
+	minPerSec int
+	calls     [budgetWindow]int
+	retries   [budgetWindow]int
+	// 每个桶对应的秒数
+	seconds [budgetWindow]int64
+}
+
+// bucket 返回当前秒对应的桶 过期的桶清零
+func (b *retryBudget) bucket(now time.Time) int {
+	sec := now.Unix()
+	i := int(sec % budgetWindow)
+	if b.seconds[i] != sec {
+		b.seconds[i], b.calls[i], b.retries[i] = sec, 0, 0
+	}
+	return i
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls[b.bucket(time.Now())]++
+}
+
+// withdraw 预算充足时记录一次重试并返回true
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	i := b.bucket(now)
+	calls, retries := 0, 0
+	for j := range b.seconds {
+		if now.Unix()-b.seconds[j] < budgetWindow {
+			calls += b.calls[j]
+			retries += b.retries[j]
+		}
+	}
+	if float64(retries) >= b.ratio*float64(calls) && retries >= b.minPerSec*budgetWindow {
+		return false
+	}
+	b.retries[i]++
+	return true
+}
+
+// SetRetryBudget 开启重试预算 最近10s内的重试次数不超过调用次数的 ratio 倍
+// 同时每秒至少允许 minPerSec 次重试 对 Failover、Failtry、Failbackup 生效
+// ratio 不大于0时关闭
+func (xc *XClient) SetRetryBudget(ratio float64, minPerSec int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if ratio <= 0 {
+		xc.budget = nil
+		return
+	}
+	xc.budget = &retryBudget{ratio: ratio, minPerSec: minPerSec}
+}
+
+func (xc *XClient) retryBudget() *retryBudget {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.budget
+}
+
+// depositBudget 记录一次调用
+func (xc *XClient) depositBudget() {
+	if b := xc.retryBudget(); b != nil {
+		b.deposit()
+	}
+}
+
+// allowRetry 重试预算是否允许再重试一次
+func (xc *XClient) allowRetry() bool {
+	b := xc.retryBudget()
+	return b == nil || b.withdraw()
+}
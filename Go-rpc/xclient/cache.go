@@ -0,0 +1,66 @@
+package xclient
+
+import (
+	"encoding/json"
+	"gorpc"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// SetCacheFile 将从注册中心获取的服务列表保存到本地文件
+// 启动时注册中心不可用 则使用文件中上次保存的列表 避免注册中心故障期间重启的客户端无实例可用
+func (d *GoRegistryDiscovery) SetCacheFile(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cacheFile = path
+	d.cached = nil
+}
+
+// saveCache 列表变化时写入缓存文件 先写临时文件再重命名 避免写入中断损坏缓存 调用时需持有锁
+func (d *GoRegistryDiscovery) saveCache(items []registryServer) {
+	if d.cacheFile == "" || (d.cached != nil && reflect.DeepEqual(d.cached, items)) {
+		return
+	}
+	if err := writeCacheFile(d.cacheFile, items); err != nil {
+		gorpc.Logf(gorpc.LevelWarn, "rpc registry: save cache err: %v", err)
+		return
+	}
+	d.cached = items
+}
+
+func writeCacheFile(path string, items []registryServer) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// loadCache 读取缓存文件中的服务列表 调用时需持有锁
+func (d *GoRegistryDiscovery) loadCache() ([]registryServer, error) {
+	data, err := os.ReadFile(d.cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var items []registryServer
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	d.cached = items
+	return items, nil
+}
@@ -0,0 +1,58 @@
+package xclient
+
+import "context"
+
+// canary 金丝雀发布配置
+type canary struct {
+	// 金丝雀实例的 version 元数据
+	version string
+	// 发往金丝雀实例的流量比例 0~1
+	percent float64
+}
+
+// SetCanary 将 percent(0~1) 比例的调用发往元数据 version 为 version 的实例
+// 其余调用只发往其他实例 percent 不大于0时关闭
+// 需要 Discovery 实现 MetadataDiscovery
+func (xc *XClient) SetCanary(version string, percent float64) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if percent <= 0 {
+		xc.canary = nil
+		return
+	}
+	xc.canary = &canary{version: version, percent: percent}
+}
+
+// selectCanary 按比例在金丝雀实例和其他实例之间分流
+// rpcAddr 为按负载均衡模式选出的实例 属于目标分组时直接使用
+func (xc *XClient) selectCanary(ctx context.Context, rpcAddr string) string {
+	xc.mu.Lock()
+	c := xc.canary
+	toCanary := c != nil && xc.r.Float64() < c.percent
+	xc.mu.Unlock()
+	d, ok := xc.d.(MetadataDiscovery)
+	if c == nil || !ok {
+		return rpcAddr
+	}
+	isCanary := func(s string) bool { return d.GetMetadata(s)["version"] == c.version }
+	if isCanary(rpcAddr) == toCanary {
+		return rpcAddr
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return rpcAddr
+	}
+	group := servers[:0]
+	for _, s := range servers {
+		if isCanary(s) == toCanary {
+			group = append(group, s)
+		}
+	}
+	// 目标分组没有实例时 不做分流
+	if len(group) == 0 {
+		return rpcAddr
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return group[xc.r.Intn(len(group))]
+}
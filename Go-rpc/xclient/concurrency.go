@@ -0,0 +1,83 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	. "gorpc"
+)
+
+// SetMaxConcurrency 限制每个实例同时进行的调用数 默认0 表示不设限
+// 达到上限时 overflow 为true 优先选择其他未满的实例 都已满时排队等待
+// overflow 为false 直接排队等待 等待期间ctx结束返回错误
+func (xc *XClient) SetMaxConcurrency(n int, overflow bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.maxConcurrency = n
+	xc.overflow = overflow
+	// 上限变化后重新创建信号量 已持有的旧信号量在调用结束后释放
+	for _, s := range xc.stats {
+		s.mu.Lock()
+		s.slots = nil
+		s.mu.Unlock()
+	}
+}
+
+// slotsOf 返回实例的信号量 没有上限时返回nil
+func (xc *XClient) slotsOf(rpcAddr string) chan struct{} {
+	xc.mu.Lock()
+	n := xc.maxConcurrency
+	xc.mu.Unlock()
+	if n <= 0 {
+		return nil
+	}
+	s := xc.instance(rpcAddr)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots == nil {
+		s.slots = make(chan struct{}, n)
+	}
+	return s.slots
+}
+
+// acquire 占用实例的一个并发名额 返回释放函数
+func (xc *XClient) acquire(ctx context.Context, rpcAddr string) (func(), error) {
+	slots := xc.slotsOf(rpcAddr)
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: waiting for instance %s: %v", ErrTooManyPendingCalls, rpcAddr, ctx.Err())
+	}
+}
+
+// full 实例是否已达到并发上限
+func (xc *XClient) full(rpcAddr string) bool {
+	slots := xc.slotsOf(rpcAddr)
+	return slots != nil && len(slots) >= cap(slots)
+}
+
+// selectOverflow 选中的实例已满时 随机选择其他未满的实例
+func (xc *XClient) selectOverflow(ctx context.Context, rpcAddr string) string {
+	xc.mu.Lock()
+	overflow := xc.overflow
+	xc.mu.Unlock()
+	if !overflow || !xc.full(rpcAddr) {
+		return rpcAddr
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return rpcAddr
+	}
+	xc.mu.Lock()
+	xc.r.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+	xc.mu.Unlock()
+	for _, s := range servers {
+		if !xc.full(s) {
+			return s
+		}
+	}
+	return rpcAddr
+}
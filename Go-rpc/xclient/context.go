@@ -0,0 +1,89 @@
+package xclient
+
+import (
+	"context"
+	"strings"
+)
+
+type targetServerKey struct{}
+
+type selectModeKey struct{}
+
+type serviceKey struct{}
+
+// WithTargetServer 本次调用固定发往 rpcAddr 不经过负载均衡 也不会换实例重试
+// 例如只对某个实例执行的管理操作
+func WithTargetServer(ctx context.Context, rpcAddr string) context.Context {
+	return context.WithValue(ctx, targetServerKey{}, rpcAddr)
+}
+
+// TargetServerFromContext 获取ctx中固定的服务地址
+func TargetServerFromContext(ctx context.Context) (string, bool) {
+	rpcAddr, ok := ctx.Value(targetServerKey{}).(string)
+	return rpcAddr, ok
+}
+
+// WithSelectMode 本次调用使用 mode 代替 XClient 的负载均衡模式
+func WithSelectMode(ctx context.Context, mode SelectMode) context.Context {
+	return context.WithValue(ctx, selectModeKey{}, mode)
+}
+
+// SelectModeFromContext 获取ctx中的负载均衡模式
+func SelectModeFromContext(ctx context.Context) (SelectMode, bool) {
+	mode, ok := ctx.Value(selectModeKey{}).(SelectMode)
+	return mode, ok
+}
+
+// withService 记录本次调用的服务名 选择实例时只考虑提供该服务的实例
+func withService(ctx context.Context, serviceMethod string) context.Context {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, serviceKey{}, serviceMethod[:dot])
+}
+
+func serviceFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(serviceKey{}).(string)
+	return name
+}
+
+// servers 返回可以被选中的实例 即 allServers 中不处于 draining 的实例
+// 全部处于 draining 时不做过滤
+func (xc *XClient) servers(ctx context.Context) ([]string, error) {
+	servers, err := xc.allServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := xc.d.(StateDiscovery)
+	if !ok {
+		return servers, nil
+	}
+	active := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if d.GetState(s) != StateDraining {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return servers, nil
+	}
+	return active, nil
+}
+
+// allServers 返回提供本次调用服务 且允许被选中 满足筛选条件的所有实例 包括 draining 的实例
+// Discovery 没有实现 ServiceDiscovery 时返回所有实例
+func (xc *XClient) allServers(ctx context.Context) ([]string, error) {
+	var servers []string
+	var err error
+	d, ok := xc.d.(ServiceDiscovery)
+	if name := serviceFromContext(ctx); ok && name != "" {
+		servers, err = d.GetAllService(name)
+	} else {
+		servers, err = xc.d.GetAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return xc.filterSelected(ctx, xc.filterPermitted(servers)), nil
+}
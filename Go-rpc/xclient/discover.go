@@ -2,19 +2,29 @@ package xclient
 
 import (
 	"errors"
+	"gorpc/registry"
 	"math"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
 
 type SelectMode int
 
+var ErrNoAvailableServers = errors.New("rpc discovery: no available servers")
+
 const (
 	// 随机
 	RandomSelect SelectMode = iota
 	// 轮询
 	RoundRobinSelect
+	// 加权轮询 权重通过 UpdateWeights 或注册中心设置 默认1
+	WeightedRoundRobinSelect
+	// 一致性哈希 key 通过 WithHashKey 设置
+	ConsistentHashSelect
+	// 随机选取两个实例 选择延迟和错误率更低的一个 由 XClient 实现
+	P2CSelect
 )
 
 type Discovery interface {
@@ -26,10 +36,72 @@ type Discovery interface {
 	Get(mode SelectMode) (string, error)
 	// 返回所有实例
 	GetAll() ([]string, error)
+	// 订阅服务列表的变化事件 返回事件通道和取消订阅的函数
+	Watch() (<-chan Event, func())
+}
+
+// MetadataDiscovery 可以提供实例元数据的服务发现 例如 version、zone
+type MetadataDiscovery interface {
+	Discovery
+	// 返回实例的元数据 没有时返回nil
+	GetMetadata(rpcAddr string) map[string]string
+}
+
+// FilterDiscovery 可以按元数据筛选实例的服务发现
+type FilterDiscovery interface {
+	Discovery
+	// 返回元数据满足 selector 的所有实例 例如 version>=1.4,region=eu 语法见 Selector
+	GetFiltered(selector string) ([]string, error)
+}
+
+// 实例状态 与注册中心一致
+const (
+	StateActive   = registry.StateActive
+	StateDraining = registry.StateDraining
+)
+
+// StateDiscovery 可以提供实例状态的服务发现
+// StateDraining 的实例不再被选中 但仍会收到广播 用于滚动发布
+type StateDiscovery interface {
+	Discovery
+	// 返回实例的状态 为空表示 StateActive
+	GetState(rpcAddr string) string
+}
+
+// ServiceDiscovery 区分服务的服务发现 只返回提供该服务的实例
+type ServiceDiscovery interface {
+	Discovery
+	// 在提供该服务的实例中选择
+	GetService(serviceName string, mode SelectMode) (string, error)
+	// 在提供该服务的实例中按一致性哈希选择
+	GetServiceByKey(serviceName, key string) (string, error)
+	// 返回提供该服务的所有实例
+	GetAllService(serviceName string) ([]string, error)
+}
+
+// HashDiscovery 支持一致性哈希的服务发现 用于 ConsistentHashSelect
+type HashDiscovery interface {
+	Discovery
+	// 根据key在哈希环上选择实例
+	GetByKey(key string) (string, error)
+}
+
+// CandidateDiscovery 可以在给定的候选实例中选择的服务发现
+// XClient 先去掉黑名单、白名单之外和不满足筛选条件的实例 再交给它按负载均衡模式选择
+// 这样一致性哈希环和加权轮询只包含可选的实例 其余实例的选择结果不受影响
+type CandidateDiscovery interface {
+	Discovery
+	// 在 candidates 中按负载均衡模式选择 key 用于 ConsistentHashSelect
+	SelectFrom(candidates []string, mode SelectMode, key string) (string, error)
 }
 
 // 实现Discovery接口
-var _ Discovery = (*MultiServersDiscovery)(nil)
+var _ HashDiscovery = (*MultiServersDiscovery)(nil)
+var _ CandidateDiscovery = (*MultiServersDiscovery)(nil)
+var _ MetadataDiscovery = (*MultiServersDiscovery)(nil)
+var _ ServiceDiscovery = (*MultiServersDiscovery)(nil)
+var _ FilterDiscovery = (*MultiServersDiscovery)(nil)
+var _ StateDiscovery = (*MultiServersDiscovery)(nil)
 
 // MultiServersDiscovery 不需要注册中心的手工维护的服务列表
 type MultiServersDiscovery struct {
@@ -41,6 +113,26 @@ type MultiServersDiscovery struct {
 	servers []string
 	// 索引(轮询
 	index int // record the selected position for robin algorithm
+	// 权重 k:v -> 服务地址:权重
+	weights map[string]int
+	// 加权轮询的当前权重
+	current map[string]int
+	// 一致性哈希环 k:v -> 服务名:哈希环 空服务名表示所有实例 服务列表变化时重建
+	// SelectFrom 的哈希环以 candidateRingPrefix+候选实例列表 为key
+	rings map[string]*hashRing
+	// 实例提供的服务 k:v -> 服务地址:服务名列表 没有记录的实例视为提供所有服务
+	services map[string][]string
+	// 元数据 k:v -> 服务地址:元数据
+	metadata map[string]map[string]string
+	// 非 StateActive 的实例状态 k:v -> 服务地址:状态
+	states map[string]string
+	// 服务列表变化的订阅者
+	subMu       sync.Mutex
+	subscribers map[int]func(servers []string)
+	nextSubID   int
+	// Watch 的订阅者 以及上次发出事件时的服务列表
+	watchers map[int]*eventWatcher
+	emitted  map[string]registryServer
 }
 
 // Refresh 手工维护的服务列表 暂时不需要
@@ -51,33 +143,260 @@ func (d *MultiServersDiscovery) Refresh() error {
 // Update 根据入参 更新服务列表
 func (d *MultiServersDiscovery) Update(servers []string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	d.servers = servers
+	d.rings = nil
+	d.pruneCurrent()
+	d.mu.Unlock()
+	d.notify(servers)
 	return nil
 }
 
+// pruneCurrent 删除已下线实例的加权轮询当前权重 服务列表变化后调用 调用时需持有锁
+func (d *MultiServersDiscovery) pruneCurrent() {
+	if len(d.current) == 0 {
+		return
+	}
+	alive := make(map[string]bool, len(d.servers))
+	for _, s := range d.servers {
+		alive[s] = true
+	}
+	for s := range d.current {
+		if !alive[s] {
+			delete(d.current, s)
+		}
+	}
+}
+
+// Subscribe 订阅服务列表的更新 每次 Update 或从注册中心刷新后调用 fn
+// 返回取消订阅的函数
+func (d *MultiServersDiscovery) Subscribe(fn func(servers []string)) (cancel func()) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	if d.subscribers == nil {
+		d.subscribers = make(map[int]func([]string))
+	}
+	id := d.nextSubID
+	d.nextSubID++
+	d.subscribers[id] = fn
+	return func() {
+		d.subMu.Lock()
+		defer d.subMu.Unlock()
+		delete(d.subscribers, id)
+	}
+}
+
+// notify 通知订阅者 调用时不能持有 d.mu
+func (d *MultiServersDiscovery) notify(servers []string) {
+	d.emit()
+	d.subMu.Lock()
+	subscribers := make([]func([]string), 0, len(d.subscribers))
+	for _, fn := range d.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	d.subMu.Unlock()
+	for _, fn := range subscribers {
+		s := make([]string, len(servers))
+		copy(s, servers)
+		fn(s)
+	}
+}
+
+// UpdateServices 更新实例提供的服务 k:v -> 服务地址:服务名列表
+func (d *MultiServersDiscovery) UpdateServices(services map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.services = services
+	d.rings = nil
+}
+
+// serversOf 返回提供该服务的实例 serviceName 为空时返回所有实例 调用时需持有锁
+func (d *MultiServersDiscovery) serversOf(serviceName string) []string {
+	if serviceName == "" || d.services == nil {
+		return d.servers
+	}
+	servers := make([]string, 0, len(d.servers))
+	for _, s := range d.servers {
+		names, ok := d.services[s]
+		if !ok {
+			servers = append(servers, s)
+			continue
+		}
+		for _, name := range names {
+			if name == serviceName {
+				servers = append(servers, s)
+				break
+			}
+		}
+	}
+	return servers
+}
+
+// UpdateWeights 更新服务实例的权重 未设置或不大于0的实例权重为1
+func (d *MultiServersDiscovery) UpdateWeights(weights map[string]int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.weights = weights
+	d.current = nil
+}
+
+// UpdateMetadata 更新服务实例的元数据 k:v -> 服务地址:元数据
+func (d *MultiServersDiscovery) UpdateMetadata(metadata map[string]map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.metadata = metadata
+}
+
+// UpdateStates 更新实例状态 k:v -> 服务地址:状态 StateDraining 的实例不再被选中
+func (d *MultiServersDiscovery) UpdateStates(states map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.states = states
+	d.rings = nil
+}
+
+// GetState 返回实例的状态 为空表示 StateActive
+func (d *MultiServersDiscovery) GetState(rpcAddr string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.states[rpcAddr]
+}
+
+// selectable 去掉 draining 的实例 全部处于 draining 时不做过滤 调用时需持有锁
+func (d *MultiServersDiscovery) selectable(servers []string) []string {
+	if len(d.states) == 0 {
+		return servers
+	}
+	active := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if d.states[s] != StateDraining {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return servers
+	}
+	return active
+}
+
+// GetMetadata 返回实例的元数据
+func (d *MultiServersDiscovery) GetMetadata(rpcAddr string) map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.metadata[rpcAddr]
+}
+
+// weightedRoundRobin 平滑加权轮询
+// 每次选择后 被选中实例的当前权重减去总权重 使流量在一个周期内均匀分布
+func (d *MultiServersDiscovery) weightedRoundRobin(servers []string) string {
+	if d.current == nil {
+		d.current = make(map[string]int, len(d.servers))
+	}
+	total, best := 0, ""
+	for _, s := range servers {
+		w := d.weights[s]
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		d.current[s] += w
+		if best == "" || d.current[s] > d.current[best] {
+			best = s
+		}
+	}
+	d.current[best] -= total
+	return best
+}
+
 // Get 选择负载均衡模式
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
+	return d.GetService("", mode)
+}
+
+// GetService 在提供该服务的实例中 按负载均衡模式选择 不选择 draining 的实例
+func (d *MultiServersDiscovery) GetService(serviceName string, mode SelectMode) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.servers)
+	if mode == ConsistentHashSelect {
+		return "", errors.New("rpc discovery: consistent hash requires a key, use GetByKey")
+	}
+	return d.selectFrom(d.selectable(d.serversOf(serviceName)), mode, "")
+}
+
+// SelectFrom 在候选实例中按负载均衡模式选择 不选择 draining 的实例
+// 轮询索引和加权轮询的当前权重与 GetService 共用
+func (d *MultiServersDiscovery) SelectFrom(candidates []string, mode SelectMode, key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.selectFrom(d.selectable(candidates), mode, key)
+}
+
+// selectFrom 在 servers 中按负载均衡模式选择 调用时需持有锁
+func (d *MultiServersDiscovery) selectFrom(servers []string, mode SelectMode, key string) (string, error) {
+	n := len(servers)
 	if n == 0 {
-		return "", errors.New("rpc discovery: no available servers")
+		return "", ErrNoAvailableServers
 	}
 	switch mode {
 	case RandomSelect:
 		// 选择一个 0～n 内的随机服务
-		return d.servers[d.r.Intn(n)], nil
+		return servers[d.r.Intn(n)], nil
 	case RoundRobinSelect:
 		// 取模确保数组越界
-		s := d.servers[d.index%n]
+		s := servers[d.index%n]
 		d.index = (d.index + 1) % n
 		return s, nil
+	case WeightedRoundRobinSelect:
+		return d.weightedRoundRobin(servers), nil
+	case ConsistentHashSelect:
+		return d.candidateRing(servers).get(key), nil
 	default:
 		return "", errors.New("rpc discovery: not supported select mode")
 	}
 }
 
+// candidateRingPrefix 候选实例哈希环在 rings 中的key前缀 不会与服务名冲突
+const candidateRingPrefix = "\x00"
+
+// maxCandidateRings 缓存的候选实例哈希环数量上限 超出后清空重建
+const maxCandidateRings = 64
+
+// candidateRing 返回候选实例的哈希环 相同的候选列表复用同一个哈希环 调用时需持有锁
+func (d *MultiServersDiscovery) candidateRing(servers []string) *hashRing {
+	key := candidateRingPrefix + strings.Join(servers, ",")
+	if ring, ok := d.rings[key]; ok {
+		return ring
+	}
+	if d.rings == nil || len(d.rings) >= maxCandidateRings {
+		d.rings = make(map[string]*hashRing)
+	}
+	ring := newHashRing(servers, defaultReplicas)
+	d.rings[key] = ring
+	return ring
+}
+
+// GetByKey 一致性哈希 相同key在服务列表不变时返回同一个实例
+func (d *MultiServersDiscovery) GetByKey(key string) (string, error) {
+	return d.GetServiceByKey("", key)
+}
+
+// GetServiceByKey 在提供该服务的实例中按一致性哈希选择
+func (d *MultiServersDiscovery) GetServiceByKey(serviceName, key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ring, ok := d.rings[serviceName]
+	if !ok {
+		ring = newHashRing(d.selectable(d.serversOf(serviceName)), defaultReplicas)
+		if d.rings == nil {
+			d.rings = make(map[string]*hashRing)
+		}
+		d.rings[serviceName] = ring
+	}
+	if len(ring.keys) == 0 {
+		return "", ErrNoAvailableServers
+	}
+	return ring.get(key), nil
+}
+
 // GetAll 返回服务列表
 func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 	d.mu.RLock()
@@ -87,6 +406,16 @@ func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 	return servers, nil
 }
 
+// GetAllService 返回提供该服务的所有实例
+func (d *MultiServersDiscovery) GetAllService(serviceName string) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s := d.serversOf(serviceName)
+	servers := make([]string, len(s))
+	copy(servers, s)
+	return servers, nil
+}
+
 // NewMultiServerDiscovery 初始化一个服务列表实例
 func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
 	d := &MultiServersDiscovery{
@@ -0,0 +1,654 @@
+package xclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorpc/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestMultiServersDiscovery_WeightedRoundRobin(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c"})
+	d.UpdateWeights(map[string]int{"tcp@a": 4, "tcp@b": 2})
+	count := make(map[string]int)
+	var seq []string
+	for i := 0; i < 14; i++ {
+		s, err := d.Get(WeightedRoundRobinSelect)
+		_assert(err == nil, "failed to get server: %v", err)
+		count[s]++
+		seq = append(seq, s)
+	}
+	_assert(count["tcp@a"] == 8 && count["tcp@b"] == 4 && count["tcp@c"] == 2, "unexpected distribution: %v", count)
+	// 平滑 不会连续选中同一个实例太多次
+	_assert(seq[0] == "tcp@a" && seq[1] == "tcp@b" && seq[2] == "tcp@a", "expect smooth sequence, got %v", seq)
+
+	// 下线实例的当前权重被删除
+	_ = d.Update([]string{"tcp@a", "tcp@d"})
+	_, _ = d.Get(WeightedRoundRobinSelect)
+	_assert(len(d.current) == 2 && d.current["tcp@b"] == 0 && d.current["tcp@c"] == 0, "expect departed servers to be pruned, got %v", d.current)
+}
+
+func TestMultiServersDiscovery_ConsistentHash(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c"})
+	picked := make(map[string]string)
+	hit := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		s, err := d.GetByKey(key)
+		_assert(err == nil, "failed to get server: %v", err)
+		s2, _ := d.GetByKey(key)
+		_assert(s == s2, "same key should map to the same server")
+		picked[key] = s
+		hit[s] = true
+	}
+	_assert(len(hit) == 3, "expect keys spread over all servers, got %v", hit)
+
+	// 删除一个实例 其余实例上的key不受影响
+	_ = d.Update([]string{"tcp@a", "tcp@b"})
+	for key, s := range picked {
+		if s == "tcp@c" {
+			continue
+		}
+		s2, _ := d.GetByKey(key)
+		_assert(s == s2, "key %s moved from %s to %s", key, s, s2)
+	}
+}
+
+func TestGoRegistryDiscovery_Services(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a", Services: []string{"Foo"}}, time.Hour)
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b", Services: []string{"Bar", "Foo"}}, time.Hour)
+	// 未上报服务名的实例 视为提供所有服务
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@c"}, time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, 0)
+	all, err := d.GetAll()
+	_assert(err == nil && len(all) == 3, "expect 3 servers, got %v %v", all, err)
+	servers, _ := d.GetAllService("Bar")
+	_assert(fmt.Sprint(servers) == "[tcp@b tcp@c]", "unexpected servers for Bar: %v", servers)
+	servers, _ = d.GetAllService("Foo")
+	_assert(fmt.Sprint(servers) == "[tcp@a tcp@b tcp@c]", "unexpected servers for Foo: %v", servers)
+	for i := 0; i < 10; i++ {
+		s, err := d.GetService("Bar", RandomSelect)
+		_assert(err == nil && s != "tcp@a", "tcp@a does not provide Bar, got %s %v", s, err)
+	}
+}
+
+func TestGoRegistryDiscovery_Metadata(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{
+		Addr:     "tcp@a",
+		Metadata: map[string]string{"version": "v2", "zone": "us-east,1a", "weight": "3"},
+	}, time.Hour)
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b"}, time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, 0)
+	_ = d.Refresh()
+	md := d.GetMetadata("tcp@a")
+	_assert(md["version"] == "v2" && md["zone"] == "us-east,1a", "unexpected metadata: %v", md)
+	_assert(d.GetMetadata("tcp@b") == nil, "expect no metadata for tcp@b")
+	// metadata 中的权重用于加权轮询
+	count := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		s, _ := d.Get(WeightedRoundRobinSelect)
+		count[s]++
+	}
+	_assert(count["tcp@a"] == 6 && count["tcp@b"] == 2, "unexpected distribution: %v", count)
+}
+
+func TestGoRegistryDiscovery_Deregister(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b"}, time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, time.Nanosecond)
+	all, _ := d.GetAll()
+	_assert(len(all) == 2, "expect 2 servers, got %v", all)
+	_assert(registry.Deregister(ts.URL, "tcp@a") == nil, "failed to deregister")
+	all, _ = d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@b]", "expect tcp@a removed immediately, got %v", all)
+}
+
+func TestGoRegistryDiscovery_Lease(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	// 短租约 不续约 很快过期
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a", TTL: 100 * time.Millisecond}, time.Hour)
+	// 短租约 定时续约
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b", TTL: 200 * time.Millisecond}, 20*time.Millisecond)
+	// 使用注册中心的默认租约
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@c"}, time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, time.Nanosecond)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a tcp@b tcp@c]", "unexpected servers: %v", all)
+	time.Sleep(300 * time.Millisecond)
+	all, _ = d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@b tcp@c]", "expect tcp@a expired, got %v", all)
+}
+
+func TestGoRegistryDiscovery_HeaderFallback(t *testing.T) {
+	// 只支持HTTP头接口的注册中心
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/registry" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Gorpc-Servers", "tcp@a,tcp@b")
+	}))
+	defer ts.Close()
+	d := NewGoRegistryDiscovery(ts.URL+"/registry", 0)
+	all, err := d.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a tcp@b]", "unexpected servers: %v %v", all, err)
+}
+
+func TestGoRegistryDiscovery_ErrorStatus(t *testing.T) {
+	// 出错的节点不能清空服务列表 需要切换到下一个节点
+	var status int32 = http.StatusInternalServerError
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer broken.Close()
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	d := NewGoRegistryDiscovery(broken.URL+","+ts.URL, 0)
+	all, err := d.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a]", "expect failover on 5xx, got %v %v", all, err)
+
+	atomic.StoreInt32(&status, http.StatusUnauthorized)
+	_, _, err = fetch([]string{broken.URL}, 0, http.DefaultClient)
+	_assert(err != nil && strings.Contains(err.Error(), "401"), "expect 401 to be an error, got %v", err)
+	_, err = fetchHeader(http.DefaultClient, broken.URL)
+	_assert(err != nil, "expect header api to reject error status")
+}
+
+func TestGoRegistryDiscovery_Failover(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	d := NewGoRegistryDiscovery("http://127.0.0.1:1,"+ts.URL, 0)
+	all, err := d.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a]", "unexpected servers: %v %v", all, err)
+}
+
+func TestGoRegistryDiscovery_BlackholeFailover(t *testing.T) {
+	// 接受连接但从不响应的注册中心节点
+	hole, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = hole.Close() }()
+	go func() {
+		for {
+			conn, err := hole.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a", Metadata: map[string]string{"zone": "a"}}, time.Hour)
+	d := NewGoRegistryDiscovery("http://"+hole.Addr().String()+","+ts.URL, 0)
+	d.SetFetchTimeout(300 * time.Millisecond)
+	d.Update([]string{"tcp@a"})
+	d.mu.Lock()
+	d.metadata = map[string]map[string]string{"tcp@a": {"zone": "a"}}
+	d.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- d.ForceRefresh() }()
+	// 访问注册中心期间 读取服务列表不被阻塞
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_assert(d.GetMetadata("tcp@a")["zone"] == "a", "expect metadata while fetching")
+	_assert(time.Since(start) < 100*time.Millisecond, "expect GetMetadata not to wait for the registry")
+	_assert(<-done == nil, "expect failover to the next registry after the fetch timeout")
+	all, _ := d.MultiServersDiscovery.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a]", "unexpected servers: %v", all)
+}
+
+func TestGoRegistryDiscovery_Watch(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+
+	// 轮询间隔很长 列表变化只能来自推送
+	d := NewGoRegistryDiscovery(ts.URL, time.Hour)
+	updates := make(chan []string, 10)
+	d.Subscribe(func(servers []string) { updates <- servers })
+	_, stop := d.Watch()
+	defer stop()
+	next := func() string {
+		select {
+		case servers := <-updates:
+			return fmt.Sprint(servers)
+		case <-time.After(3 * time.Second):
+			return "timeout"
+		}
+	}
+	_assert(next() == "[tcp@a]", "expect initial snapshot")
+
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b", TTL: 100 * time.Millisecond}, time.Hour)
+	_assert(next() == "[tcp@a tcp@b]", "expect tcp@b added")
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a tcp@b]", "unexpected servers: %v", all)
+	// 租约过期同样会被推送
+	_assert(next() == "[tcp@a]", "expect tcp@b expired")
+	_ = registry.Deregister(ts.URL, "tcp@a")
+	_assert(next() == "[]", "expect tcp@a removed")
+}
+
+func TestGoRegistryDiscovery_LongPoll(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+
+	d := NewGoRegistryDiscovery(ts.URL, time.Hour)
+	updates := make(chan []string, 10)
+	d.Subscribe(func(servers []string) { updates <- servers })
+	_, stop := d.LongPoll()
+	defer stop()
+	next := func() string {
+		select {
+		case servers := <-updates:
+			return fmt.Sprint(servers)
+		case <-time.After(3 * time.Second):
+			return "timeout"
+		}
+	}
+	_assert(next() == "[tcp@a]", "expect initial list")
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b"}, time.Hour)
+	_assert(next() == "[tcp@a tcp@b]", "expect tcp@b added")
+	_ = registry.Deregister(ts.URL, "tcp@a")
+	_assert(next() == "[tcp@b]", "expect tcp@a removed")
+}
+
+func TestGoRegistryDiscovery_Namespace(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	base := ts.URL + "/_gorpc_/registry"
+	registry.Register(base+"/staging", registry.Registration{Addr: "tcp@staging"}, time.Hour)
+	registry.Register(base+"/production", registry.Registration{Addr: "tcp@production"}, time.Hour)
+	registry.Register(base, registry.Registration{Addr: "tcp@default"}, time.Hour)
+
+	for ns, want := range map[string]string{"": "[tcp@default]", "staging": "[tcp@staging]", "production": "[tcp@production]"} {
+		d := NewGoRegistryDiscovery(base, 0)
+		d.SetNamespace(ns)
+		all, err := d.GetAll()
+		_assert(err == nil && fmt.Sprint(all) == want, "namespace %q: expect %s, got %v %v", ns, want, all, err)
+	}
+}
+
+// fakeNacos 模拟 Nacos Open API 中实例注册 心跳 注销与查询接口
+func fakeNacos() *httptest.Server {
+	var mu sync.Mutex
+	hosts := make(map[string]map[string]interface{})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := req.URL.Query()
+		key := q.Get("serviceName") + "/" + q.Get("ip") + ":" + q.Get("port")
+		switch req.Method + " " + req.URL.Path {
+		case "POST /nacos/v1/ns/instance":
+			var md map[string]string
+			_ = json.Unmarshal([]byte(q.Get("metadata")), &md)
+			port, _ := strconv.Atoi(q.Get("port"))
+			weight, _ := strconv.ParseFloat(q.Get("weight"), 64)
+			hosts[key] = map[string]interface{}{
+				"ip": q.Get("ip"), "port": port, "weight": weight,
+				"healthy": true, "enabled": true, "metadata": md,
+			}
+		case "PUT /nacos/v1/ns/instance/beat":
+			var beat struct {
+				IP   string
+				Port int
+			}
+			_ = json.Unmarshal([]byte(q.Get("beat")), &beat)
+			code := 10200
+			if hosts[fmt.Sprintf("%s/%s:%d", q.Get("serviceName"), beat.IP, beat.Port)] == nil {
+				code = 20404
+			}
+			_ = json.NewEncoder(w).Encode(map[string]int{"code": code})
+		case "DELETE /nacos/v1/ns/instance":
+			delete(hosts, key)
+		case "GET /nacos/v1/ns/instance/list":
+			list := make([]interface{}, 0)
+			for k, h := range hosts {
+				if strings.HasPrefix(k, q.Get("serviceName")+"/") {
+					list = append(list, h)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"hosts": list})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestNacosDiscovery(t *testing.T) {
+	ts := fakeNacos()
+	defer ts.Close()
+	cfg := registry.NacosConfig{Server: ts.URL + "/nacos", Service: "foo"}
+	h := registry.RegisterNacos(cfg, registry.Registration{
+		Addr:     "tcp@10.0.0.1:9999",
+		Weight:   3,
+		Services: []string{"Foo"},
+		Metadata: map[string]string{"zone": "a"},
+	}, 20*time.Millisecond)
+	defer h.Stop()
+	_assert(h.Err() == nil, "failed to register: %v", h.Err())
+	registry.RegisterNacos(cfg, registry.Registration{Addr: "tls@10.0.0.2:9443"}, time.Hour).Stop()
+
+	d := NewNacosDiscovery(cfg, time.Nanosecond)
+	all, err := d.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@10.0.0.1:9999 tls@10.0.0.2:9443]", "unexpected servers: %v %v", all, err)
+	_assert(d.GetMetadata("tcp@10.0.0.1:9999")["zone"] == "a", "expect metadata from nacos")
+	servers, _ := d.GetAllService("Foo")
+	_assert(fmt.Sprint(servers) == "[tcp@10.0.0.1:9999 tls@10.0.0.2:9443]", "unexpected servers for Foo: %v", servers)
+	servers, _ = d.GetAllService("Bar")
+	_assert(fmt.Sprint(servers) == "[tls@10.0.0.2:9443]", "unexpected servers for Bar: %v", servers)
+
+	// 心跳发现实例丢失后重新注册
+	_ = registry.DeregisterNacos(cfg, "tcp@10.0.0.1:9999")
+	all, _ = d.GetAll()
+	_assert(len(all) == 1, "expect one server after deregister, got %v", all)
+	time.Sleep(100 * time.Millisecond)
+	all, _ = d.GetAll()
+	_assert(len(all) == 2, "expect the instance registered again by heartbeat, got %v", all)
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	slice := func(name string, ready bool, ips ...string) string {
+		var eps []string
+		for _, ip := range ips {
+			eps = append(eps, fmt.Sprintf(`{"addresses":[%q],"conditions":{"ready":%v},"zone":"z1"}`, ip, ready))
+		}
+		return fmt.Sprintf(`{"metadata":{"name":%q},"addressType":"IPv4","endpoints":[%s],"ports":[{"name":"metrics","port":9100},{"name":"rpc","port":9999}]}`,
+			name, strings.Join(eps, ","))
+	}
+	events := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_assert(req.Header.Get("Authorization") == "Bearer token", "expect bearer token")
+		_assert(req.URL.Path == "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices", "unexpected path %s", req.URL.Path)
+		_assert(req.URL.Query().Get("labelSelector") == "kubernetes.io/service-name=foo", "unexpected selector")
+		if req.URL.Query().Get("watch") != "true" {
+			_, _ = fmt.Fprintf(w, `{"metadata":{"resourceVersion":"1"},"items":[%s,%s]}`,
+				slice("foo-a", true, "10.0.0.1", "10.0.0.2"), slice("foo-b", false, "10.0.0.3"))
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case e := <-events:
+				_, _ = fmt.Fprintln(w, e)
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	d, err := NewKubernetesDiscovery(KubernetesConfig{
+		APIServer: ts.URL, Token: "token", HTTPClient: ts.Client(),
+		Namespace: "prod", Service: "foo", Port: "rpc",
+	}, time.Hour)
+	_assert(err == nil, "failed to create discovery: %v", err)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@10.0.0.1:9999 tcp@10.0.0.2:9999]", "unexpected servers: %v", all)
+	_assert(d.GetMetadata("tcp@10.0.0.1:9999")["zone"] == "z1", "expect zone metadata")
+
+	updates := make(chan []string, 10)
+	d.Subscribe(func(servers []string) { updates <- servers })
+	_, stop := d.Watch()
+	defer stop()
+	events <- fmt.Sprintf(`{"type":"MODIFIED","object":%s}`, slice("foo-b", true, "10.0.0.3"))
+	events <- fmt.Sprintf(`{"type":"DELETED","object":%s}`, slice("foo-a", true))
+	deadline := time.After(3 * time.Second)
+	for fmt.Sprint(all) != "[tcp@10.0.0.3:9999]" {
+		select {
+		case all = <-updates:
+		case <-deadline:
+			_assert(false, "expect watch events applied, got %v", all)
+		}
+	}
+}
+
+type fakeResolver struct {
+	hosts map[string][]string
+	srv   []*net.SRV
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	return r.hosts[host], nil
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	return name, r.srv, nil
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{"foo.svc": {"10.0.0.2", "10.0.0.1", "fd00::1"}}}
+	d := NewDNSDiscovery("foo.svc:9999", time.Hour)
+	d.SetResolver(r)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@10.0.0.1:9999 tcp@10.0.0.2:9999 tcp@[fd00::1]:9999]", "unexpected servers: %v", all)
+
+	r.srv = []*net.SRV{
+		{Target: "a.example.com.", Port: 7001, Priority: 10, Weight: 3},
+		{Target: "b.example.com.", Port: 7002, Priority: 10, Weight: 1},
+		{Target: "backup.example.com.", Port: 7003, Priority: 20, Weight: 1},
+	}
+	d = NewDNSDiscovery("tls@_rpc._tcp.example.com", time.Hour)
+	d.SetResolver(r)
+	all, _ = d.GetAll()
+	_assert(fmt.Sprint(all) == "[tls@a.example.com:7001 tls@b.example.com:7002]", "unexpected servers: %v", all)
+	_assert(d.weights["tls@a.example.com:7001"] == 3, "expect weight from SRV record")
+}
+
+func TestMulticastDiscovery(t *testing.T) {
+	d, err := NewMulticastDiscovery("127.0.0.1:0")
+	_assert(err == nil, "failed to listen: %v", err)
+	defer func() { _ = d.Close() }()
+	group := d.Addr().String()
+
+	updates := make(chan []string, 10)
+	d.Subscribe(func(servers []string) { updates <- servers })
+	wait := func(expect string) {
+		deadline := time.After(3 * time.Second)
+		for {
+			all, _ := d.GetAll()
+			if fmt.Sprint(all) == expect {
+				return
+			}
+			select {
+			case <-updates:
+			case <-deadline:
+				_assert(false, "expect %s, got %v", expect, all)
+			}
+		}
+	}
+
+	a := registry.Announce(group, registry.Registration{Addr: "tcp@10.0.0.1:9999", Services: []string{"Foo"}}, time.Hour)
+	b := registry.Announce(group, registry.Registration{Addr: "tcp@10.0.0.2:9999", TTL: 500 * time.Millisecond}, 100*time.Millisecond)
+	wait("[tcp@10.0.0.1:9999 tcp@10.0.0.2:9999]")
+	foo, _ := d.GetAllService("Foo")
+	_assert(fmt.Sprint(foo) == "[tcp@10.0.0.1:9999 tcp@10.0.0.2:9999]", "instances without services provide all, got %v", foo)
+
+	// 停止公告后 超过 TTL 被移除
+	b.Stop()
+	wait("[tcp@10.0.0.1:9999]")
+
+	a.Stop()
+	_assert(registry.Leave(group, "tcp@10.0.0.1:9999") == nil, "failed to leave")
+	wait("[]")
+}
+
+func TestFederatedDiscovery(t *testing.T) {
+	east := httptest.NewServer(registry.New(0))
+	defer east.Close()
+	west := httptest.NewServer(registry.New(0))
+	registry.Register(east.URL, registry.Registration{Addr: "tcp@a", Services: []string{"Foo"}}, time.Hour)
+	registry.Register(west.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	registry.Register(west.URL, registry.Registration{Addr: "tcp@b", Weight: 3}, time.Hour)
+	sources := func() []Discovery {
+		return []Discovery{NewGoRegistryDiscovery(east.URL, time.Nanosecond), NewGoRegistryDiscovery(west.URL, time.Nanosecond)}
+	}
+
+	failover := NewFederatedDiscovery(FederatedFailover, time.Nanosecond, sources()...)
+	all, err := failover.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a]", "expect only the first registry, got %v %v", all, err)
+
+	merge := NewFederatedDiscovery(FederatedMerge, time.Nanosecond, sources()...)
+	all, _ = merge.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a tcp@b]", "expect merged servers, got %v", all)
+	// 同一地址以靠前的注册中心为准
+	bar, _ := merge.GetAllService("Bar")
+	_assert(fmt.Sprint(bar) == "[tcp@b]", "unexpected servers for Bar: %v", bar)
+	_assert(merge.snapshot()[1].Weight == 3, "expect weight kept")
+
+	// 第一个注册中心不可用时回退
+	east.Close()
+	all, err = failover.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a tcp@b]", "expect fallback to second registry, got %v %v", all, err)
+	west.Close()
+	_, err = merge.GetAll()
+	_assert(err != nil, "expect error when all registries are down")
+}
+
+func TestMultiServersDiscovery_Watch(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+	events, cancel := d.Watch()
+	next := func() string {
+		select {
+		case e := <-events:
+			return e.Type.String() + " " + e.Addr
+		case <-time.After(time.Second):
+			return "timeout"
+		}
+	}
+	// 首先收到当前的实例
+	_assert(next() == "add tcp@a" && next() == "add tcp@b", "expect initial add events")
+	_ = d.Update([]string{"tcp@b", "tcp@c"})
+	_assert(next() == "remove tcp@a", "expect remove event")
+	_assert(next() == "add tcp@c", "expect add event")
+	cancel()
+	_, ok := <-events
+	_assert(!ok, "expect channel closed after cancel")
+}
+
+func TestGoRegistryDiscovery_WatchEvents(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	d := NewGoRegistryDiscovery(ts.URL, time.Nanosecond)
+	_ = d.Refresh()
+	events, cancel := d.MultiServersDiscovery.Watch()
+	defer cancel()
+	_assert((<-events).Type == EventAdd, "expect initial add event")
+
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a", Weight: 5}, time.Hour)
+	_ = d.Refresh()
+	select {
+	case e := <-events:
+		_assert(e.Type == EventUpdate && e.Addr == "tcp@a" && e.Weight == 5, "unexpected event: %+v", e)
+	case <-time.After(time.Second):
+		_assert(false, "expect update event")
+	}
+}
+
+func TestGoRegistryDiscovery_CacheFile(t *testing.T) {
+	cache := t.TempDir() + "/servers.json"
+	ts := httptest.NewServer(registry.New(0))
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a", Weight: 2}, time.Hour)
+	d := NewGoRegistryDiscovery(ts.URL, 0)
+	d.SetCacheFile(cache)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a]", "unexpected servers: %v", all)
+	ts.Close()
+
+	// 注册中心不可用时 重启的客户端使用缓存
+	d = NewGoRegistryDiscovery(ts.URL, 0)
+	d.SetCacheFile(cache)
+	all, err := d.GetAll()
+	_assert(err == nil && fmt.Sprint(all) == "[tcp@a]", "expect cached servers, got %v %v", all, err)
+	_assert(d.weights["tcp@a"] == 2, "expect cached weight")
+
+	// 没有缓存时返回错误
+	d = NewGoRegistryDiscovery(ts.URL, 0)
+	d.SetCacheFile(t.TempDir() + "/missing.json")
+	_, err = d.GetAll()
+	_assert(err != nil, "expect error without cache")
+}
+
+func TestGoRegistryDiscovery_ForceRefresh(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	d := NewGoRegistryDiscovery(ts.URL, time.Hour)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a]", "unexpected servers: %v", all)
+
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b"}, time.Hour)
+	all, _ = d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a]", "expect cached servers before expiry, got %v", all)
+	_assert(d.ForceRefresh() == nil, "failed to force refresh")
+	all, _ = d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a tcp@b]", "expect refreshed servers, got %v", all)
+
+	// 后台定时获取 不依赖 Get
+	d.SetRefreshInterval(20 * time.Millisecond)
+	events, stop := d.AutoRefresh()
+	defer stop()
+	_assert((<-events).Addr == "tcp@a" && (<-events).Addr == "tcp@b", "expect initial events")
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@c"}, time.Hour)
+	select {
+	case e := <-events:
+		_assert(e.Type == EventAdd && e.Addr == "tcp@c", "unexpected event: %+v", e)
+	case <-time.After(3 * time.Second):
+		_assert(false, "expect background refresh")
+	}
+}
+
+func TestGoRegistryDiscovery_Draining(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	a := registry.Register(ts.URL, registry.Registration{Addr: "tcp@a"}, time.Hour)
+	defer a.Stop()
+	registry.Register(ts.URL, registry.Registration{Addr: "tcp@b"}, time.Hour)
+	_assert(a.SetState(registry.StateDraining) == nil, "failed to set state")
+
+	d := NewGoRegistryDiscovery(ts.URL, time.Nanosecond)
+	all, _ := d.GetAll()
+	_assert(fmt.Sprint(all) == "[tcp@a tcp@b]", "expect draining instance listed, got %v", all)
+	_assert(d.GetState("tcp@a") == StateDraining, "expect tcp@a draining")
+	for i := 0; i < 4; i++ {
+		s, _ := d.Get(RoundRobinSelect)
+		_assert(s == "tcp@b", "expect draining instance not selected, got %s", s)
+	}
+	// 只支持HTTP头接口的客户端
+	items, err := fetchHeader(http.DefaultClient, ts.URL)
+	_assert(err == nil && len(items) == 2 && items[0].State == StateDraining, "unexpected header items: %+v %v", items, err)
+
+	_assert(a.SetState(registry.StateActive) == nil, "failed to set state")
+	_ = d.Refresh()
+	_assert(d.GetState("tcp@a") == "", "expect tcp@a active again")
+}
@@ -0,0 +1,85 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver DNS解析 *net.Resolver 实现了该接口
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNS 查询超时时间
+const dnsLookupTimeout = time.Second * 5
+
+// DNSDiscovery 通过 DNS 解析服务实例 无需注册中心 适用于 headless Service 或 SRV 记录
+type DNSDiscovery struct {
+	*pollingDiscovery
+	protocol string
+	target   string
+	resolver Resolver
+}
+
+// NewDNSDiscovery 初始化 interval 为重新解析的间隔 默认10s
+// target 可以带 protocol@ 前缀 默认 tcp
+//   - host:port 解析 A/AAAA 记录 例如 foo.default.svc.cluster.local:9999
+//   - _service._proto.name 解析 SRV 记录 端口与权重取自记录 只使用优先级最高的一组
+func NewDNSDiscovery(target string, interval time.Duration) *DNSDiscovery {
+	d := &DNSDiscovery{protocol: "tcp", target: target, resolver: net.DefaultResolver}
+	if parts := strings.SplitN(target, "@", 2); len(parts) == 2 {
+		d.protocol, d.target = parts[0], parts[1]
+	}
+	d.pollingDiscovery = newPollingDiscovery(interval, d.fetch)
+	return d
+}
+
+// SetResolver 替换DNS解析器 例如指定DNS服务器的 *net.Resolver
+func (d *DNSDiscovery) SetResolver(r Resolver) {
+	d.fetchMu.Lock()
+	defer d.fetchMu.Unlock()
+	d.resolver = r
+	d.lastUpdate = time.Time{}
+}
+
+func (d *DNSDiscovery) fetch() ([]registryServer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	var items []registryServer
+	if strings.HasPrefix(d.target, "_") {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.target)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			// 数值越小优先级越高
+			if srv.Priority != records[0].Priority {
+				continue
+			}
+			host := strings.TrimSuffix(srv.Target, ".")
+			items = append(items, registryServer{
+				Addr:   d.protocol + "@" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight: int(srv.Weight),
+			})
+		}
+	} else {
+		host, port, err := net.SplitHostPort(d.target)
+		if err != nil {
+			return nil, fmt.Errorf("rpc discovery: invalid dns target %s: %v", d.target, err)
+		}
+		addrs, err := d.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range addrs {
+			items = append(items, registryServer{Addr: d.protocol + "@" + net.JoinHostPort(ip, port)})
+		}
+	}
+	sortServers(items)
+	return items, nil
+}
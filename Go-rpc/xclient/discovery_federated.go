@@ -0,0 +1,88 @@
+package xclient
+
+import (
+	"errors"
+	"gorpc"
+	"time"
+)
+
+// FederatedMode 多个注册中心的组合方式
+type FederatedMode int
+
+const (
+	// FederatedFailover 按顺序使用第一个可用且有实例的注册中心 前面的不可用时回退到后面的
+	FederatedFailover FederatedMode = iota
+	// FederatedMerge 合并所有可用注册中心的实例 同一地址以靠前的注册中心为准
+	// 适用于在注册中心之间逐步迁移
+	FederatedMerge
+)
+
+// FederatedDiscovery 组合多个注册中心的服务发现 例如不同区域的注册中心
+// 部分注册中心不可用时 继续使用其余注册中心的实例 全部不可用时返回错误
+type FederatedDiscovery struct {
+	*pollingDiscovery
+	mode    FederatedMode
+	sources []Discovery
+}
+
+// NewFederatedDiscovery 初始化 sources 按优先级从高到低排列
+// 每 timeout 重新组合一次 各注册中心的过期时间由其自身决定
+func NewFederatedDiscovery(mode FederatedMode, timeout time.Duration, sources ...Discovery) *FederatedDiscovery {
+	d := &FederatedDiscovery{mode: mode, sources: sources}
+	d.pollingDiscovery = newPollingDiscovery(timeout, d.fetch)
+	return d
+}
+
+// snapshotDiscovery 可以返回实例权重 服务名 元数据的服务发现
+// 内嵌 MultiServersDiscovery 的服务发现都实现了该接口
+type snapshotDiscovery interface {
+	snapshot() []registryServer
+}
+
+func (d *FederatedDiscovery) fetch() ([]registryServer, error) {
+	err := errors.New("rpc discovery: no registry configured")
+	var items []registryServer
+	available := false
+	seen := make(map[string]bool)
+	for _, src := range d.sources {
+		got, e := d.fetchSource(src)
+		if e != nil {
+			gorpc.Logf(gorpc.LevelWarn, "rpc discovery: federated source err: %v", e)
+			err = e
+			continue
+		}
+		available = true
+		for _, item := range got {
+			if !seen[item.Addr] {
+				seen[item.Addr] = true
+				items = append(items, item)
+			}
+		}
+		if d.mode == FederatedFailover && len(items) > 0 {
+			break
+		}
+	}
+	if !available {
+		return nil, err
+	}
+	sortServers(items)
+	return items, nil
+}
+
+func (d *FederatedDiscovery) fetchSource(src Discovery) ([]registryServer, error) {
+	if err := src.Refresh(); err != nil {
+		return nil, err
+	}
+	if s, ok := src.(snapshotDiscovery); ok {
+		return s.snapshot(), nil
+	}
+	servers, err := src.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]registryServer, 0, len(servers))
+	for _, addr := range servers {
+		items = append(items, registryServer{Addr: addr})
+	}
+	return items, nil
+}
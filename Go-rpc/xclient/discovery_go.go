@@ -1,9 +1,15 @@
 package xclient
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"gorpc"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,18 +17,35 @@ import (
 // 嵌套MultiServersDiscovery，提高复用率
 type GoRegistryDiscovery struct {
 	*MultiServersDiscovery
-	// 注册中心地址
+	// 注册中心地址 集群模式下以,分隔多个节点
 	registry string
+	// 上次成功访问的节点 优先使用
+	active int
+	// 是否已订阅注册中心的变化推送
+	watching bool
+	// 命名空间 为空时使用默认命名空间
+	namespace string
+	// 服务列表的本地缓存文件 以及上次写入的内容
+	cacheFile string
+	cached    []registryServer
 	// 注册中心过期时间
 	timeout time.Duration
+	// 访问注册中心的超时 超时后切换到下一个节点
+	fetchTimeout time.Duration
+	// 保证同一时间只有一个请求访问注册中心 访问期间不持有 mu
+	fetchMu sync.Mutex
 	// 最后从注册中心更新服务列表的时间
 	// 默认10s过期
 	lastUpdate time.Time
 }
 
-const defaultUpdateTimeout = time.Second * 10
+const (
+	defaultUpdateTimeout = time.Second * 10
+	defaultFetchTimeout  = time.Second * 5
+)
 
 // NewGoRegistryDiscovery 初始化
+// registerAddr 可以是以,分隔的多个注册中心节点 访问失败时自动切换到下一个
 func NewGoRegistryDiscovery(registerAddr string, timeout time.Duration) *GoRegistryDiscovery {
 	if timeout == 0 {
 		timeout = defaultUpdateTimeout
@@ -31,6 +54,7 @@ func NewGoRegistryDiscovery(registerAddr string, timeout time.Duration) *GoRegis
 		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
 		registry:              registerAddr,
 		timeout:               timeout,
+		fetchTimeout:          defaultFetchTimeout,
 	}
 	return d
 }
@@ -38,37 +62,270 @@ func NewGoRegistryDiscovery(registerAddr string, timeout time.Duration) *GoRegis
 // Update 根据入参更新 服务列表
 func (d *GoRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	d.servers = servers
+	d.rings = nil
+	d.pruneCurrent()
 	d.lastUpdate = time.Now()
+	d.mu.Unlock()
+	d.notify(servers)
 	return nil
 }
 
 // Refresh 超时 自动更新服务列表
 func (d *GoRegistryDiscovery) Refresh() error {
+	return d.refreshAndNotify(false)
+}
+
+// ForceRefresh 立即从注册中心获取服务列表 不论是否过期 例如已知拓扑变化后调用
+func (d *GoRegistryDiscovery) ForceRefresh() error {
+	return d.refreshAndNotify(true)
+}
+
+// SetRefreshInterval 设置服务列表的过期时间 过期后下一次选择实例时重新获取
+// 调用 AutoRefresh 时为后台获取的周期 不大于0时使用默认值10s
+func (d *GoRegistryDiscovery) SetRefreshInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultUpdateTimeout
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeout = interval
+}
+
+// SetFetchTimeout 设置访问单个注册中心节点的超时 超时后尝试下一个节点 不大于0时使用默认值5s
+func (d *GoRegistryDiscovery) SetFetchTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fetchTimeout = timeout
+}
+
+// AutoRefresh 在后台每个刷新间隔获取一次服务列表 选择实例时不再等待注册中心
+// 返回事件通道和停止的函数
+func (d *GoRegistryDiscovery) AutoRefresh() (<-chan Event, func()) {
+	events, unwatch := d.MultiServersDiscovery.Watch()
+	done := make(chan struct{})
+	go func() {
+		for {
+			_ = d.ForceRefresh()
+			d.mu.RLock()
+			interval := d.timeout
+			d.mu.RUnlock()
+			select {
+			case <-time.After(interval):
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			close(done)
+			unwatch()
+		})
+	}
+}
+
+func (d *GoRegistryDiscovery) refreshAndNotify(force bool) error {
+	servers, err := d.refresh(force)
+	if servers != nil {
+		d.notify(servers)
+	}
+	return err
+}
+
+// refresh 从注册中心获取服务列表 没有过期且不强制时返回nil
+// 访问注册中心时不持有 mu 注册中心无响应不会阻塞读取服务列表
+func (d *GoRegistryDiscovery) refresh(force bool) ([]string, error) {
+	d.fetchMu.Lock()
+	defer d.fetchMu.Unlock()
+	d.mu.RLock()
+	// 超时判断 订阅了变化推送时不需要轮询
+	fresh := !force && (d.watching || d.lastUpdate.Add(d.timeout).After(time.Now()))
+	registries, active, timeout := d.registries(), d.active, d.fetchTimeout
+	d.mu.RUnlock()
+	if fresh {
+		return nil, nil
+	}
+	gorpc.Logf(gorpc.LevelDebug, "rpc registry: refresh servers from registry %s", d.registry)
+	items, n, err := fetch(registries, active, &http.Client{Timeout: timeout})
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	// 超时判断
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
-		return nil
+	if err != nil {
+		gorpc.Logf(gorpc.LevelWarn, "rpc registry refresh err: %v", err)
+		// 还没有获取过服务列表 (例如刚启动) 时使用缓存 稍后再访问注册中心
+		if len(d.servers) == 0 && d.cacheFile != "" {
+			if cached, cerr := d.loadCache(); cerr == nil && len(cached) > 0 {
+				gorpc.Logf(gorpc.LevelInfo, "rpc registry: use cached servers from %s", d.cacheFile)
+				d.replace(cached)
+				d.lastUpdate = time.Now()
+				return d.servers, nil
+			}
+		}
+		return nil, err
+	}
+	d.active = n
+	d.apply(items)
+	return d.servers, nil
+}
+
+// apply 用注册中心返回的实例替换服务列表 调用时需持有锁
+func (d *GoRegistryDiscovery) apply(items []registryServer) {
+	d.replace(items)
+	d.lastUpdate = time.Now()
+	d.saveCache(items)
+}
+
+// registryServer 注册中心返回的一个服务实例
+type registryServer struct {
+	Addr     string            `json:"addr"`
+	Weight   int               `json:"weight"`
+	Services []string          `json:"services"`
+	Metadata map[string]string `json:"metadata"`
+	// 实例状态 StateDraining 的实例不再被选中 但仍会收到广播
+	State string `json:"state"`
+}
+
+// fetch 从上次成功的节点 active 开始依次尝试各注册中心 返回成功的节点序号
+func fetch(registries []string, active int, client *http.Client) ([]registryServer, int, error) {
+	err := errors.New("rpc registry: no registry address")
+	for i := range registries {
+		n := (active + i) % len(registries)
+		// 优先使用JSON接口 注册中心不支持 (404) 时退回到HTTP头
+		var items []registryServer
+		items, err = fetchJSON(client, registries[n])
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusNotFound {
+			items, err = fetchHeader(client, registries[n])
+		}
+		if err == nil {
+			return items, n, nil
+		}
+	}
+	return nil, active, err
+}
+
+// SetNamespace 只发现该命名空间下的实例 例如 staging production
+// 服务端以 http://host/_gorpc_/registry/{namespace} 作为注册中心地址注册到对应命名空间
+func (d *GoRegistryDiscovery) SetNamespace(namespace string) {
+	d.mu.Lock()
+	d.namespace = strings.Trim(namespace, "/")
+	d.lastUpdate = time.Time{}
+	d.mu.Unlock()
+}
+
+// registries 解析以,分隔的注册中心地址 并附加命名空间 调用时需持有锁
+func (d *GoRegistryDiscovery) registries() []string {
+	var registries []string
+	for _, addr := range strings.Split(d.registry, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			if d.namespace != "" {
+				addr = strings.TrimSuffix(addr, "/") + "/" + d.namespace
+			}
+			registries = append(registries, addr)
+		}
+	}
+	return registries
+}
+
+// statusError 注册中心返回了非200的状态码
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "rpc registry: unexpected status " + e.status
+}
+
+// fetchJSON 通过 /v1/servers 获取服务列表
+func fetchJSON(client *http.Client, registry string) ([]registryServer, error) {
+	items, _, err := fetchJSONBlocking(context.Background(), client, registry, "")
+	return items, err
+}
+
+// fetchJSONBlocking 通过 /v1/servers 获取服务列表及变化序号
+// query 不为空时作为阻塞查询参数 例如 index=3&wait=30s client 的超时需要大于等待时间
+func fetchJSONBlocking(ctx context.Context, client *http.Client, registry, query string) ([]registryServer, uint64, error) {
+	url := strings.TrimSuffix(registry, "/") + "/v1/servers"
+	if query != "" {
+		url += "?" + query
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	var body struct {
+		Servers []registryServer `json:"servers"`
+		Index   uint64           `json:"index"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, 0, err
+	}
+	items := body.Servers[:0]
+	for _, item := range body.Servers {
+		if item.Addr != "" {
+			items = append(items, item)
+		}
 	}
-	log.Println("rpc registry: refresh servers from registry", d.registry)
+	return items, body.Index, nil
+}
+
+// fetchHeader 通过 X-Gorpc-Servers 等HTTP头获取服务列表
+func fetchHeader(client *http.Client, registry string) ([]registryServer, error) {
 	// 发送Get请求获取服务列表
-	resp, err := http.Get(d.registry)
+	resp, err := client.Get(registry)
 	if err != nil {
-		log.Println("rpc registry refresh err:", err)
-		return err
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	// 出错的响应中没有服务列表 不能当作空列表
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
 	}
 	// 返回可用服务列表
 	servers := strings.Split(resp.Header.Get("X-Gorpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+	// 与服务列表一一对应的权重
+	weights := strings.Split(resp.Header.Get("X-Gorpc-Weights"), ",")
+	// 与服务列表一一对应的服务名 同一实例以;分隔
+	services := strings.Split(resp.Header.Get("X-Gorpc-Services"), ",")
+	// 与服务列表一一对应的元数据 k=v&k=v 格式
+	metadata := strings.Split(resp.Header.Get("X-Gorpc-Metadata"), ",")
+	// 与服务列表一一对应的实例状态
+	states := strings.Split(resp.Header.Get("X-Gorpc-States"), ",")
+	items := make([]registryServer, 0, len(servers))
+	for i, server := range servers {
+		item := registryServer{Addr: strings.TrimSpace(server)}
+		if item.Addr == "" {
+			continue
+		}
+		if i < len(weights) {
+			item.Weight, _ = strconv.Atoi(weights[i])
 		}
+		if i < len(services) {
+			item.Services = splitServices(services[i])
+		}
+		if i < len(metadata) && metadata[i] != "" {
+			values, _ := url.ParseQuery(metadata[i])
+			item.Metadata = make(map[string]string, len(values))
+			for k := range values {
+				item.Metadata[k] = values.Get(k)
+			}
+		}
+		if i < len(states) {
+			item.State = states[i]
+		}
+		items = append(items, item)
 	}
-	d.lastUpdate = time.Now()
-	return nil
+	return items, nil
 }
 
 // Get 根据负载均衡模式 返回一个可用服务实例
@@ -80,6 +337,14 @@ func (d *GoRegistryDiscovery) Get(mode SelectMode) (string, error) {
 	return d.MultiServersDiscovery.Get(mode)
 }
 
+// GetByKey 根据key在哈希环上选择实例
+func (d *GoRegistryDiscovery) GetByKey(key string) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetByKey(key)
+}
+
 // GetAll 返回全部服务实例
 func (d *GoRegistryDiscovery) GetAll() ([]string, error) {
 	// 先调用 Refresh 确保服务列表没有过期
@@ -88,3 +353,38 @@ func (d *GoRegistryDiscovery) GetAll() ([]string, error) {
 	}
 	return d.MultiServersDiscovery.GetAll()
 }
+
+// GetService 在提供该服务的实例中选择
+func (d *GoRegistryDiscovery) GetService(serviceName string, mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetService(serviceName, mode)
+}
+
+// GetServiceByKey 在提供该服务的实例中按一致性哈希选择
+func (d *GoRegistryDiscovery) GetServiceByKey(serviceName, key string) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetServiceByKey(serviceName, key)
+}
+
+// GetAllService 返回提供该服务的所有实例
+func (d *GoRegistryDiscovery) GetAllService(serviceName string) ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllService(serviceName)
+}
+
+// splitServices 解析以;分隔的服务名
+func splitServices(v string) []string {
+	var names []string
+	for _, name := range strings.Split(v, ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
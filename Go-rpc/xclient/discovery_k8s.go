@@ -0,0 +1,299 @@
+package xclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorpc"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 集群内 ServiceAccount 凭据的位置
+const k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesConfig 定位 Service 及访问 API Server 的配置
+type KubernetesConfig struct {
+	// API Server 地址 例如 https://10.96.0.1:443 为空时使用集群内配置
+	APIServer string
+	// Bearer Token 为空时使用集群内 ServiceAccount 的令牌
+	Token string
+	// 访问 API Server 的客户端 为空时使用集群内CA证书
+	HTTPClient *http.Client
+	// Service 所在命名空间 为空时使用当前Pod的命名空间
+	Namespace string
+	// Service 名称
+	Service string
+	// 端口名 为空时使用第一个端口
+	Port string
+	// protocol@addr 中的 protocol 默认 tcp
+	Protocol string
+}
+
+// KubernetesDiscovery 跟踪 Service 的 EndpointSlice 只返回 ready 的地址
+// 集群内无需部署 GoRegistry
+type KubernetesDiscovery struct {
+	*pollingDiscovery
+	cfg KubernetesConfig
+
+	// Watch 时维护的 EndpointSlice 名称 -> 对象
+	slicesMu sync.Mutex
+	slices   map[string]*endpointSlice
+}
+
+// NewKubernetesDiscovery 初始化 timeout 为服务列表的过期时间 默认10s
+// 调用 Watch 通过 API Server 的 watch 接口实时跟踪变化
+func NewKubernetesDiscovery(cfg KubernetesConfig, timeout time.Duration) (*KubernetesDiscovery, error) {
+	if err := cfg.inCluster(); err != nil {
+		return nil, err
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = "tcp"
+	}
+	d := &KubernetesDiscovery{cfg: cfg}
+	d.pollingDiscovery = newPollingDiscovery(timeout, d.fetch)
+	return d, nil
+}
+
+// inCluster 用集群内配置补全未设置的字段
+func (c *KubernetesConfig) inCluster() error {
+	if c.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return errors.New("rpc discovery: not running in a kubernetes cluster, APIServer required")
+		}
+		c.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if c.Token == "" {
+		if token, err := os.ReadFile(k8sServiceAccountDir + "/token"); err == nil {
+			c.Token = strings.TrimSpace(string(token))
+		}
+	}
+	if c.Namespace == "" {
+		if ns, err := os.ReadFile(k8sServiceAccountDir + "/namespace"); err == nil {
+			c.Namespace = strings.TrimSpace(string(ns))
+		} else {
+			c.Namespace = "default"
+		}
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+		if ca, err := os.ReadFile(k8sServiceAccountDir + "/ca.crt"); err == nil {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			c.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+		}
+	}
+	if c.Service == "" {
+		return errors.New("rpc discovery: kubernetes service name required")
+	}
+	return nil
+}
+
+// endpointSlice discovery.k8s.io/v1 EndpointSlice 中用到的字段
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	AddressType string `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+		NodeName string `json:"nodeName"`
+		Zone     string `json:"zone"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int   `json:"port"`
+	} `json:"ports"`
+}
+
+func (d *KubernetesDiscovery) request(ctx context.Context, query url.Values) (*http.Response, error) {
+	query.Set("labelSelector", "kubernetes.io/service-name="+d.cfg.Service)
+	u := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?%s",
+		strings.TrimSuffix(d.cfg.APIServer, "/"), url.PathEscape(d.cfg.Namespace), query.Encode())
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if d.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.cfg.Token)
+	}
+	resp, err := d.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("rpc discovery: kubernetes api: %s %s", resp.Status, body)
+	}
+	return resp, nil
+}
+
+// list 列出 Service 的所有 EndpointSlice 及其 resourceVersion
+func (d *KubernetesDiscovery) list(ctx context.Context) ([]*endpointSlice, string, error) {
+	resp, err := d.request(ctx, url.Values{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var body struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []*endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, "", err
+	}
+	return body.Items, body.Metadata.ResourceVersion, nil
+}
+
+func (d *KubernetesDiscovery) fetch() ([]registryServer, error) {
+	slices, _, err := d.list(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return d.instances(slices), nil
+}
+
+// instances 将 EndpointSlice 转换为服务实例 同一地址只保留一个
+func (d *KubernetesDiscovery) instances(slices []*endpointSlice) []registryServer {
+	seen := make(map[string]bool)
+	var items []registryServer
+	for _, s := range slices {
+		port := -1
+		for _, p := range s.Ports {
+			if p.Port != nil && (d.cfg.Port == "" || p.Name == d.cfg.Port) {
+				port = *p.Port
+				break
+			}
+		}
+		if port < 0 || s.AddressType == "FQDN" {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			// ready 为空视为 ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, ip := range ep.Addresses {
+				addr := d.cfg.Protocol + "@" + net.JoinHostPort(ip, strconv.Itoa(port))
+				if seen[addr] {
+					continue
+				}
+				seen[addr] = true
+				item := registryServer{Addr: addr}
+				if ep.Zone != "" || ep.NodeName != "" {
+					item.Metadata = map[string]string{"zone": ep.Zone, "node": ep.NodeName}
+				}
+				items = append(items, item)
+			}
+		}
+	}
+	sortServers(items)
+	return items
+}
+
+// Watch 通过 API Server 的 watch 接口跟踪 EndpointSlice 变化 变化时通知订阅者
+// 连接断开后重新 list 再 watch 返回事件通道和停止的函数
+func (d *KubernetesDiscovery) Watch() (<-chan Event, func()) {
+	events, unwatch := d.MultiServersDiscovery.Watch()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		retry := watchRetryMin
+		for {
+			err := d.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			gorpc.Logf(gorpc.LevelWarn, "rpc discovery: kubernetes watch err: %v", err)
+			select {
+			case <-time.After(retry):
+			case <-ctx.Done():
+				return
+			}
+			if retry *= 2; retry > watchRetryMax {
+				retry = watchRetryMax
+			}
+		}
+	}()
+	return events, func() {
+		cancel()
+		unwatch()
+	}
+}
+
+func (d *KubernetesDiscovery) watch(ctx context.Context) error {
+	slices, version, err := d.list(ctx)
+	if err != nil {
+		return err
+	}
+	d.slicesMu.Lock()
+	d.slices = make(map[string]*endpointSlice, len(slices))
+	for _, s := range slices {
+		d.slices[s.Metadata.Name] = s
+	}
+	d.slicesMu.Unlock()
+	d.apply()
+
+	resp, err := d.request(ctx, url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var event struct {
+			Type   string         `json:"type"`
+			Object *endpointSlice `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			return err
+		}
+		if event.Object == nil {
+			continue
+		}
+		d.slicesMu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			d.slices[event.Object.Metadata.Name] = event.Object
+		case "DELETED":
+			delete(d.slices, event.Object.Metadata.Name)
+		case "ERROR":
+			d.slicesMu.Unlock()
+			// 例如 410 Gone resourceVersion 过旧 需要重新 list
+			return errors.New("rpc discovery: kubernetes watch error event")
+		}
+		d.slicesMu.Unlock()
+		d.apply()
+	}
+}
+
+// apply 用当前的 EndpointSlice 更新服务列表
+func (d *KubernetesDiscovery) apply() {
+	d.slicesMu.Lock()
+	slices := make([]*endpointSlice, 0, len(d.slices))
+	for _, s := range d.slices {
+		slices = append(slices, s)
+	}
+	d.slicesMu.Unlock()
+	d.touch()
+	d.set(d.instances(slices))
+}
@@ -0,0 +1,150 @@
+package xclient
+
+import (
+	"encoding/json"
+	"errors"
+	"gorpc"
+	"gorpc/registry"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 检查过期实例的间隔
+const multicastSweepInterval = time.Second
+
+// MulticastDiscovery 监听局域网组播公告的服务发现 服务端通过 registry.Announce 发送公告
+type MulticastDiscovery struct {
+	*MultiServersDiscovery
+	conn *net.UDPConn
+
+	instancesMu sync.Mutex
+	instances   map[string]multicastInstance
+	// 当前生效的实例列表 未变化时不替换 保留负载均衡状态
+	items []registryServer
+}
+
+type multicastInstance struct {
+	item   registryServer
+	expire time.Time
+}
+
+// NewMulticastDiscovery 加入组播组并开始接收公告 group 为空时使用 registry.DefaultMulticastGroup
+// group 不是组播地址时直接监听该地址 便于在单机或不支持组播的网络中调试
+func NewMulticastDiscovery(group string) (*MulticastDiscovery, error) {
+	if group == "" {
+		group = registry.DefaultMulticastGroup
+	}
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &MulticastDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		conn:                  conn,
+		instances:             make(map[string]multicastInstance),
+	}
+	go d.receive()
+	return d, nil
+}
+
+// Addr 返回监听的地址
+func (d *MulticastDiscovery) Addr() net.Addr {
+	return d.conn.LocalAddr()
+}
+
+// Close 停止接收公告
+func (d *MulticastDiscovery) Close() error {
+	return d.conn.Close()
+}
+
+// Refresh 移除超过 TTL 未收到公告的实例
+func (d *MulticastDiscovery) Refresh() error {
+	d.instancesMu.Lock()
+	d.applyLocked(time.Now())
+	return nil
+}
+
+func (d *MulticastDiscovery) receive() {
+	buf := make([]byte, registry.MaxAnnouncementSize)
+	for {
+		_ = d.conn.SetReadDeadline(time.Now().Add(multicastSweepInterval))
+		n, _, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				_ = d.Refresh()
+				continue
+			}
+			return
+		}
+		var a registry.Announcement
+		if err := json.Unmarshal(buf[:n], &a); err != nil || a.Addr == "" {
+			gorpc.Logf(gorpc.LevelWarn, "rpc discovery: invalid announcement: %v", err)
+			continue
+		}
+		d.handle(&a)
+	}
+}
+
+// handle 处理一条公告
+func (d *MulticastDiscovery) handle(a *registry.Announcement) {
+	d.instancesMu.Lock()
+	now := time.Now()
+	if a.Leave {
+		delete(d.instances, a.Addr)
+	} else {
+		d.instances[a.Addr] = multicastInstance{
+			item: registryServer{
+				Addr:     a.Addr,
+				Weight:   a.Weight,
+				Services: a.Services,
+				Metadata: a.Metadata,
+				State:    a.State,
+			},
+			expire: now.Add(a.TTL),
+		}
+	}
+	d.applyLocked(now)
+}
+
+// applyLocked 移除过期实例并更新服务列表 列表变化时通知订阅者
+// 调用时需持有 instancesMu 返回前释放 通知时不持有锁
+func (d *MulticastDiscovery) applyLocked(now time.Time) {
+	items := make([]registryServer, 0, len(d.instances))
+	for addr, inst := range d.instances {
+		if now.After(inst.expire) {
+			delete(d.instances, addr)
+			continue
+		}
+		items = append(items, inst.item)
+	}
+	sortServers(items)
+	if reflect.DeepEqual(items, d.items) {
+		d.instancesMu.Unlock()
+		return
+	}
+	d.items = items
+	d.mu.Lock()
+	old := d.servers
+	d.replace(items)
+	servers := d.servers
+	d.mu.Unlock()
+	d.instancesMu.Unlock()
+	if !reflect.DeepEqual(old, servers) {
+		d.notify(servers)
+	} else {
+		// 实例不变 权重或元数据可能变化
+		d.emit()
+	}
+}
@@ -0,0 +1,77 @@
+package xclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorpc/registry"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NacosDiscovery 从 Nacos 拉取服务实例 与 registry.RegisterNacos 配合使用
+// 实例元数据中的 protocol 与 services 还原为 protocol@addr 及服务名
+type NacosDiscovery struct {
+	*pollingDiscovery
+	cfg registry.NacosConfig
+}
+
+// NewNacosDiscovery 初始化 timeout 为服务列表的过期时间 默认10s
+// 调用 Watch 可在后台定期拉取并通知订阅者
+func NewNacosDiscovery(cfg registry.NacosConfig, timeout time.Duration) *NacosDiscovery {
+	d := &NacosDiscovery{cfg: cfg}
+	d.pollingDiscovery = newPollingDiscovery(timeout, d.fetch)
+	return d
+}
+
+// nacosHost Nacos 实例列表中的一个实例
+type nacosHost struct {
+	IP       string            `json:"ip"`
+	Port     int               `json:"port"`
+	Weight   float64           `json:"weight"`
+	Healthy  bool              `json:"healthy"`
+	Enabled  bool              `json:"enabled"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (d *NacosDiscovery) fetch() ([]registryServer, error) {
+	v := d.cfg.Values()
+	v.Set("healthyOnly", "true")
+	resp, err := http.Get(d.cfg.URL("/v1/ns/instance/list", v))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rpc discovery: nacos list: %s %s", resp.Status, body)
+	}
+	var body struct {
+		Hosts []nacosHost `json:"hosts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	items := make([]registryServer, 0, len(body.Hosts))
+	for _, h := range body.Hosts {
+		if !h.Healthy || !h.Enabled {
+			continue
+		}
+		protocol := h.Metadata["protocol"]
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		items = append(items, registryServer{
+			Addr:     protocol + "@" + net.JoinHostPort(h.IP, strconv.Itoa(h.Port)),
+			Weight:   int(math.Round(h.Weight)),
+			Services: splitServices(h.Metadata["services"]),
+			Metadata: h.Metadata,
+			State:    h.Metadata["state"],
+		})
+	}
+	sortServers(items)
+	return items, nil
+}
@@ -0,0 +1,162 @@
+package xclient
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// EventType 服务列表变化事件的类型
+type EventType int
+
+const (
+	// 新增实例
+	EventAdd EventType = iota
+	// 实例下线
+	EventRemove
+	// 实例的权重 服务名或元数据变化
+	EventUpdate
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "add"
+	case EventRemove:
+		return "remove"
+	case EventUpdate:
+		return "update"
+	}
+	return "unknown"
+}
+
+// Event 服务列表变化事件 EventRemove 时只有 Addr
+type Event struct {
+	Type     EventType
+	Addr     string
+	Weight   int
+	Services []string
+	Metadata map[string]string
+	// 实例状态 例如 StateDraining 为空表示正常
+	State string
+}
+
+func newEvent(t EventType, item registryServer) Event {
+	return Event{Type: t, Addr: item.Addr, Weight: item.Weight, Services: item.Services, Metadata: item.Metadata, State: item.State}
+}
+
+// eventWatcher 一个 Watch 的订阅者 事件在队列中缓冲 慢的订阅者不会阻塞服务列表更新
+type eventWatcher struct {
+	mu    sync.Mutex
+	queue []Event
+	wake  chan struct{}
+	done  chan struct{}
+	ch    chan Event
+}
+
+func (w *eventWatcher) push(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.queue = append(w.queue, events...)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *eventWatcher) run() {
+	defer close(w.ch)
+	for {
+		w.mu.Lock()
+		events := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+		if len(events) == 0 {
+			select {
+			case <-w.wake:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		for _, e := range events {
+			select {
+			case w.ch <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Watch 订阅服务列表的变化事件 首先收到当前每个实例的 EventAdd
+// 返回的函数取消订阅并关闭通道
+func (d *MultiServersDiscovery) Watch() (<-chan Event, func()) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	if len(d.watchers) == 0 {
+		d.watchers = make(map[int]*eventWatcher)
+		d.emitted = make(map[string]registryServer)
+		for _, item := range d.snapshot() {
+			d.emitted[item.Addr] = item
+		}
+	}
+	w := &eventWatcher{wake: make(chan struct{}, 1), done: make(chan struct{}), ch: make(chan Event)}
+	w.push(diffServers(nil, d.emitted))
+	id := d.nextSubID
+	d.nextSubID++
+	d.watchers[id] = w
+	go w.run()
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			d.subMu.Lock()
+			delete(d.watchers, id)
+			d.subMu.Unlock()
+			close(w.done)
+		})
+	}
+}
+
+// emit 对比上次发出事件时的服务列表 向 Watch 的订阅者发送变化事件
+// 调用时不能持有 d.mu
+func (d *MultiServersDiscovery) emit() {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	if len(d.watchers) == 0 {
+		return
+	}
+	current := make(map[string]registryServer, len(d.emitted))
+	for _, item := range d.snapshot() {
+		current[item.Addr] = item
+	}
+	events := diffServers(d.emitted, current)
+	d.emitted = current
+	for _, w := range d.watchers {
+		w.push(events)
+	}
+}
+
+// diffServers 计算从 old 到 current 的变化事件 按地址排序
+func diffServers(old, current map[string]registryServer) []Event {
+	var events []Event
+	for addr, item := range current {
+		prev, ok := old[addr]
+		switch {
+		case !ok:
+			events = append(events, newEvent(EventAdd, item))
+		case !reflect.DeepEqual(prev, item):
+			events = append(events, newEvent(EventUpdate, item))
+		}
+	}
+	for addr := range old {
+		if _, ok := current[addr]; !ok {
+			events = append(events, Event{Type: EventRemove, Addr: addr})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Addr < events[j].Addr })
+	return events
+}
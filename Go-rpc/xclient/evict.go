@@ -0,0 +1,72 @@
+package xclient
+
+import (
+	"container/list"
+	. "gorpc"
+	"time"
+)
+
+// poolEntry 缓存的连接池 按最近使用时间排列在 XClient.ll 中
+type poolEntry struct {
+	rpcAddr  string
+	pool     *Pool
+	lastUsed time.Time
+}
+
+// SetIdleTimeout 超过 idle 没有使用的连接池会被关闭 默认0 表示不关闭
+// 在选择实例时顺带检查 不会额外启动协程
+func (xc *XClient) SetIdleTimeout(idle time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.idleTimeout = idle
+}
+
+// SetMaxClients 缓存的连接池数量上限 超过时关闭最久未使用的 默认0 表示不设限
+// 被关闭的连接池上进行中的调用会返回 ErrShutdown
+func (xc *XClient) SetMaxClients(n int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.maxClients = n
+	xc.evictLocked(time.Now())
+}
+
+// evictLocked 关闭空闲和超出上限的连接池 调用时需持有 xc.mu
+func (xc *XClient) evictLocked(now time.Time) {
+	for e := xc.ll.Back(); e != nil; e = xc.ll.Back() {
+		entry := e.Value.(*poolEntry)
+		idle := xc.idleTimeout > 0 && now.Sub(entry.lastUsed) > xc.idleTimeout
+		full := xc.maxClients > 0 && xc.ll.Len() > xc.maxClients
+		if !idle && !full {
+			return
+		}
+		xc.removeElement(e)
+	}
+}
+
+// removeElement 从缓存中删除并关闭连接池 调用时需持有 xc.mu
+func (xc *XClient) removeElement(e *list.Element) {
+	entry := e.Value.(*poolEntry)
+	xc.ll.Remove(e)
+	delete(xc.clients, entry.rpcAddr)
+	_ = entry.pool.Close()
+}
+
+// subscriber 支持订阅服务列表更新的服务发现
+type subscriber interface {
+	Subscribe(fn func(servers []string)) (cancel func())
+}
+
+// prune 关闭已不在服务列表中的实例的连接池
+func (xc *XClient) prune(servers []string) {
+	alive := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		alive[s] = true
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for rpcAddr, e := range xc.clients {
+		if !alive[rpcAddr] {
+			xc.removeElement(e)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "gorpc"
+	"reflect"
+	"time"
+)
+
+// FailMode 调用失败时的处理策略
+type FailMode int
+
+const (
+	// Failfast 直接返回错误 默认
+	Failfast FailMode = iota
+	// Failover 换一个实例重试 最多重试 retries 次
+	Failover
+	// Failtry 在同一个实例上退避重试 最多重试 retries 次
+	Failtry
+	// Failbackup 超过 backupDelay 未返回时 向另一个实例发送相同请求 取先返回的结果
+	Failbackup
+)
+
+const (
+	// Failtry 第一次重试前的等待时间 之后每次翻倍
+	failtryBackoff = time.Millisecond * 10
+	// Failtry 最大等待时间
+	failtryMaxBackoff = time.Second
+	// Failbackup 默认的等待时间
+	defaultBackupDelay = time.Millisecond * 10
+)
+
+// SetFailMode 设置调用失败时的处理策略 以及最大重试次数
+func (xc *XClient) SetFailMode(mode FailMode, retries int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.failMode = mode
+	xc.retries = retries
+}
+
+// SetBackupDelay 设置 Failbackup 发送备份请求前的等待时间
+func (xc *XClient) SetBackupDelay(d time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.backupDelay = d
+}
+
+func (xc *XClient) failPolicy() (FailMode, int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.failMode, xc.retries
+}
+
+// retryable 判断错误是否可以换实例重试
+// ctx 已结束 或服务端已处理该请求(返回了业务错误)时不重试
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *ServerError
+	if errors.As(err, &se) {
+		// 该实例没有注册服务 其他实例可能有
+		return errors.Is(err, ErrServiceNotFound)
+	}
+	// 连接错误、连接关闭、客户端限流等
+	return true
+}
+
+// failover 调用失败时 换一个没有尝试过的实例重试
+func (xc *XClient) failover(ctx context.Context, retries int, serviceMethod string, args, reply interface{}) error {
+	rpcAddr, err := xc.selectServer(ctx)
+	if err != nil {
+		return err
+	}
+	tried := make(map[string]bool)
+	for i := 0; ; i++ {
+		tried[rpcAddr] = true
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		if err == nil || i >= retries || !retryable(ctx, err) || !xc.allowRetry() {
+			return err
+		}
+		next, e := xc.selectUntried(ctx, tried)
+		if e != nil {
+			// 没有其他可用实例 返回最后一次的错误
+			return err
+		}
+		rpcAddr = next
+	}
+}
+
+// failtry 调用失败时 退避后在同一个实例上重试
+func (xc *XClient) failtry(ctx context.Context, retries int, serviceMethod string, args, reply interface{}) error {
+	rpcAddr, err := xc.selectServer(ctx)
+	if err != nil {
+		return err
+	}
+	backoff := failtryBackoff
+	for i := 0; ; i++ {
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		if err == nil || i >= retries || !retryable(ctx, err) || !xc.allowRetry() {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > failtryMaxBackoff {
+			backoff = failtryMaxBackoff
+		}
+	}
+}
+
+// failbackup 第一个实例超过 backupDelay 未返回或已失败时 向另一个实例发送备份请求
+// 取最先成功的结果 并取消另一个请求
+func (xc *XClient) failbackup(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	rpcAddr, err := xc.selectServer(ctx)
+	if err != nil {
+		return err
+	}
+	xc.mu.Lock()
+	delay := xc.backupDelay
+	xc.mu.Unlock()
+	if delay <= 0 {
+		delay = defaultBackupDelay
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	// 缓存信道 被取消的请求返回后不会阻塞
+	ch := make(chan result, 2)
+	send := func(rpcAddr string) {
+		// 每个请求使用独立的reply 防止并发写入
+		var r interface{}
+		if reply != nil {
+			r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+		}
+		go func() {
+			ch <- result{reply: r, err: xc.call(rpcAddr, ctx, serviceMethod, args, r)}
+		}()
+	}
+	send(rpcAddr)
+	pending, backup := 1, false
+	sendBackup := func() {
+		backup = true
+		if !xc.allowRetry() {
+			return
+		}
+		if next, err := xc.selectUntried(ctx, map[string]bool{rpcAddr: true}); err == nil {
+			send(next)
+			pending++
+		}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if !backup {
+				sendBackup()
+			}
+		case res := <-ch:
+			pending--
+			if res.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(res.reply).Elem())
+				}
+				return nil
+			}
+			if !backup && retryable(ctx, res.err) {
+				sendBackup()
+			}
+			if pending == 0 {
+				return res.err
+			}
+		}
+	}
+}
+
+// selectUntried 在没有尝试过的实例中随机选择一个
+// 通过 WithTargetServer 固定实例的调用没有其他实例可选
+func (xc *XClient) selectUntried(ctx context.Context, tried map[string]bool) (string, error) {
+	if _, ok := TargetServerFromContext(ctx); ok {
+		return "", ErrNoAvailableServers
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", err
+	}
+	untried := servers[:0]
+	for _, s := range servers {
+		if !tried[s] {
+			untried = append(untried, s)
+		}
+	}
+	if len(untried) == 0 {
+		return "", ErrNoAvailableServers
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return untried[xc.r.Intn(len(untried))], nil
+}
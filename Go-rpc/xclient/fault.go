@@ -0,0 +1,22 @@
+package xclient
+
+import (
+	"context"
+	"gorpc"
+)
+
+// FaultInterceptor 客户端故障注入拦截器 规则见 gorpc.FaultRule
+// 丢弃响应时不发出请求 等待ctx结束 用 UseInstance 添加时规则对每个实例的每次调用生效
+func FaultInterceptor(f *gorpc.FaultInjector) Interceptor {
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		drop, err := f.Inject(ctx, serviceMethod)
+		if err != nil {
+			return err
+		}
+		if drop {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return next(ctx, serviceMethod, args, reply)
+	}
+}
@@ -0,0 +1,58 @@
+package xclient
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// 每个服务实例在哈希环上的虚拟节点数
+const defaultReplicas = 100
+
+// hashRing 一致性哈希环
+type hashRing struct {
+	// 已排序的虚拟节点哈希值
+	keys []uint32
+	// k:v -> 虚拟节点哈希值:服务地址
+	nodes map[uint32]string
+}
+
+// newHashRing 根据服务列表构建哈希环
+func newHashRing(servers []string, replicas int) *hashRing {
+	r := &hashRing{nodes: make(map[uint32]string, len(servers)*replicas)}
+	for _, s := range servers {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + s))
+			r.keys = append(r.keys, h)
+			r.nodes[h] = s
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+	return r
+}
+
+// get 顺时针找到第一个虚拟节点 对应的服务地址
+func (r *hashRing) get(key string) string {
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	// 超出最后一个节点 回到环的起点
+	return r.nodes[r.keys[idx%len(r.keys)]]
+}
+
+type hashKey struct{}
+
+// WithHashKey 设置一致性哈希使用的key 例如用户ID
+// 相同key的请求在服务列表不变时总是发往同一个实例
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext 获取ctx中的一致性哈希key
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
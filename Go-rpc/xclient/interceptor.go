@@ -0,0 +1,58 @@
+package xclient
+
+import "context"
+
+// Invoker 执行一次调用
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// Interceptor 拦截器 在 next 前后加入重试、指标、链路追踪等逻辑
+// 不调用 next 即可中断调用
+type Interceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error
+
+// Use 添加在选择实例之前执行的拦截器 按添加顺序由外到内执行
+// 对 Call、Broadcast、BroadcastDetailed、Fork 的每次调用执行一次
+func (xc *XClient) Use(interceptors ...Interceptor) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.interceptors = append(xc.interceptors, interceptors...)
+}
+
+// UseInstance 添加调用单个实例时执行的拦截器
+// 广播、重试时对每个实例的每次调用都会执行 ctx 中 TargetServerFromContext 返回该实例地址
+func (xc *XClient) UseInstance(interceptors ...Interceptor) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.instanceInterceptors = append(xc.instanceInterceptors, interceptors...)
+}
+
+// chain 将拦截器依次包装在 invoker 外层
+func chain(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// invoke 经过选择实例之前的拦截器后执行 invoker
+func (xc *XClient) invoke(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+	xc.mu.Lock()
+	interceptors := xc.interceptors
+	xc.mu.Unlock()
+	return chain(interceptors, invoker)(ctx, serviceMethod, args, reply)
+}
+
+// invokeInstance 经过单个实例的拦截器后调用该实例
+func (xc *XClient) invokeInstance(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	xc.mu.Lock()
+	interceptors := xc.instanceInterceptors
+	xc.mu.Unlock()
+	if len(interceptors) == 0 {
+		return xc.callInstance(rpcAddr, ctx, serviceMethod, args, reply)
+	}
+	return chain(interceptors, func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return xc.callInstance(rpcAddr, ctx, serviceMethod, args, reply)
+	})(WithTargetServer(ctx, rpcAddr), serviceMethod, args, reply)
+}
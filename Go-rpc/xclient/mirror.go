@@ -0,0 +1,81 @@
+package xclient
+
+import (
+	"context"
+	. "gorpc"
+	"reflect"
+	"time"
+)
+
+const (
+	// 镜像请求的超时 Option.CallTimeout 未设置时使用
+	defaultMirrorTimeout = 5 * time.Second
+	// 同时进行的镜像请求上限 超过时不再镜像 避免影子实例变慢拖累客户端
+	maxMirrorInFlight = 64
+)
+
+// mirror 流量镜像配置
+type mirror struct {
+	rpcAddr string
+	// 镜像的流量比例 0~1
+	percent  float64
+	pool     *Pool
+	inFlight chan struct{}
+}
+
+// SetMirror 将 percent(0~1) 比例的 Call 异步复制一份发往 rpcAddr 响应被丢弃
+// 用于在切换前用真实流量验证新实现 percent 不大于0或 rpcAddr 为空时关闭
+// 镜像请求使用独立的连接 不影响负载均衡、统计和重试
+func (xc *XClient) SetMirror(rpcAddr string, percent float64) {
+	xc.mu.Lock()
+	old := xc.mirror
+	xc.mirror = nil
+	if rpcAddr != "" && percent > 0 {
+		xc.mirror = &mirror{
+			rpcAddr:  rpcAddr,
+			percent:  percent,
+			pool:     NewPool(rpcAddr, xc.opt),
+			inFlight: make(chan struct{}, maxMirrorInFlight),
+		}
+	}
+	xc.mu.Unlock()
+	if old != nil {
+		_ = old.pool.Close()
+	}
+}
+
+// mirrorCall 按比例异步发送镜像请求 携带原请求的元数据
+func (xc *XClient) mirrorCall(ctx context.Context, serviceMethod string, args, reply interface{}) {
+	xc.mu.Lock()
+	m := xc.mirror
+	hit := m != nil && xc.r.Float64() < m.percent
+	xc.mu.Unlock()
+	if !hit || reply == nil || reflect.TypeOf(reply).Kind() != reflect.Ptr {
+		return
+	}
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		return
+	}
+	timeout := defaultMirrorTimeout
+	if xc.opt != nil && xc.opt.CallTimeout > 0 {
+		timeout = xc.opt.CallTimeout
+	}
+	// 原请求结束后镜像请求仍在进行 不继承原ctx的取消
+	mctx := context.Background()
+	if md, ok := FromOutgoingContext(ctx); ok {
+		mctx = NewOutgoingContext(mctx, md)
+	}
+	shadow := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	go func() {
+		defer func() { <-m.inFlight }()
+		mctx, cancel := context.WithTimeout(mctx, timeout)
+		defer cancel()
+		client, err := m.pool.Get()
+		if err != nil {
+			return
+		}
+		_ = client.Call(mctx, serviceMethod, args, shadow)
+	}()
+}
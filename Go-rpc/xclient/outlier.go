@@ -0,0 +1,172 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "gorpc"
+	"time"
+)
+
+// 摘除时长最多为基础时长的倍数
+const maxEjectionMultiplier = 10
+
+// health 实例的摘除状态 由 instanceStats.mu 保护
+type health struct {
+	// 连续失败次数
+	consecutive int
+	// 累计被摘除的次数 摘除时长随之增加
+	ejections int
+	// 摘除结束时间
+	ejectedUntil time.Time
+	// 逐步恢复结束时间 期间按比例放入流量
+	recoverUntil time.Time
+}
+
+// SetOutlierEjection 开启异常实例摘除
+// 连续失败 consecutiveErrors 次的实例在 ejection*摘除次数 内不会被选中
+// 之后在同样长的时间内逐步恢复流量 consecutiveErrors 不大于0时关闭
+func (xc *XClient) SetOutlierEjection(consecutiveErrors int, ejection time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.ejectThreshold = consecutiveErrors
+	xc.ejectDuration = ejection
+}
+
+// isFailure 判断错误是否说明实例不健康
+// 服务端返回的业务错误和调用方取消不计入 超时计入
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrCanceled) {
+		return false
+	}
+	var se *ServerError
+	if errors.As(err, &se) {
+		return errors.Is(err, ErrTimeout)
+	}
+	return true
+}
+
+// observe 记录一次调用结果 连续失败达到阈值时摘除实例
+func (xc *XClient) observe(s *instanceStats, err error) {
+	xc.mu.Lock()
+	threshold, base := xc.ejectThreshold, xc.ejectDuration
+	xc.mu.Unlock()
+	if threshold <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := &s.health
+	now := time.Now()
+	if !isFailure(err) {
+		h.consecutive = 0
+		// 完全恢复后 重置摘除次数
+		if h.ejections > 0 && now.After(h.recoverUntil) {
+			h.ejections = 0
+		}
+		return
+	}
+	h.consecutive++
+	if h.consecutive < threshold || now.Before(h.ejectedUntil) {
+		return
+	}
+	if h.ejections < maxEjectionMultiplier {
+		h.ejections++
+	}
+	d := base * time.Duration(h.ejections)
+	h.ejectedUntil = now.Add(d)
+	h.recoverUntil = h.ejectedUntil.Add(d)
+	h.consecutive = 0
+}
+
+// admitted 判断实例当前是否可以被选中
+// 恢复期和慢启动预热期内被选中的概率随时间线性增加
+func (xc *XClient) admitted(rpcAddr string) bool {
+	if !xc.permitted(rpcAddr) {
+		return false
+	}
+	s := xc.instance(rpcAddr)
+	s.mu.Lock()
+	now := time.Now()
+	ejectedUntil, recoverUntil := s.health.ejectedUntil, s.health.recoverUntil
+	s.mu.Unlock()
+	if now.After(recoverUntil) {
+		return true
+	}
+	if now.Before(ejectedUntil) {
+		return false
+	}
+	p := float64(now.Sub(ejectedUntil)) / float64(recoverUntil.Sub(ejectedUntil))
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.r.Float64() < p
+}
+
+// reselect 选中的实例被摘除时 在未被摘除的实例中按同样的负载均衡模式重新选择
+// 一致性哈希下 被摘除实例上的key落到剩余实例构成的哈希环上
+// 所有实例都被摘除时仍然使用原来的结果 避免无法调用
+func (xc *XClient) reselect(ctx context.Context, mode SelectMode, rpcAddr string) string {
+	if xc.admitted(rpcAddr) {
+		return rpcAddr
+	}
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return rpcAddr
+	}
+	admitted := servers[:0]
+	for _, s := range servers {
+		if s != rpcAddr && xc.admitted(s) {
+			admitted = append(admitted, s)
+		}
+	}
+	if next, err := xc.selectFrom(ctx, mode, admitted); err == nil {
+		return next
+	}
+	return rpcAddr
+}
+
+// SetHealthCheck 开启主动健康检查 每隔 interval 向所有实例发送一次Ping
+// 结果与正常调用一样计入异常实例摘除 interval 不大于0时关闭
+func (xc *XClient) SetHealthCheck(interval time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.stopHealthCheck != nil {
+		close(xc.stopHealthCheck)
+		xc.stopHealthCheck = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	xc.stopHealthCheck = stop
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				xc.checkHealth(interval)
+			}
+		}
+	}()
+}
+
+// checkHealth 并发Ping所有实例
+func (xc *XClient) checkHealth(timeout time.Duration) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return
+	}
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			client, err := xc.dial(rpcAddr)
+			if err == nil {
+				err = client.Ping(ctx)
+			}
+			xc.observe(xc.instance(rpcAddr), err)
+		}(rpcAddr)
+	}
+}
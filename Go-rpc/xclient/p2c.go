@@ -0,0 +1,123 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// EWMA 的衰减系数 越大越偏向最近的调用
+	ewmaAlpha = 0.3
+	// 失败调用按该延迟计入 避免快速失败的实例被误认为"快"
+	errorPenalty = time.Second
+)
+
+// instanceStats 单个服务实例的调用统计
+type instanceStats struct {
+	mu sync.Mutex
+	// 延迟的指数加权移动平均 单位ns
+	latency float64
+	// 错误率的指数加权移动平均 0~1
+	errRate float64
+	// 正在进行的调用数
+	inflight int64
+	// 是否已有样本
+	sampled bool
+	// 累计调用数、失败数和耗时
+	calls        uint64
+	errors       uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	// 异常摘除状态
+	health health
+	// 并发名额 SetMaxConcurrency 开启时创建
+	slots chan struct{}
+}
+
+func (s *instanceStats) begin() {
+	s.mu.Lock()
+	s.inflight++
+	s.mu.Unlock()
+}
+
+// end 记录一次调用结果
+func (s *instanceStats) end(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	s.calls++
+	s.totalLatency += d
+	if d > s.maxLatency {
+		s.maxLatency = d
+	}
+	var failed float64
+	if err != nil {
+		s.errors++
+		failed = 1
+		if d < errorPenalty {
+			d = errorPenalty
+		}
+	}
+	if !s.sampled {
+		s.latency, s.errRate, s.sampled = float64(d), failed, true
+		return
+	}
+	s.latency = ewmaAlpha*float64(d) + (1-ewmaAlpha)*s.latency
+	s.errRate = ewmaAlpha*failed + (1-ewmaAlpha)*s.errRate
+}
+
+// score 负载评分 越小越好
+// 没有样本的实例评分为0 保证新实例能被选中
+func (s *instanceStats) score() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sampled {
+		return 0
+	}
+	// 错误率接近1时 评分趋于无穷大
+	success := 1 - s.errRate
+	if success < 0.01 {
+		success = 0.01
+	}
+	return (s.latency + 1) * float64(s.inflight+1) / success
+}
+
+// instance 返回地址对应的统计 没有则新建
+func (xc *XClient) instance(rpcAddr string) *instanceStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	s, ok := xc.stats[rpcAddr]
+	if !ok {
+		s = xc.newInstanceStats()
+		xc.stats[rpcAddr] = s
+	}
+	return s
+}
+
+// p2c 随机选取两个实例 返回评分更低的一个
+func (xc *XClient) p2c(ctx context.Context) (string, error) {
+	servers, err := xc.servers(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch len(servers) {
+	case 0:
+		return "", ErrNoAvailableServers
+	case 1:
+		return servers[0], nil
+	}
+	xc.mu.Lock()
+	i := xc.r.Intn(len(servers))
+	j := xc.r.Intn(len(servers) - 1)
+	xc.mu.Unlock()
+	// 保证 j != i
+	if j >= i {
+		j++
+	}
+	a, b := servers[i], servers[j]
+	if xc.instance(b).score() < xc.instance(a).score() {
+		return b, nil
+	}
+	return a, nil
+}
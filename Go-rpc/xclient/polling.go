@@ -0,0 +1,207 @@
+package xclient
+
+import (
+	"gorpc"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replace 用外部来源(注册中心 Nacos DNS等)返回的实例替换服务列表 调用时需持有锁
+func (d *MultiServersDiscovery) replace(items []registryServer) {
+	d.servers = make([]string, 0, len(items))
+	d.weights = make(map[string]int, len(items))
+	d.services = nil
+	d.metadata = make(map[string]map[string]string, len(items))
+	d.states = nil
+	d.current = nil
+	d.rings = nil
+	for _, item := range items {
+		d.servers = append(d.servers, item.Addr)
+		d.weights[item.Addr] = item.Weight
+		// 未上报服务名的实例 视为提供所有服务
+		if len(item.Services) > 0 {
+			if d.services == nil {
+				d.services = make(map[string][]string)
+			}
+			d.services[item.Addr] = item.Services
+		}
+		if len(item.Metadata) > 0 {
+			d.metadata[item.Addr] = item.Metadata
+		}
+		if item.State != "" && item.State != StateActive {
+			if d.states == nil {
+				d.states = make(map[string]string)
+			}
+			d.states[item.Addr] = item.State
+		}
+	}
+}
+
+// snapshot 返回当前实例及其权重 服务名 元数据 与 replace 相对
+func (d *MultiServersDiscovery) snapshot() []registryServer {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	items := make([]registryServer, 0, len(d.servers))
+	for _, addr := range d.servers {
+		items = append(items, registryServer{
+			Addr:     addr,
+			Weight:   d.weights[addr],
+			Services: d.services[addr],
+			Metadata: d.metadata[addr],
+			State:    d.states[addr],
+		})
+	}
+	return items
+}
+
+// sortServers 按地址排序 使列表顺序稳定
+func sortServers(items []registryServer) {
+	sort.Slice(items, func(i, j int) bool { return items[i].Addr < items[j].Addr })
+}
+
+// pollingDiscovery 定期从外部来源拉取服务列表的服务发现
+// 选择实例前先检查列表是否过期 过期则重新拉取 列表变化时通知订阅者
+type pollingDiscovery struct {
+	*MultiServersDiscovery
+	// 拉取服务列表
+	fetch func() ([]registryServer, error)
+	// 列表过期时间
+	timeout time.Duration
+	// 串行化拉取 拉取期间不持有 d.mu 不阻塞选择
+	fetchMu    sync.Mutex
+	lastUpdate time.Time
+}
+
+func newPollingDiscovery(timeout time.Duration, fetch func() ([]registryServer, error)) *pollingDiscovery {
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	return &pollingDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		fetch:                 fetch,
+		timeout:               timeout,
+	}
+}
+
+// Refresh 列表过期时重新拉取
+func (d *pollingDiscovery) Refresh() error {
+	d.fetchMu.Lock()
+	defer d.fetchMu.Unlock()
+	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+		return nil
+	}
+	items, err := d.fetch()
+	if err != nil {
+		gorpc.Logf(gorpc.LevelWarn, "rpc discovery: refresh err: %v", err)
+		return err
+	}
+	d.lastUpdate = time.Now()
+	d.set(items)
+	return nil
+}
+
+// set 替换服务列表 列表变化时通知订阅者
+func (d *pollingDiscovery) set(items []registryServer) {
+	d.mu.Lock()
+	old := d.servers
+	d.replace(items)
+	servers := d.servers
+	d.mu.Unlock()
+	if !reflect.DeepEqual(old, servers) {
+		d.notify(servers)
+	} else {
+		// 实例不变 权重或元数据可能变化
+		d.emit()
+	}
+}
+
+// touch 记录一次来自推送的更新 推迟下一次按需拉取
+func (d *pollingDiscovery) touch() {
+	d.fetchMu.Lock()
+	d.lastUpdate = time.Now()
+	d.fetchMu.Unlock()
+}
+
+// Watch 在后台每 timeout 拉取一次服务列表 并订阅变化事件 变化时同时通知 Subscribe 的订阅者
+// 不调用 Watch 时只在选择实例时按需拉取 返回事件通道和停止的函数
+func (d *pollingDiscovery) Watch() (<-chan Event, func()) {
+	events, cancel := d.MultiServersDiscovery.Watch()
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(d.timeout)
+		defer t.Stop()
+		for {
+			_ = d.Refresh()
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// Update 手动更新服务列表 直到下一次拉取
+func (d *pollingDiscovery) Update(servers []string) error {
+	d.fetchMu.Lock()
+	d.lastUpdate = time.Now()
+	d.fetchMu.Unlock()
+	return d.MultiServersDiscovery.Update(servers)
+}
+
+// Get 根据负载均衡策略 选择服务实例
+func (d *pollingDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+// GetByKey 根据key在哈希环上选择实例
+func (d *pollingDiscovery) GetByKey(key string) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetByKey(key)
+}
+
+// GetAll 返回全部服务实例
+func (d *pollingDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+// GetService 在提供该服务的实例中选择
+func (d *pollingDiscovery) GetService(serviceName string, mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetService(serviceName, mode)
+}
+
+// GetServiceByKey 在提供该服务的实例中按一致性哈希选择
+func (d *pollingDiscovery) GetServiceByKey(serviceName, key string) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.GetServiceByKey(serviceName, key)
+}
+
+// GetAllService 返回提供该服务的所有实例
+func (d *pollingDiscovery) GetAllService(serviceName string) ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllService(serviceName)
+}
@@ -0,0 +1,66 @@
+package xclient
+
+import (
+	"sync"
+	"time"
+)
+
+// SetPreconnect 开启预连接 每隔 interval 检查一次服务列表
+// 在后台为新发现的实例建立连接 最多同时建立 concurrency 个
+// 第一次请求不再承担建立连接的延迟 interval 不大于0时关闭
+func (xc *XClient) SetPreconnect(interval time.Duration, concurrency int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.stopPreconnect != nil {
+		close(xc.stopPreconnect)
+		xc.stopPreconnect = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	stop := make(chan struct{})
+	xc.stopPreconnect = stop
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			xc.preconnect(concurrency)
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// preconnect 为还没有连接池的实例建立连接
+func (xc *XClient) preconnect(concurrency int) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return
+	}
+	xc.mu.Lock()
+	fresh := servers[:0]
+	for _, rpcAddr := range servers {
+		if _, ok := xc.clients[rpcAddr]; !ok {
+			fresh = append(fresh, rpcAddr)
+		}
+	}
+	xc.mu.Unlock()
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, rpcAddr := range fresh {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_ = xc.pool(rpcAddr).WarmUp()
+		}(rpcAddr)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Selector 按元数据筛选实例的条件 多个条件以,分隔 全部满足才匹配
+// 支持 = == != >= <= > < 例如 version>=1.4,region=eu
+// 比较运算符两边都是版本号 (如 v1.4.2) 时按版本比较 否则按字符串比较
+// 元数据中没有该键时 只有 != 满足
+type Selector struct {
+	reqs []requirement
+}
+
+type requirement struct {
+	key, op, value string
+}
+
+// 按长度排列 先匹配两个字符的运算符
+var selectorOps = []string{"==", "!=", ">=", "<=", "=", ">", "<"}
+
+// ParseSelector 解析筛选条件 空字符串匹配所有实例
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		i := strings.IndexAny(part, "=!<>")
+		if i <= 0 {
+			return Selector{}, fmt.Errorf("rpc discovery: invalid selector %q", part)
+		}
+		req := requirement{key: strings.TrimSpace(part[:i])}
+		for _, op := range selectorOps {
+			if strings.HasPrefix(part[i:], op) {
+				req.op, req.value = op, strings.TrimSpace(part[i+len(op):])
+				break
+			}
+		}
+		if req.op == "" || strings.ContainsAny(req.value, "=!<>") {
+			return Selector{}, fmt.Errorf("rpc discovery: invalid selector %q", part)
+		}
+		sel.reqs = append(sel.reqs, req)
+	}
+	return sel, nil
+}
+
+// MustParseSelector 解析筛选条件 出错时 panic 用于常量条件
+func MustParseSelector(s string) Selector {
+	sel, err := ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// Empty 没有任何条件
+func (s Selector) Empty() bool {
+	return len(s.reqs) == 0
+}
+
+// Match 元数据是否满足全部条件
+func (s Selector) Match(metadata map[string]string) bool {
+	for _, req := range s.reqs {
+		if !req.match(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s.reqs))
+	for i, req := range s.reqs {
+		parts[i] = req.key + req.op + req.value
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r requirement) match(metadata map[string]string) bool {
+	v, ok := metadata[r.key]
+	if !ok {
+		return r.op == "!="
+	}
+	switch r.op {
+	case "=", "==":
+		return v == r.value
+	case "!=":
+		return v != r.value
+	}
+	c := compareVersion(v, r.value)
+	switch r.op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c < 0
+	}
+}
+
+// compareVersion 按 . 分隔的数字逐段比较版本号 允许 v 前缀 缺少的段视为0
+// 任意一边不是版本号时按字符串比较
+func compareVersion(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(s string) ([]int, bool) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// GetFiltered 返回元数据满足 selector 的所有实例
+func (d *MultiServersDiscovery) GetFiltered(selector string) ([]string, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var servers []string
+	for _, s := range d.servers {
+		if sel.Match(d.metadata[s]) {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+// GetFiltered 返回元数据满足 selector 的所有实例
+func (d *pollingDiscovery) GetFiltered(selector string) ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetFiltered(selector)
+}
+
+// GetFiltered 返回元数据满足 selector 的所有实例
+func (d *GoRegistryDiscovery) GetFiltered(selector string) ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetFiltered(selector)
+}
+
+type selectorKey struct{}
+
+// WithSelector 本次调用只发往元数据满足 selector 的实例 没有满足的实例时返回 ErrNoAvailableServers
+// 需要 Discovery 实现 MetadataDiscovery
+func WithSelector(ctx context.Context, selector Selector) context.Context {
+	return context.WithValue(ctx, selectorKey{}, selector)
+}
+
+// SelectorFromContext 获取ctx中的筛选条件
+func SelectorFromContext(ctx context.Context) (Selector, bool) {
+	sel, ok := ctx.Value(selectorKey{}).(Selector)
+	return sel, ok && !sel.Empty()
+}
+
+// selected 实例是否满足本次调用的筛选条件
+func (xc *XClient) selected(ctx context.Context, rpcAddr string) bool {
+	sel, ok := SelectorFromContext(ctx)
+	if !ok {
+		return true
+	}
+	d, ok := xc.d.(MetadataDiscovery)
+	return ok && sel.Match(d.GetMetadata(rpcAddr))
+}
+
+// filterSelected 过滤掉不满足本次调用筛选条件的实例
+func (xc *XClient) filterSelected(ctx context.Context, servers []string) []string {
+	if _, ok := SelectorFromContext(ctx); !ok {
+		return servers
+	}
+	selected := servers[:0]
+	for _, s := range servers {
+		if xc.selected(ctx, s) {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,30 @@
+package xclient
+
+import "time"
+
+// SetSlowStart 新发现的实例在 window 内逐步增加流量 被选中的概率随时间线性增加
+// 调用时已存在的实例不受影响 window 不大于0时关闭
+func (xc *XClient) SetSlowStart(window time.Duration) {
+	servers, _ := xc.d.GetAll()
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	// 已存在的实例直接承担全部流量
+	for _, rpcAddr := range servers {
+		if _, ok := xc.stats[rpcAddr]; !ok {
+			xc.stats[rpcAddr] = new(instanceStats)
+		}
+	}
+	xc.slowStart = window
+}
+
+// newInstanceStats 新实例的统计 开启慢启动时进入预热期 调用时需持有 xc.mu
+// 预热期复用异常摘除的恢复期 由 admitted 按比例放入流量
+func (xc *XClient) newInstanceStats() *instanceStats {
+	s := new(instanceStats)
+	if xc.slowStart > 0 {
+		now := time.Now()
+		s.health.ejectedUntil = now
+		s.health.recoverUntil = now.Add(xc.slowStart)
+	}
+	return s
+}
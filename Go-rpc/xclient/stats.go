@@ -0,0 +1,56 @@
+package xclient
+
+import "time"
+
+// InstanceStats 单个服务实例的调用指标
+type InstanceStats struct {
+	Calls    uint64
+	Errors   uint64
+	InFlight int64
+	// 累计耗时 平均耗时 = TotalLatency / Calls
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	// 延迟的指数加权移动平均 失败的调用至少按1s计入
+	EWMALatency time.Duration
+	// 错误率的指数加权移动平均 0~1
+	ErrorRate float64
+	// 当前是否被异常摘除
+	Ejected bool
+}
+
+// CallHook 每次调用单个实例完成后执行 可以用于接入外部监控
+type CallHook func(rpcAddr, serviceMethod string, d time.Duration, err error)
+
+// SetCallHook 设置调用完成后的回调 在调用协程中同步执行
+func (xc *XClient) SetCallHook(hook CallHook) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.callHook = hook
+}
+
+// Stats 返回每个实例的调用指标 k:v -> 服务地址:指标
+func (xc *XClient) Stats() map[string]InstanceStats {
+	xc.mu.Lock()
+	instances := make(map[string]*instanceStats, len(xc.stats))
+	for rpcAddr, s := range xc.stats {
+		instances[rpcAddr] = s
+	}
+	xc.mu.Unlock()
+	now := time.Now()
+	stats := make(map[string]InstanceStats, len(instances))
+	for rpcAddr, s := range instances {
+		s.mu.Lock()
+		stats[rpcAddr] = InstanceStats{
+			Calls:        s.calls,
+			Errors:       s.errors,
+			InFlight:     s.inflight,
+			TotalLatency: s.totalLatency,
+			MaxLatency:   s.maxLatency,
+			EWMALatency:  time.Duration(s.latency),
+			ErrorRate:    s.errRate,
+			Ejected:      now.Before(s.health.ejectedUntil),
+		}
+		s.mu.Unlock()
+	}
+	return stats
+}
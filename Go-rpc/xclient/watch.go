@@ -0,0 +1,180 @@
+package xclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorpc"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// 推送连接断开后的重连间隔 指数增长
+	watchRetryMin = time.Second
+	watchRetryMax = time.Second * 30
+)
+
+// 长轮询每次最长等待时间
+const longPollWait = time.Second * 30
+
+// Watch 订阅注册中心 /watch 推送的服务列表变化 代替每 timeout 一次的轮询
+// 连接断开期间退回到轮询 并在稍后自动重连 返回事件通道和停止订阅的函数
+func (d *GoRegistryDiscovery) Watch() (<-chan Event, func()) {
+	return d.keep(d.watch)
+}
+
+// LongPoll 以阻塞查询跟踪服务列表变化 适用于不支持 SSE 的代理环境
+// 注册中心在列表变化或等待超时后才返回 返回事件通道和停止跟踪的函数
+func (d *GoRegistryDiscovery) LongPoll() (<-chan Event, func()) {
+	return d.keep(d.longPoll)
+}
+
+// keep 持续运行 follow 出错后按指数退避重试
+func (d *GoRegistryDiscovery) keep(follow func(ctx context.Context) (bool, error)) (<-chan Event, func()) {
+	events, unwatch := d.MultiServersDiscovery.Watch()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		retry := watchRetryMin
+		for {
+			synced, err := follow(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			gorpc.Logf(gorpc.LevelWarn, "rpc registry: watch err: %v", err)
+			if synced {
+				retry = watchRetryMin
+			}
+			select {
+			case <-time.After(retry):
+			case <-ctx.Done():
+				return
+			}
+			if retry *= 2; retry > watchRetryMax {
+				retry = watchRetryMax
+			}
+		}
+	}()
+	return events, func() {
+		cancel()
+		unwatch()
+	}
+}
+
+// watch 连接一个注册中心节点并持续应用推送的变化 直到连接断开
+// synced 表示是否至少应用过一次完整的服务列表
+func (d *GoRegistryDiscovery) watch(ctx context.Context) (synced bool, err error) {
+	d.mu.Lock()
+	registries, active := d.registries(), d.active
+	d.mu.Unlock()
+	err = errors.New("rpc registry: no registry address")
+	var resp *http.Response
+	for i := range registries {
+		n := (active + i) % len(registries)
+		req, _ := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(registries[n], "/")+"/watch", nil)
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("rpc registry: unexpected status %s", resp.Status)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+		d.mu.Lock()
+		d.watching = false
+		d.mu.Unlock()
+	}()
+
+	items := make(map[string]registryServer)
+	var event string
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return synced, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			var item registryServer
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &item); err != nil {
+				return synced, err
+			}
+			switch event {
+			case "add":
+				items[item.Addr] = item
+			case "remove":
+				delete(items, item.Addr)
+			case "sync":
+				d.sync(items)
+				synced = true
+			}
+		}
+	}
+}
+
+// longPoll 连接一个注册中心节点持续进行阻塞查询 直到出错
+func (d *GoRegistryDiscovery) longPoll(ctx context.Context) (synced bool, err error) {
+	d.mu.Lock()
+	registries, active := d.registries(), d.active
+	client := &http.Client{Timeout: longPollWait + d.fetchTimeout}
+	d.mu.Unlock()
+	if len(registries) == 0 {
+		return false, errors.New("rpc registry: no registry address")
+	}
+	registry := registries[active%len(registries)]
+	defer func() {
+		d.mu.Lock()
+		d.watching = false
+		// 下一次从其他节点重试
+		d.active = (active + 1) % len(registries)
+		d.mu.Unlock()
+	}()
+	var query string
+	var last uint64
+	for {
+		items, index, err := fetchJSONBlocking(ctx, client, registry, query)
+		if err != nil {
+			return synced, err
+		}
+		query = fmt.Sprintf("index=%d&wait=%s", index, longPollWait)
+		// 等待超时 列表没有变化
+		if synced && index == last {
+			continue
+		}
+		last = index
+		list := make(map[string]registryServer, len(items))
+		for _, item := range items {
+			list[item.Addr] = item
+		}
+		d.sync(list)
+		synced = true
+	}
+}
+
+// sync 应用一批推送的变化并通知订阅者
+func (d *GoRegistryDiscovery) sync(items map[string]registryServer) {
+	list := make([]registryServer, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sortServers(list)
+	d.mu.Lock()
+	d.apply(list)
+	d.watching = true
+	servers := d.servers
+	d.mu.Unlock()
+	d.notify(servers)
+}
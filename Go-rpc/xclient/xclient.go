@@ -1,11 +1,16 @@
 package xclient
 
 import (
+	"container/list"
 	"context"
+	"errors"
+	"fmt"
 	. "gorpc"
 	"io"
-	"reflect"
+	"log"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 // XClient 支持负载均衡的客户端
@@ -17,58 +22,188 @@ type XClient struct {
 	// 协议选项
 	opt *Option
 	mu  sync.Mutex // protect following
-	// 缓存： 复用socket连接 保存创建好的Client实例
-	clients map[string]*Client
+	// 缓存： 复用socket连接 每个地址一个连接池
+	clients map[string]*list.Element
+	// 按最近使用时间排列 最久未使用的在队尾
+	ll *list.List
+	// 连接池空闲超时 默认0 表示不关闭
+	idleTimeout time.Duration
+	// 连接池数量上限 默认0 表示不设限
+	maxClients int
+	// 每个实例的延迟和错误率统计 用于 P2CSelect
+	stats map[string]*instanceStats
+	r     *rand.Rand
+	// 调用失败时的处理策略
+	failMode FailMode
+	// 最大重试次数
+	retries int
+	// Failbackup 发送备份请求前的等待时间
+	backupDelay time.Duration
+	// 广播时部分失败的处理策略
+	broadcastPolicy BroadcastPolicy
+	// 广播的最大并发数 默认0 表示不设限
+	maxParallel int
+	// 会话保持 默认nil 表示不启用
+	affinity *affinity
+	// 连续失败多少次后摘除实例 默认0 表示不启用
+	ejectThreshold int
+	// 基础摘除时长
+	ejectDuration time.Duration
+	// 关闭后停止主动健康检查
+	stopHealthCheck chan struct{}
+	// 关闭后停止预连接
+	stopPreconnect chan struct{}
+	// 金丝雀分流 默认nil 表示不启用
+	canary *canary
+	// 调用完成后的回调
+	callHook CallHook
+	// 选择实例之前执行的拦截器
+	interceptors []Interceptor
+	// 调用单个实例时执行的拦截器
+	instanceInterceptors []Interceptor
+	// 每个实例的并发上限 默认0 表示不设限
+	maxConcurrency int
+	// 达到并发上限时是否选择其他实例
+	overflow bool
+	// 新实例的预热时长 默认0 表示不启用
+	slowStart time.Duration
+	// 黑名单
+	denied map[string]bool
+	// 白名单 默认nil 表示不限制
+	allowed map[string]bool
+	// 重试预算 默认nil 表示不限制
+	budget *retryBudget
+	// 取消订阅服务列表的更新
+	unsubscribe func()
+	// 流量镜像 默认nil 表示不启用
+	mirror *mirror
 }
 
 var _ io.Closer = (*XClient)(nil)
+var _ Caller = (*XClient)(nil)
 
 // NewXClient 初始化负载均衡客户端
+// Discovery 支持订阅时 实例下线后立即关闭对应的连接
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{
+	xc := &XClient{
 		d:       d,
 		mode:    mode,
 		opt:     opt,
-		clients: make(map[string]*Client)}
+		clients: make(map[string]*list.Element),
+		ll:      list.New(),
+		stats:   make(map[string]*instanceStats),
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if s, ok := d.(subscriber); ok {
+		xc.unsubscribe = s.Subscribe(xc.prune)
+	}
+	return xc
 }
 
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
+	if xc.stopHealthCheck != nil {
+		close(xc.stopHealthCheck)
+		xc.stopHealthCheck = nil
+	}
+	if xc.stopPreconnect != nil {
+		close(xc.stopPreconnect)
+		xc.stopPreconnect = nil
+	}
+	if xc.unsubscribe != nil {
+		xc.unsubscribe()
+		xc.unsubscribe = nil
+	}
+	if xc.mirror != nil {
+		_ = xc.mirror.pool.Close()
+		xc.mirror = nil
+	}
+	for e := xc.ll.Front(); e != nil; e = xc.ll.Front() {
 		//TODO I have no idea how to deal with error, just ignore it.
-		_ = client.Close()
-		delete(xc.clients, key)
+		xc.removeElement(e)
 	}
 	return nil
 }
 
-// dial 复用Client
+// dial 复用Client 同一地址并发的请求只会建立一次连接
 func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+	// 检查是否有缓存的连接池 没有则新建
+	// 连接池内部检查连接是否可用 不可用时重新建立
+	return xc.pool(rpcAddr).Get()
+}
+
+// WarmUp 提前连接所有已发现的服务实例 避免第一次调用承担建立连接的延迟
+// 配合 Option.PingOnConnect 可以在连接后发送一次Ping
+func (xc *XClient) WarmUp() error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var e error
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			if err := xc.pool(rpcAddr).WarmUp(); err != nil {
+				mu.Lock()
+				if e == nil {
+					e = err
+				}
+				mu.Unlock()
+			}
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return e
+}
+
+// pool 返回地址对应的连接池 没有则新建
+func (xc *XClient) pool(rpcAddr string) *Pool {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-
-	// 检查是否有缓存的client
-	// 有则检查是否可用
-	client, ok := xc.clients[rpcAddr]
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
-	}
-	// 没有 则新建 并添加进缓存
-	if client == nil {
-		var err error
-		client, err = XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
-		}
-		xc.clients[rpcAddr] = client
+	now := time.Now()
+	if e, ok := xc.clients[rpcAddr]; ok {
+		entry := e.Value.(*poolEntry)
+		entry.lastUsed = now
+		xc.ll.MoveToFront(e)
+		xc.evictLocked(now)
+		return entry.pool
 	}
-	return client, nil
+	entry := &poolEntry{rpcAddr: rpcAddr, pool: NewPool(rpcAddr, xc.opt), lastUsed: now}
+	xc.clients[rpcAddr] = xc.ll.PushFront(entry)
+	xc.evictLocked(now)
+	return entry.pool
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	release, err := xc.acquire(ctx, rpcAddr)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s := xc.instance(rpcAddr)
+	s.begin()
+	start := time.Now()
+	err = xc.invokeInstance(rpcAddr, ctx, serviceMethod, args, reply)
+	d := time.Since(start)
+	s.end(d, err)
+	xc.observe(s, err)
+	xc.mu.Lock()
+	hook := xc.callHook
+	xc.mu.Unlock()
+	if hook != nil {
+		hook(rpcAddr, serviceMethod, d, err)
+	}
+	if err == nil {
+		xc.bind(ctx, rpcAddr)
+	}
+	return err
+}
+
+func (xc *XClient) callInstance(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	client, err := xc.dial(rpcAddr)
 	if err != nil {
 		return err
@@ -77,53 +212,128 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 	return client.Call(ctx, serviceMethod, args, reply)
 }
 
+// selectServer 根据负载均衡模式选择实例
+// ConsistentHashSelect 使用ctx中 WithHashKey 设置的key
+// ctx 中的 WithTargetServer、会话保持和 WithSelectMode 依次优先
+// 被摘除的异常实例会重新选择 不会选择黑名单或白名单之外 或不满足 WithSelector 的实例
+// WithTargetServer 固定的实例不允许被选中时返回 ErrNoAvailableServers
+func (xc *XClient) selectServer(ctx context.Context) (string, error) {
+	if rpcAddr, ok := TargetServerFromContext(ctx); ok {
+		if !xc.admitted(rpcAddr) {
+			return "", fmt.Errorf("%w: target %s is not admitted", ErrNoAvailableServers, rpcAddr)
+		}
+		return rpcAddr, nil
+	}
+	if rpcAddr, ok := xc.sticky(ctx); ok && xc.admitted(rpcAddr) && xc.selected(ctx, rpcAddr) {
+		return rpcAddr, nil
+	}
+	mode := xc.mode
+	if m, ok := SelectModeFromContext(ctx); ok {
+		mode = m
+	}
+	rpcAddr, err := xc.selectByMode(ctx, mode)
+	if err != nil {
+		return "", err
+	}
+	return xc.enforceAccess(ctx, mode, xc.selectOverflow(ctx, xc.selectCanary(ctx, xc.reselect(ctx, mode, rpcAddr))))
+}
+
+// selectByMode 按负载均衡模式选择实例
+// Discovery 实现了 CandidateDiscovery 时 只在允许被选中的实例中选择
+func (xc *XClient) selectByMode(ctx context.Context, mode SelectMode) (string, error) {
+	if mode == P2CSelect {
+		return xc.p2c(ctx)
+	}
+	key, _ := HashKeyFromContext(ctx)
+	if cd, ok := xc.d.(CandidateDiscovery); ok {
+		servers, err := xc.servers(ctx)
+		if err != nil {
+			return "", err
+		}
+		return cd.SelectFrom(servers, mode, key)
+	}
+	name := serviceFromContext(ctx)
+	sd, ok := xc.d.(ServiceDiscovery)
+	if mode == ConsistentHashSelect {
+		if ok && name != "" {
+			return sd.GetServiceByKey(name, key)
+		}
+		d, ok := xc.d.(HashDiscovery)
+		if !ok {
+			return "", errors.New("rpc xclient: discovery does not support consistent hash")
+		}
+		return d.GetByKey(key)
+	}
+	if ok && name != "" {
+		return sd.GetService(name, mode)
+	}
+	return xc.d.Get(mode)
+}
+
 // Call 封装call()
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	xc.mirrorCall(ctx, serviceMethod, args, reply)
+	return xc.invoke(ctx, serviceMethod, args, reply, xc.balancedCall)
+}
+
+// balancedCall 选择实例并按失败策略调用
+func (xc *XClient) balancedCall(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	ctx = withService(ctx, serviceMethod)
+	xc.depositBudget()
+	mode, retries := xc.failPolicy()
+	switch mode {
+	case Failover:
+		return xc.failover(ctx, retries, serviceMethod, args, reply)
+	case Failtry:
+		return xc.failtry(ctx, retries, serviceMethod, args, reply)
+	case Failbackup:
+		return xc.failbackup(ctx, serviceMethod, args, reply)
+	}
+	rpcAddr, err := xc.selectServer(ctx)
 	if err != nil {
 		return err
 	}
 	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
-// Broadcast 广播服务
-func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := xc.d.GetAll()
-	if err != nil {
-		return err
+// Go 异步调用 负载均衡选择、建立连接和失败策略都在协程中完成
+// 异步调用没有ctx ConsistentHashSelect 下使用空key
+func (xc *XClient) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
 	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	xc.goCall(context.Background(), call, func(call *Call) { done <- call })
+	return call
+}
 
-	var wg sync.WaitGroup
-	// 并发 广播
-	var mu sync.Mutex
-	var e error
-
-	replyDone := reply == nil // if reply is nil, don't need to set value
-	// 确保有错误发生的时候 快速失败
-	ctx, cancel := context.WithCancel(ctx)
-	for _, rpcAddr := range servers {
-		wg.Add(1)
-		go func(rpcAddr string) {
-			defer wg.Done()
-			var clonedReply interface{}
-			if reply != nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
-			// 如果调用成功，则返回其中一个的结果
-			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
-			mu.Lock()
-			// 如果任意一个实例发生错误，则返回其中一个错误
-			if err != nil && e == nil {
-				e = err
-				cancel()
-			}
-			if err == nil && !replyDone {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
-				replyDone = true
-			}
-			mu.Unlock()
-		}(rpcAddr)
+// GoFunc 回调风格的异步接口 调用完成(或ctx取消)后在新的协程中执行 callback
+func (xc *XClient) GoFunc(ctx context.Context, serviceMethod string, args, reply interface{}, callback func(*Call)) *Call {
+	if callback == nil {
+		log.Panic("rpc client: callback is nil")
 	}
-	wg.Wait()
-	return e
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+	}
+	xc.goCall(ctx, call, callback)
+	return call
+}
+
+// goCall 在协程中执行 Call 完成后调用 finish
+func (xc *XClient) goCall(ctx context.Context, call *Call, finish func(*Call)) {
+	go func() {
+		var md Metadata
+		call.Error = xc.Call(WithResponseMetadata(ctx, &md), call.ServiceMethod, call.Args, call.Reply)
+		call.Metadata = md
+		finish(call)
+	}()
 }
@@ -0,0 +1,668 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorpc"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+// Sum 注册为 Foo(1) 时模拟慢实例
+func (f Foo) Sum(args Args, reply *int) error {
+	if f == 1 {
+		time.Sleep(time.Second)
+	}
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// startServer 启动一个注册了 foo 的服务端 返回 tcp@addr
+func startServer(t *testing.T, foo Foo) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "failed to listen: %v", err)
+	t.Cleanup(func() { _ = l.Close() })
+	server := gorpc.NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func record(s *instanceStats, d time.Duration, err error) {
+	s.begin()
+	s.end(d, err)
+}
+
+func TestXClient_P2C(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@fast", "tcp@slow"})
+	xc := NewXClient(d, P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 5; i++ {
+		record(xc.instance("tcp@fast"), time.Millisecond, nil)
+		record(xc.instance("tcp@slow"), time.Millisecond*100, nil)
+	}
+	for i := 0; i < 10; i++ {
+		s, err := xc.p2c(context.Background())
+		_assert(err == nil && s == "tcp@fast", "expect the fast instance, got %s", s)
+	}
+
+	// 错误率高的实例同样会被避开
+	for i := 0; i < 5; i++ {
+		record(xc.instance("tcp@fast"), time.Millisecond, errors.New("boom"))
+	}
+	s, _ := xc.p2c(context.Background())
+	_assert(s == "tcp@slow", "expect the healthy instance, got %s", s)
+}
+
+func TestXClient_Failover(t *testing.T) {
+	addr := startServer(t, 0)
+	// 已注册但不可用的实例
+	d := NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", addr})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failover, 1)
+	for i := 0; i < 4; i++ {
+		var reply int
+		err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "failover call failed: %v", err)
+	}
+
+	// 业务错误不重试
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Nope", Args{}, &reply)
+	_assert(errors.Is(err, gorpc.ErrMethodNotFound), "expect ErrMethodNotFound, got %v", err)
+}
+
+func TestXClient_Failtry(t *testing.T) {
+	// 先占用一个端口再释放 模拟实例重启
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	go func() {
+		time.Sleep(time.Millisecond * 30)
+		l, err := net.Listen("tcp", addr)
+		_assert(err == nil, "failed to listen: %v", err)
+		t.Cleanup(func() { _ = l.Close() })
+		server := gorpc.NewServer()
+		var foo Foo
+		_ = server.Register(&foo)
+		server.Accept(l)
+	}()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@" + addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failtry, 5)
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failtry call failed: %v", err)
+}
+
+func TestXClient_Failbackup(t *testing.T) {
+	slow, fast := startServer(t, 1), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{slow, fast}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failbackup, 0)
+	xc.SetBackupDelay(time.Millisecond * 20)
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		var reply int
+		err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failbackup call failed: %v", err)
+		_assert(time.Since(start) < time.Millisecond*500, "expect the fast instance to answer")
+	}
+}
+
+func TestXClient_BroadcastDetailed(t *testing.T) {
+	addr := startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr, "tcp@127.0.0.1:1"}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	results, err := xc.BroadcastDetailed(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && len(results) == 2, "unexpected broadcast results: %v %v", results, err)
+	ok := results[addr]
+	_assert(ok.Error == nil && *ok.Reply.(*int) == 3 && ok.Duration > 0, "unexpected result from %s: %+v", addr, ok)
+	_assert(results["tcp@127.0.0.1:1"].Error != nil, "expect error from the dead instance")
+	_assert(reply == 0, "reply should only be used as a template")
+}
+
+func TestXClient_BroadcastPolicy(t *testing.T) {
+	a, b, dead := startServer(t, 0), startServer(t, 0), "tcp@127.0.0.1:1"
+	broadcast := func(policy BroadcastPolicy, servers ...string) error {
+		xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetBroadcast(policy, 1)
+		var reply int
+		err := xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err != nil || reply == 3, "expect reply to be set on success")
+		return err
+	}
+	_assert(broadcast(BroadcastFailFast, a, b, dead) != nil, "fail fast should surface the error")
+	_assert(broadcast(BroadcastBestEffort, dead, a) == nil, "best effort should succeed")
+	_assert(broadcast(BroadcastQuorum, a, dead, b) == nil, "2 of 3 should reach quorum")
+	_assert(broadcast(BroadcastQuorum, a, dead, "tcp@127.0.0.1:2") != nil, "1 of 3 should not reach quorum")
+}
+
+func TestXClient_Fork(t *testing.T) {
+	slow, fast := startServer(t, 1), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{slow, "tcp@127.0.0.1:1", fast}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	start := time.Now()
+	var reply int
+	err := xc.Fork(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "fork failed: %v", err)
+	_assert(time.Since(start) < time.Millisecond*500, "expect the fast instance to answer first")
+
+	xc = NewXClient(NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", "tcp@127.0.0.1:2"}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	_assert(xc.ForkN(context.Background(), 1, "Foo.Sum", Args{}, &reply) != nil, "expect error when all instances fail")
+}
+
+func TestXClient_Go(t *testing.T) {
+	addr := startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	// 异步调用同样应用失败策略
+	xc.SetFailMode(Failover, 1)
+	done := make(chan *gorpc.Call, 4)
+	for i := 0; i < 4; i++ {
+		xc.Go("Foo.Sum", Args{Num1: i, Num2: 1}, new(int), done)
+	}
+	for i := 0; i < 4; i++ {
+		call := <-done
+		_assert(call.Error == nil && *call.Reply.(*int) == call.Args.(Args).Num1+1, "async call failed: %v", call.Error)
+	}
+
+	ch := make(chan *gorpc.Call)
+	xc.GoFunc(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), func(call *gorpc.Call) { ch <- call })
+	call := <-ch
+	_assert(call.Error == nil && *call.Reply.(*int) == 3, "callback call failed: %v", call.Error)
+}
+
+func TestXClient_SelectOverride(t *testing.T) {
+	addr := startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failover, 1)
+	var reply int
+	for i := 0; i < 3; i++ {
+		err := xc.Call(WithTargetServer(context.Background(), addr), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "pinned call failed: %v", err)
+	}
+	// 固定的实例不可用时 不会换实例重试
+	err := xc.Call(WithTargetServer(context.Background(), "tcp@127.0.0.1:1"), "Foo.Sum", Args{}, &reply)
+	_assert(err != nil, "expect the pinned instance to fail")
+
+	ctx := WithSelectMode(context.Background(), ConsistentHashSelect)
+	_, err = xc.selectServer(WithHashKey(ctx, "user"))
+	_assert(err == nil, "failed to override select mode: %v", err)
+}
+
+func TestXClient_Affinity(t *testing.T) {
+	a, b := startServer(t, 0), startServer(t, 0)
+	d := NewMultiServerDiscovery([]string{a, b})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetAffinityTTL(time.Minute)
+	ctx := WithSessionKey(context.Background(), "session-1")
+	var reply int
+	_ = xc.Call(ctx, "Foo.Sum", Args{}, &reply)
+	first, _ := xc.sticky(ctx)
+	_assert(first == a || first == b, "expect session to be bound")
+	for i := 0; i < 4; i++ {
+		s, _ := xc.selectServer(ctx)
+		_assert(s == first, "expect sticky instance %s, got %s", first, s)
+	}
+
+	// 实例下线后重新选择
+	other := a
+	if first == a {
+		other = b
+	}
+	_ = d.Update([]string{other})
+	s, _ := xc.selectServer(ctx)
+	_assert(s == other, "expect rebinding after instance removal, got %s", s)
+}
+
+func TestXClient_OutlierEjection(t *testing.T) {
+	addr, dead := startServer(t, 0), "tcp@127.0.0.1:1"
+	xc := NewXClient(NewMultiServerDiscovery([]string{dead, addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetOutlierEjection(2, time.Millisecond*100)
+	var reply int
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if xc.Call(context.Background(), "Foo.Sum", Args{}, &reply) != nil {
+			failed++
+		}
+	}
+	_assert(failed == 2, "expect the dead instance to be ejected after 2 failures, got %d", failed)
+	_assert(!xc.admitted(dead) && xc.admitted(addr), "expect only the dead instance to be ejected")
+
+	// 摘除和恢复期结束后重新放入流量
+	time.Sleep(time.Millisecond * 250)
+	_assert(xc.admitted(dead), "expect the instance to be re-admitted")
+
+	// 主动健康检查同样会摘除实例
+	xc.SetHealthCheck(time.Millisecond * 20)
+	time.Sleep(time.Millisecond * 100)
+	_assert(!xc.admitted(dead), "expect health check to eject the dead instance")
+}
+
+func TestXClient_OutlierEjectionHash(t *testing.T) {
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c"}), ConsistentHashSelect, nil)
+	defer func() { _ = xc.Close() }()
+	picked := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		picked[key], _ = xc.selectServer(WithHashKey(context.Background(), key))
+	}
+	s := xc.instance("tcp@c")
+	s.mu.Lock()
+	s.health.ejectedUntil = time.Now().Add(time.Hour)
+	s.health.recoverUntil = s.health.ejectedUntil
+	s.mu.Unlock()
+
+	// 被摘除实例上的key稳定地落到其余实例 其余key不受影响
+	for key, s := range picked {
+		ctx := WithHashKey(context.Background(), key)
+		s2, err := xc.selectServer(ctx)
+		_assert(err == nil && s2 != "tcp@c", "expect tcp@c to be ejected, got %s %v", s2, err)
+		_assert(s == "tcp@c" || s == s2, "key %s moved from %s to %s", key, s, s2)
+		s3, _ := xc.selectServer(ctx)
+		_assert(s2 == s3, "same key should map to the same server, got %s and %s", s2, s3)
+	}
+}
+
+func TestXClient_Evict(t *testing.T) {
+	a, b, c := startServer(t, 0), startServer(t, 0), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b, c}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetMaxClients(2)
+	var reply int
+	for _, addr := range []string{a, b, a, c} {
+		_ = xc.Call(WithTargetServer(context.Background(), addr), "Foo.Sum", Args{}, &reply)
+	}
+	_, okA := xc.clients[a]
+	_, okB := xc.clients[b]
+	_assert(len(xc.clients) == 2 && okA && !okB, "expect the least recently used client to be evicted")
+
+	xc.SetIdleTimeout(time.Millisecond * 20)
+	time.Sleep(time.Millisecond * 50)
+	_ = xc.Call(WithTargetServer(context.Background(), b), "Foo.Sum", Args{}, &reply)
+	_, okB = xc.clients[b]
+	_assert(len(xc.clients) == 1 && okB, "expect idle clients to be evicted")
+}
+
+func TestXClient_Canary(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c", "tcp@d"})
+	d.UpdateMetadata(map[string]map[string]string{"tcp@d": {"version": "1.1"}})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetCanary("1.1", 0.1)
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		s, err := xc.selectServer(context.Background())
+		_assert(err == nil, "failed to select: %v", err)
+		if s == "tcp@d" {
+			canary++
+		}
+	}
+	_assert(canary > 50 && canary < 150, "expect about 10%% canary traffic, got %d/1000", canary)
+}
+
+func TestXClient_ServiceDiscovery(t *testing.T) {
+	addr := startServer(t, 0)
+	d := NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", addr})
+	// 不可用的实例只提供 Bar 服务
+	d.UpdateServices(map[string][]string{"tcp@127.0.0.1:1": {"Bar"}, addr: {"Foo"}})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply int
+		err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect Foo.Sum to be routed to %s: %v", addr, err)
+	}
+	s, _ := d.GetService("Bar", RandomSelect)
+	_assert(s == "tcp@127.0.0.1:1", "expect Bar instance, got %s", s)
+	_, err := d.GetService("Baz", RandomSelect)
+	_assert(err == ErrNoAvailableServers, "expect no instances for Baz")
+}
+
+func TestXClient_Stats(t *testing.T) {
+	addr, dead := startServer(t, 0), "tcp@127.0.0.1:1"
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr, dead}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var hooked int32
+	xc.SetCallHook(func(rpcAddr, serviceMethod string, d time.Duration, err error) {
+		_assert(serviceMethod == "Foo.Sum", "unexpected method %s", serviceMethod)
+		atomic.AddInt32(&hooked, 1)
+	})
+	var reply int
+	for i := 0; i < 4; i++ {
+		_ = xc.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	}
+	stats := xc.Stats()
+	_assert(stats[addr].Calls == 2 && stats[addr].Errors == 0 && stats[addr].InFlight == 0, "unexpected stats for %s: %+v", addr, stats[addr])
+	_assert(stats[dead].Calls == 2 && stats[dead].Errors == 2 && stats[dead].ErrorRate > 0, "unexpected stats for %s: %+v", dead, stats[dead])
+	_assert(atomic.LoadInt32(&hooked) == 4, "expect hook for every call")
+}
+
+func TestXClient_Preconnect(t *testing.T) {
+	a, b := startServer(t, 0), startServer(t, 0)
+	d := NewMultiServerDiscovery([]string{a})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetPreconnect(time.Millisecond*10, 2)
+	_ = d.Update([]string{a, b})
+	time.Sleep(time.Millisecond * 100)
+	xc.mu.Lock()
+	e, ok := xc.clients[b]
+	xc.mu.Unlock()
+	_assert(ok, "expect new instance to be pre-connected")
+	client, err := e.Value.(*poolEntry).pool.Get()
+	_assert(err == nil && client.IsAvailable(), "expect pre-connected client to be available")
+}
+
+func TestXClient_Interceptor(t *testing.T) {
+	a, b := startServer(t, 0), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var order []string
+	var outer, inner int32
+	xc.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		order = append(order, "first")
+		atomic.AddInt32(&outer, 1)
+		return next(ctx, serviceMethod, args, reply)
+	}, func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		order = append(order, "second")
+		return next(ctx, serviceMethod, args, reply)
+	})
+	xc.UseInstance(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		rpcAddr, _ := TargetServerFromContext(ctx)
+		_assert(rpcAddr == a || rpcAddr == b, "expect instance address in ctx, got %s", rpcAddr)
+		atomic.AddInt32(&inner, 1)
+		return next(ctx, serviceMethod, args, reply)
+	})
+	var reply int
+	_ = xc.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	_assert(len(order) == 2 && order[0] == "first" && order[1] == "second", "unexpected order %v", order)
+	_ = xc.Broadcast(context.Background(), "Foo.Sum", Args{}, &reply)
+	_assert(atomic.LoadInt32(&outer) == 2 && atomic.LoadInt32(&inner) == 3, "unexpected counts %d %d", outer, inner)
+
+	// 拦截器可以中断调用
+	xc.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		return errors.New("denied")
+	})
+	err := xc.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	_assert(err != nil && err.Error() == "denied", "expect interceptor to short-circuit, got %v", err)
+}
+
+func TestXClient_MaxConcurrency(t *testing.T) {
+	slow, fast := startServer(t, 1), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{slow, fast}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetMaxConcurrency(1, false)
+	go func() {
+		var reply int
+		_ = xc.Call(WithTargetServer(context.Background(), slow), "Foo.Sum", Args{}, &reply)
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// 排队等待 超时返回错误
+	ctx, cancel := context.WithTimeout(WithTargetServer(context.Background(), slow), time.Millisecond*50)
+	defer cancel()
+	var reply int
+	err := xc.Call(ctx, "Foo.Sum", Args{}, &reply)
+	_assert(errors.Is(err, gorpc.ErrTooManyPendingCalls), "expect to wait for a slot, got %v", err)
+
+	// 溢出到其他实例
+	xc.SetMaxConcurrency(1, true)
+	go func() {
+		_ = xc.Call(WithTargetServer(context.Background(), slow), "Foo.Sum", Args{}, new(int))
+	}()
+	time.Sleep(time.Millisecond * 50)
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		err = xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && time.Since(start) < time.Millisecond*500, "expect overflow to the fast instance: %v", err)
+	}
+}
+
+func TestXClient_SlowStart(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetSlowStart(time.Millisecond * 200)
+	_ = d.Update([]string{"tcp@a", "tcp@b", "tcp@new"})
+	count := func() int {
+		n := 0
+		for i := 0; i < 300; i++ {
+			if s, _ := xc.selectServer(context.Background()); s == "tcp@new" {
+				n++
+			}
+		}
+		return n
+	}
+	// 预热初期几乎没有流量 预热结束后承担 1/3
+	_assert(count() < 30, "expect little traffic during slow start")
+	time.Sleep(time.Millisecond * 250)
+	n := count()
+	_assert(n > 60 && n < 140, "expect a full share after slow start, got %d/300", n)
+}
+
+func TestXClient_AccessList(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c"})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	selected := func() map[string]bool {
+		m := make(map[string]bool)
+		for i := 0; i < 30; i++ {
+			s, err := xc.selectServer(context.Background())
+			if err == nil {
+				m[s] = true
+			}
+		}
+		return m
+	}
+	xc.Deny("tcp@b")
+	m := selected()
+	_assert(len(m) == 2 && !m["tcp@b"], "expect tcp@b to be denied, got %v", m)
+	xc.SetAllowList([]string{"tcp@b", "tcp@c"})
+	m = selected()
+	_assert(len(m) == 1 && m["tcp@c"], "expect only tcp@c, got %v", m)
+	xc.Deny("tcp@c")
+	_, err := xc.selectServer(context.Background())
+	_assert(err == ErrNoAvailableServers, "expect no available servers, got %v", err)
+	xc.Undeny("tcp@b", "tcp@c")
+	xc.SetAllowList(nil)
+	_assert(len(selected()) == 3, "expect all servers after reset")
+
+	// 黑名单不能通过 WithTargetServer 绕过
+	xc.Deny("tcp@a")
+	_, err = xc.selectServer(WithTargetServer(context.Background(), "tcp@a"))
+	_assert(errors.Is(err, ErrNoAvailableServers), "expect the denied target to be rejected, got %v", err)
+
+	// 一致性哈希在允许的实例上选择 其余实例上的key不受影响
+	xc.Undeny("tcp@a")
+	ctx := WithSelectMode(context.Background(), ConsistentHashSelect)
+	picked := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		picked[key], _ = xc.selectServer(WithHashKey(ctx, key))
+	}
+	xc.Deny("tcp@c")
+	for key, s := range picked {
+		s2, err := xc.selectServer(WithHashKey(ctx, key))
+		_assert(err == nil && s2 != "tcp@c", "expect tcp@c to be denied, got %s %v", s2, err)
+		_assert(s == "tcp@c" || s == s2, "key %s moved from %s to %s", key, s, s2)
+		s3, _ := xc.selectServer(WithHashKey(ctx, key))
+		_assert(s2 == s3, "same key should map to the same server, got %s and %s", s2, s3)
+	}
+}
+
+func TestXClient_QuorumBroadcast(t *testing.T) {
+	a, b, dead := startServer(t, 0), startServer(t, 0), "tcp@127.0.0.1:1"
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, dead, b}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	err := xc.QuorumBroadcast(context.Background(), 2, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 2 of 3 to agree: %v", err)
+	err = xc.QuorumBroadcast(context.Background(), 3, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect quorum of 3 to fail")
+	err = xc.QuorumBroadcast(context.Background(), 4, "Foo.Sum", Args{}, &reply)
+	_assert(errors.Is(err, ErrQuorumNotReached), "expect ErrQuorumNotReached, got %v", err)
+}
+
+func TestXClient_RetryBudget(t *testing.T) {
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@127.0.0.1:1", "tcp@127.0.0.1:2"}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failover, 1)
+	xc.SetRetryBudget(0.2, 0)
+	var retried int32
+	xc.UseInstance(func(ctx context.Context, serviceMethod string, args, reply interface{}, next Invoker) error {
+		atomic.AddInt32(&retried, 1)
+		return next(ctx, serviceMethod, args, reply)
+	})
+	var reply int
+	for i := 0; i < 50; i++ {
+		_ = xc.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	}
+	// 50次调用 最多10次重试
+	n := atomic.LoadInt32(&retried) - 50
+	_assert(n > 0 && n <= 10, "expect retries to be limited to 20%% of calls, got %d", n)
+}
+
+func TestXClient_Map(t *testing.T) {
+	a, b := startServer(t, 0), startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	sum, calls := 0, 0
+	err := xc.Map(context.Background(), "Foo.Sum", map[string]interface{}{
+		a: Args{Num1: 1, Num2: 2},
+		b: Args{Num1: 3, Num2: 4},
+	}, new(int), func(rpcAddr string, reply interface{}, err error) {
+		_assert(err == nil, "call to %s failed: %v", rpcAddr, err)
+		sum += *reply.(*int)
+		calls++
+	})
+	_assert(err == nil && calls == 2 && sum == 10, "unexpected map result: %d %d %v", calls, sum, err)
+}
+
+func TestXClient_PruneRemoved(t *testing.T) {
+	a, b := startServer(t, 0), startServer(t, 0)
+	d := NewMultiServerDiscovery([]string{a, b})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	_ = xc.Broadcast(context.Background(), "Foo.Sum", Args{}, &reply)
+	xc.mu.Lock()
+	client, _ := xc.clients[b].Value.(*poolEntry).pool.Get()
+	xc.mu.Unlock()
+
+	_ = d.Update([]string{a})
+	xc.mu.Lock()
+	_, ok := xc.clients[b]
+	xc.mu.Unlock()
+	_assert(!ok && !client.IsAvailable(), "expect the removed instance to be closed")
+}
+
+func TestXClient_Selector(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b", "tcp@c"})
+	d.UpdateMetadata(map[string]map[string]string{
+		"tcp@a": {"version": "1.3.9", "region": "eu"},
+		"tcp@b": {"version": "v1.10", "region": "eu"},
+		"tcp@c": {"version": "1.4", "region": "us"},
+	})
+	servers, err := d.GetFiltered("version>=1.4, region=eu")
+	_assert(err == nil && fmt.Sprint(servers) == "[tcp@b]", "unexpected filtered servers: %v %v", servers, err)
+	servers, _ = d.GetFiltered("zone!=z1")
+	_assert(len(servers) == 3, "missing key satisfies !=, got %v", servers)
+	_, err = d.GetFiltered("version>>1")
+	_assert(err != nil, "expect invalid selector error")
+
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	ctx := WithSelector(context.Background(), MustParseSelector("version<1.4"))
+	for i := 0; i < 6; i++ {
+		s, err := xc.selectServer(ctx)
+		_assert(err == nil && s == "tcp@a", "expect tcp@a, got %s %v", s, err)
+	}
+	_, err = xc.selectServer(WithSelector(context.Background(), MustParseSelector("region=ap")))
+	_assert(err == ErrNoAvailableServers, "expect no available servers, got %v", err)
+}
+
+func TestXClient_Draining(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+	d.UpdateStates(map[string]string{"tcp@a": StateDraining})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	servers, _ := xc.servers(context.Background())
+	_assert(fmt.Sprint(servers) == "[tcp@b]", "expect draining instance excluded from selection, got %v", servers)
+	servers, _ = xc.allServers(context.Background())
+	_assert(fmt.Sprint(servers) == "[tcp@a tcp@b]", "expect broadcast to reach draining instance, got %v", servers)
+	// 全部处于 draining 时仍然可以选择
+	d.UpdateStates(map[string]string{"tcp@a": StateDraining, "tcp@b": StateDraining})
+	s, err := xc.selectServer(context.Background())
+	_assert(err == nil && s != "", "expect fallback to draining instances: %v", err)
+}
+
+func TestFaultInterceptor(t *testing.T) {
+	a := startServer(t, 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	faults := gorpc.NewFaultInjector(gorpc.FaultRule{Method: "Foo.Sum", Percent: 100, Delay: 20 * time.Millisecond, Error: "injected"})
+	xc.Use(FaultInterceptor(faults))
+	var reply int
+	start := time.Now()
+	err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == "injected" && time.Since(start) >= 20*time.Millisecond, "expect delayed injected error, got %v", err)
+
+	faults.SetRules(gorpc.FaultRule{Method: "Foo.", Percent: 100, Drop: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = xc.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect dropped call to wait for ctx, got %v", err)
+}
+
+func TestXClient_Mirror(t *testing.T) {
+	a := startServer(t, 0)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	shadow := gorpc.NewServer()
+	var foo Foo
+	_ = shadow.Register(&foo)
+	mirrored := make(chan string, 10)
+	shadow.Use(func(next gorpc.Handler) gorpc.Handler {
+		return func(ctx context.Context, call *gorpc.ServerCall) error {
+			mirrored <- call.Metadata["user"]
+			return next(ctx, call)
+		}
+	})
+	go shadow.Accept(l)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{a}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetMirror("tcp@"+l.Addr().String(), 1)
+	var reply int
+	ctx := gorpc.AppendToOutgoingContext(context.Background(), "user", "alice")
+	err := xc.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum")
+	select {
+	case user := <-mirrored:
+		_assert(user == "alice", "expect metadata on mirrored call, got %q", user)
+	case <-time.After(time.Second):
+		_assert(false, "expect call to be mirrored")
+	}
+
+	xc.SetMirror("", 0)
+	_ = xc.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	time.Sleep(50 * time.Millisecond)
+	_assert(len(mirrored) == 0, "expect no mirroring after disabling")
+}